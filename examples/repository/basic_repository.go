@@ -543,7 +543,8 @@ func main() {
 	}
 
 	// Sign root with the new RSA and ECDSA keys
-	outofbandSignerRSA, err := signature.LoadSigner(anotherRootKeyRSA, crypto.SHA256)
+	// RSA keys use the rsassa-pss-sha256 scheme, sign with RSA-PSS
+	outofbandSignerRSA, err := signature.LoadRSAPSSSigner(anotherRootKeyRSA, crypto.SHA256, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
 	if err != nil {
 		panic(fmt.Sprintln("basic_repository.go:", "loading RSA signer failed", err))
 	}
@@ -0,0 +1,50 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metadata
+
+import (
+	"sync/atomic"
+
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+)
+
+// canonicalEncoder holds the CanonicalEncoder set with SetCanonicalEncoder,
+// if any, it is read and written atomically since metadata may be signed
+// and verified concurrently
+var canonicalEncoder atomic.Value
+
+// CanonicalEncoder serializes v to canonical JSON. It is used to build the
+// payload that is signed and verified
+type CanonicalEncoder func(v any) ([]byte, error)
+
+// SetCanonicalEncoder replaces the canonical JSON encoder used for signing
+// and verification. Passing nil restores the default cjson encoder
+func SetCanonicalEncoder(encoder CanonicalEncoder) {
+	if encoder == nil {
+		encoder = cjson.EncodeCanonical
+	}
+	canonicalEncoder.Store(encoder)
+}
+
+// GetCanonicalEncoder returns the canonical JSON encoder used for signing
+// and verification, see SetCanonicalEncoder
+func GetCanonicalEncoder() CanonicalEncoder {
+	if encoder, ok := canonicalEncoder.Load().(CanonicalEncoder); ok {
+		return encoder
+	}
+	return cjson.EncodeCanonical
+}
+
+// encodeCanonical serializes v with the current canonical JSON encoder
+func encodeCanonical(v any) ([]byte, error) {
+	return GetCanonicalEncoder()(v)
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metadata
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCanonicalEncoder(t *testing.T) {
+	// restore the default encoder once done
+	defer SetCanonicalEncoder(nil)
+
+	public, private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	signer, err := signature.LoadSigner(private, crypto.Hash(0))
+	assert.NoError(t, err)
+	key, err := KeyFromPublicKey(public)
+	assert.NoError(t, err)
+
+	root := Root(time.Now().AddDate(0, 0, 1).UTC())
+	err = root.Signed.AddKey(key, ROOT)
+	assert.NoError(t, err)
+
+	// Sign with the default encoder
+	defaultSig, err := root.Sign(signer)
+	assert.NoError(t, err)
+	assert.NoError(t, root.VerifyDelegate(ROOT, root))
+
+	// Sign with a swapped encoder, ed25519 signatures are deterministic
+	// so they differ only if the payload differs
+	SetCanonicalEncoder(func(v any) ([]byte, error) {
+		return json.MarshalIndent(v, "", " ")
+	})
+	root.ClearSignatures()
+	customSig, err := root.Sign(signer)
+	assert.NoError(t, err)
+	assert.NotEqual(t, defaultSig.Signature, customSig.Signature)
+	// verification uses the same swapped encoder
+	assert.NoError(t, root.VerifyDelegate(ROOT, root))
+
+	// verification with the default encoder fails for the custom signature
+	SetCanonicalEncoder(nil)
+	err = root.VerifyDelegate(ROOT, root)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{Msg: "Verifying root failed, not enough signatures, got 0, want 1"})
+}
+
+func TestGetCanonicalEncoder(t *testing.T) {
+	// This function is just a simple getter, no need for testing table
+	assert.NotNil(t, GetCanonicalEncoder())
+}
+
+func TestSetCanonicalEncoderConcurrently(t *testing.T) {
+	// Test the encoder and the trailing newline can be set while metadata is
+	// serialized, run with -race to catch unsynchronized access
+	defer SetCanonicalEncoder(nil)
+	defer SetTrailingNewline(false)
+
+	root := Root(time.Now().AddDate(0, 0, 1).UTC())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetCanonicalEncoder(nil)
+			SetTrailingNewline(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, err := encodeCanonical(root.Signed)
+		assert.NoError(t, err)
+		_, err = root.ToBytes(false)
+		assert.NoError(t, err)
+	}
+	<-done
+}
@@ -14,8 +14,11 @@ package config
 import (
 	"net/url"
 	"os"
+	"time"
 
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
 )
 
 type UpdaterConfig struct {
@@ -26,20 +29,138 @@ type UpdaterConfig struct {
 	TimestampMaxLength int64
 	SnapshotMaxLength  int64
 	TargetsMaxLength   int64
+	// TargetFileMaxLength caps the download of target files which don't
+	// commit to a length
+	TargetFileMaxLength int64
+	// MaxDelegatedPathPatterns caps the number of paths or path hash
+	// prefixes of each delegated role, so that matching targets against
+	// delegations stays cheap. Targets metadata delegating to a role with
+	// more is rejected. A zero value means no limit
+	MaxDelegatedPathPatterns int
+	// MaxDelegatedDownloads caps the number of delegated targets metadata
+	// downloaded by a single operation, e.g. GetTargetInfo, regardless of
+	// the delegation depth. Roles loaded from the local cache don't count.
+	// A zero value means no limit
+	MaxDelegatedDownloads int
+	// Download timeouts per metadata role, delegated targets metadata
+	// use TargetsTimeout. A zero value falls back to DefaultTimeout
+	RootTimeout      time.Duration
+	TimestampTimeout time.Duration
+	SnapshotTimeout  time.Duration
+	TargetsTimeout   time.Duration
+	// TargetTimeout is the download timeout of target files. A zero value
+	// falls back to DefaultTimeout
+	TargetTimeout time.Duration
+	// VersionedMetadataRetryTimeout tolerates remotes which lag behind the
+	// timestamp, e.g. CDNs still propagating a new snapshot: with consistent
+	// snapshots, downloads of <version>.snapshot.json and
+	// <version>.<role>.json failing with HTTP 404 are retried with backoff
+	// until this timeout elapses. A zero value disables the retries
+	VersionedMetadataRetryTimeout time.Duration
 	// Updater configuration
-	Fetcher               fetcher.Fetcher
-	LocalTrustedRoot      []byte
+	Fetcher          fetcher.Fetcher
+	LocalTrustedRoot []byte
+	// InitialRootURL and InitialRootSHA256 bootstrap the trusted root if
+	// LocalTrustedRoot is empty: the cached root.json in LocalMetadataDir is
+	// used if it exists, otherwise the root is downloaded from InitialRootURL
+	// and trusted only if its sha256 matches the hex encoded InitialRootSHA256
+	InitialRootURL        string
+	InitialRootSHA256     string
 	LocalMetadataDir      string
 	LocalTargetsDir       string
 	RemoteMetadataURL     string
 	RemoteTargetsURL      string
 	DisableLocalCache     bool
 	PrefixTargetsWithHash bool
+	// AllowedURLPrefixes, if not empty, restricts all metadata and target
+	// file downloads to URLs starting with one of these prefixes. A prefix
+	// only matches whole path segments, e.g. "https://example.com/tuf"
+	// matches "https://example.com/tuf/root.json" but not
+	// "https://example.com/tuf-evil/root.json". Redirects are checked too
+	// if the Fetcher supports it, as the DefaultFetcher does
+	AllowedURLPrefixes []string
+	// RewriteURL, if set, is called with the URL of every metadata and
+	// target file download and returns the URL to pass to the Fetcher
+	// instead, e.g. with signed query parameters appended for a CDN. The
+	// rewritten URL is the one checked against AllowedURLPrefixes. An error
+	// fails the download
+	RewriteURL func(url string) (string, error)
+	// RemoteTargetsMirrorURLs lists additional target base URLs that
+	// DownloadTarget falls back to, in order, if the target file can't be
+	// downloaded from RemoteTargetsURL or fails verification
+	RemoteTargetsMirrorURLs []string
+	// TargetHashPrefixAlgorithm selects which hash of a target file prefixes
+	// its filename with consistent snapshots. An empty value uses sha256
+	TargetHashPrefixAlgorithm string
+	// PreserveTargetDirs stores target files under LocalTargetsDir using
+	// their target path as a relative path, creating any intermediate
+	// directories, instead of a single URL encoded filename
+	PreserveTargetDirs bool
+	// NormalizeTargetPaths makes target lookups normalize the requested
+	// path and match it against the listed target paths with the same
+	// normalized form, e.g. "/dir/file.txt" and "./dir/file.txt" both find
+	// a target listed as "dir/file.txt", see metadata.NormalizeTargetPath.
+	// The normalized path is also the one matched against delegations
+	NormalizeTargetPaths bool
+	// MinTrustedRootVersion rejects an initial trusted root with a lower
+	// version, e.g. to avoid bootstrapping from a stale embedded root.
+	// A zero value disables the check
+	MinTrustedRootVersion int64
+	// RequireHashAlgorithms lists hash algorithms (e.g. "sha512") that every
+	// target file must be verified with, regardless of what the repository
+	// published. Since hashes are optional for metadata files, snapshot and
+	// targets metadata are only required to include them if any are published
+	RequireHashAlgorithms []string
+	// AllowedHashAlgorithms, if not empty, restricts the verification of
+	// target files to these hash algorithms (e.g. "sha256"). Hashes of other
+	// algorithms are ignored, and at least one hash of an allowed algorithm
+	// must be published for a target file
+	AllowedHashAlgorithms []string
+	// RequireMetaHashes rejects snapshot and targets metadata unless hashes
+	// for them are published in the timestamp and snapshot meta. Otherwise
+	// such hashes are optional and only verified if present
+	RequireMetaHashes bool
+	// CompressLocalMetadata stores local metadata gzipped as <role>.json.gz.
+	// Loading falls back to an uncompressed <role>.json if no gzipped file exists
+	CompressLocalMetadata bool
+	// RootForbiddenAsNotFound treats an HTTP 403 Forbidden for the next root
+	// version like a 404 Not Found, i.e. the trusted root is the newest one,
+	// e.g. for storage that answers 403 for missing objects. By default a
+	// 403 fails the update, so that permission issues which would otherwise
+	// silently prevent root rotations are detected
+	RootForbiddenAsNotFound bool
+	// TempDir is where the temporary files for the atomic writes of local
+	// metadata and target files are created. An empty value creates them
+	// next to the destination file, so they can always be renamed into
+	// place. Otherwise, if the rename fails because TempDir is on another
+	// device, the file is copied next to the destination and renamed from
+	// there
+	TempDir string
 	// UnsafeLocalMode only uses the metadata as written on disk
 	// if the metadata is incomplete, calling updater.Refresh will fail
 	UnsafeLocalMode bool
+	// OnMetadataLoaded, if set, is called with the raw data of every root,
+	// top-level and delegated targets metadata once it is verified and
+	// loaded into the trusted set, whether it was read from the local cache
+	// or downloaded. It is called while the Updater is locked, so it must not
+	// call back into the Updater. Metadata loaded by a Refresh that fails
+	// later on is reported too, even though the refresh rolls it back
+	OnMetadataLoaded func(role string, version int64, data []byte)
+	// TargetsCache, if set, caches verified targets metadata so that
+	// Updaters sharing it, e.g. one per request in a server, don't verify
+	// the same targets metadata again, see trustedmetadata.TargetsCache
+	TargetsCache *trustedmetadata.TargetsCache
+	// SignatureVerificationWorkers, if greater than 1, verifies the
+	// signatures of each metadata file concurrently with up to that many
+	// goroutines, which speeds up roles with many keys that are slow to
+	// verify, e.g. RSA keys. Verification outcomes are not affected.
+	// Defaults to 0, verifying sequentially
+	SignatureVerificationWorkers int
 }
 
+// DefaultTimeout is the download timeout used when none is configured
+const DefaultTimeout = 15 * time.Second
+
 // New creates a new UpdaterConfig instance used by the Updater to
 // store configuration
 func New(remoteURL string, rootBytes []byte) (*UpdaterConfig, error) {
@@ -51,12 +172,19 @@ func New(remoteURL string, rootBytes []byte) (*UpdaterConfig, error) {
 
 	return &UpdaterConfig{
 		// TUF configuration
-		MaxRootRotations:   32,
-		MaxDelegations:     32,
-		RootMaxLength:      512000,  // bytes
-		TimestampMaxLength: 16384,   // bytes
-		SnapshotMaxLength:  2000000, // bytes
-		TargetsMaxLength:   5000000, // bytes
+		MaxRootRotations:         32,
+		MaxDelegations:           32,
+		RootMaxLength:            512000,    // bytes
+		TimestampMaxLength:       16384,     // bytes
+		SnapshotMaxLength:        2000000,   // bytes
+		TargetsMaxLength:         5000000,   // bytes
+		TargetFileMaxLength:      500000000, // bytes
+		MaxDelegatedPathPatterns: 1000,
+		RootTimeout:              DefaultTimeout,
+		TimestampTimeout:         DefaultTimeout,
+		SnapshotTimeout:          DefaultTimeout,
+		TargetsTimeout:           DefaultTimeout,
+		TargetTimeout:            DefaultTimeout,
 		// Updater configuration
 		Fetcher:               &fetcher.DefaultFetcher{}, // use the default built-in download fetcher
 		LocalTrustedRoot:      rootBytes,                 // trusted root.json
@@ -68,6 +196,33 @@ func New(remoteURL string, rootBytes []byte) (*UpdaterConfig, error) {
 	}, nil
 }
 
+// RoleTimeout returns the download timeout configured for roleName
+func (cfg *UpdaterConfig) RoleTimeout(roleName string) time.Duration {
+	var timeout time.Duration
+	switch roleName {
+	case metadata.ROOT:
+		timeout = cfg.RootTimeout
+	case metadata.TIMESTAMP:
+		timeout = cfg.TimestampTimeout
+	case metadata.SNAPSHOT:
+		timeout = cfg.SnapshotTimeout
+	default:
+		timeout = cfg.TargetsTimeout
+	}
+	if timeout <= 0 {
+		return DefaultTimeout
+	}
+	return timeout
+}
+
+// TargetFileTimeout returns the download timeout configured for target files
+func (cfg *UpdaterConfig) TargetFileTimeout() time.Duration {
+	if cfg.TargetTimeout <= 0 {
+		return DefaultTimeout
+	}
+	return cfg.TargetTimeout
+}
+
 func (cfg *UpdaterConfig) EnsurePathsExist() error {
 	if cfg.DisableLocalCache {
 		return nil
@@ -37,18 +37,25 @@ func TestNewUpdaterConfig(t *testing.T) {
 			remoteURL: "somepath",
 			rootBytes: []byte("somerootbytes"),
 			config: &UpdaterConfig{
-				MaxRootRotations:      32,
-				MaxDelegations:        32,
-				RootMaxLength:         512000,
-				TimestampMaxLength:    16384,
-				SnapshotMaxLength:     2000000,
-				TargetsMaxLength:      5000000,
-				Fetcher:               &fetcher.DefaultFetcher{},
-				LocalTrustedRoot:      []byte("somerootbytes"),
-				RemoteMetadataURL:     "somepath",
-				RemoteTargetsURL:      "somepath/targets",
-				DisableLocalCache:     false,
-				PrefixTargetsWithHash: true,
+				MaxRootRotations:         32,
+				MaxDelegations:           32,
+				RootMaxLength:            512000,
+				TimestampMaxLength:       16384,
+				SnapshotMaxLength:        2000000,
+				TargetsMaxLength:         5000000,
+				TargetFileMaxLength:      500000000,
+				MaxDelegatedPathPatterns: 1000,
+				RootTimeout:              DefaultTimeout,
+				TimestampTimeout:         DefaultTimeout,
+				SnapshotTimeout:          DefaultTimeout,
+				TargetsTimeout:           DefaultTimeout,
+				TargetTimeout:            DefaultTimeout,
+				Fetcher:                  &fetcher.DefaultFetcher{},
+				LocalTrustedRoot:         []byte("somerootbytes"),
+				RemoteMetadataURL:        "somepath",
+				RemoteTargetsURL:         "somepath/targets",
+				DisableLocalCache:        false,
+				PrefixTargetsWithHash:    true,
 			},
 			wantErr: nil,
 		},
@@ -46,6 +46,36 @@ func (e ErrUnsignedMetadata) Is(target error) bool {
 	return target == ErrRepository{} || target == ErrUnsignedMetadata{}
 }
 
+// ErrInvalidDelegation - An error about a delegated role with malformed or
+// too many path patterns
+type ErrInvalidDelegation struct {
+	Msg string
+}
+
+func (e ErrInvalidDelegation) Error() string {
+	return fmt.Sprintf("invalid delegation error: %s", e.Msg)
+}
+
+// ErrInvalidDelegation is a subset of ErrRepository
+func (e ErrInvalidDelegation) Is(target error) bool {
+	return target == ErrRepository{} || target == ErrInvalidDelegation{}
+}
+
+// ErrInvalidTimestampMeta - An error for a timestamp whose meta is anything
+// other than a single snapshot.json entry
+type ErrInvalidTimestampMeta struct {
+	Msg string
+}
+
+func (e ErrInvalidTimestampMeta) Error() string {
+	return fmt.Sprintf("invalid timestamp meta error: %s", e.Msg)
+}
+
+// ErrInvalidTimestampMeta is a subset of ErrRepository
+func (e ErrInvalidTimestampMeta) Is(target error) bool {
+	return target == ErrRepository{} || target == ErrInvalidTimestampMeta{}
+}
+
 // ErrBadVersionNumber - An error for metadata that contains an invalid version number
 type ErrBadVersionNumber struct {
 	Msg string
@@ -88,6 +118,20 @@ func (e ErrExpiredMetadata) Is(target error) bool {
 	return target == ErrRepository{} || target == ErrExpiredMetadata{}
 }
 
+// ErrInvalidExpiry - Indicate that a TUF Metadata file has an expiry which is not an RFC3339 timestamp in UTC
+type ErrInvalidExpiry struct {
+	Msg string
+}
+
+func (e ErrInvalidExpiry) Error() string {
+	return fmt.Sprintf("invalid expiry error: %s", e.Msg)
+}
+
+// ErrInvalidExpiry is a subset of ErrRepository
+func (e ErrInvalidExpiry) Is(target error) bool {
+	return target == ErrRepository{} || target == ErrInvalidExpiry{}
+}
+
 // ErrLengthOrHashMismatch - An error while checking the length and hash values of an object
 type ErrLengthOrHashMismatch struct {
 	Msg string
@@ -127,6 +171,50 @@ func (e ErrDownloadLengthMismatch) Is(target error) bool {
 	return target == ErrDownload{} || target == ErrDownloadLengthMismatch{}
 }
 
+// ErrDownloadBudgetExceeded - Indicate that a download was refused because the
+// total download budget is used up
+type ErrDownloadBudgetExceeded struct {
+	Msg string
+}
+
+func (e ErrDownloadBudgetExceeded) Error() string {
+	return fmt.Sprintf("download budget exceeded error: %s", e.Msg)
+}
+
+// ErrDownloadBudgetExceeded is a subset of ErrDownload
+func (e ErrDownloadBudgetExceeded) Is(target error) bool {
+	return target == ErrDownload{} || target == ErrDownloadBudgetExceeded{}
+}
+
+// ErrDelegatedDownloadsExceeded - Indicate that delegated targets metadata was
+// not downloaded because MaxDelegatedDownloads roles were already downloaded
+type ErrDelegatedDownloadsExceeded struct {
+	Msg string
+}
+
+func (e ErrDelegatedDownloadsExceeded) Error() string {
+	return fmt.Sprintf("delegated downloads exceeded error: %s", e.Msg)
+}
+
+// ErrDelegatedDownloadsExceeded is a subset of ErrDownload
+func (e ErrDelegatedDownloadsExceeded) Is(target error) bool {
+	return target == ErrDownload{} || target == ErrDelegatedDownloadsExceeded{}
+}
+
+// ErrClockSkew - Indicate that the local clock differs too much from the server date
+type ErrClockSkew struct {
+	Msg string
+}
+
+func (e ErrClockSkew) Error() string {
+	return fmt.Sprintf("clock skew error: %s", e.Msg)
+}
+
+// ErrClockSkew is a subset of ErrDownload
+func (e ErrClockSkew) Is(target error) bool {
+	return target == ErrDownload{} || target == ErrClockSkew{}
+}
+
 // ErrDownloadHTTP - Returned by Fetcher interface implementations for HTTP errors
 type ErrDownloadHTTP struct {
 	StatusCode int
@@ -142,6 +230,41 @@ func (e ErrDownloadHTTP) Is(target error) bool {
 	return target == ErrDownload{} || target == ErrDownloadHTTP{}
 }
 
+// ErrDownloadURLNotAllowed - Indicate that a download was refused because its URL is not allowed
+type ErrDownloadURLNotAllowed struct {
+	URL string
+}
+
+func (e ErrDownloadURLNotAllowed) Error() string {
+	return fmt.Sprintf("download url not allowed error: %s does not match any allowed url prefix", e.URL)
+}
+
+// ErrDownloadURLNotAllowed is a subset of ErrDownload
+func (e ErrDownloadURLNotAllowed) Is(target error) bool {
+	return target == ErrDownload{} || target == ErrDownloadURLNotAllowed{}
+}
+
+// ErrDownloadNetwork - Returned by Fetcher interface implementations when the
+// server could not be reached or the transfer was interrupted
+type ErrDownloadNetwork struct {
+	URL string
+	Err error
+}
+
+func (e ErrDownloadNetwork) Error() string {
+	return fmt.Sprintf("failed to download %s, network error: %v", e.URL, e.Err)
+}
+
+// Unwrap returns the underlying transport error
+func (e ErrDownloadNetwork) Unwrap() error {
+	return e.Err
+}
+
+// ErrDownloadNetwork is a subset of ErrDownload
+func (e ErrDownloadNetwork) Is(target error) bool {
+	return target == ErrDownload{} || target == ErrDownloadNetwork{}
+}
+
 // ValueError
 type ErrValue struct {
 	Msg string
@@ -151,6 +274,20 @@ func (e ErrValue) Error() string {
 	return fmt.Sprintf("value error: %s", e.Msg)
 }
 
+// MetadataTypeError
+type ErrMetadataType struct {
+	Msg string
+}
+
+func (e ErrMetadataType) Error() string {
+	return ErrValue{Msg: e.Msg}.Error()
+}
+
+// ErrMetadataType is a subset of ErrValue
+func (e ErrMetadataType) Is(target error) bool {
+	return target == ErrMetadataType{} || target == ErrValue{}
+}
+
 // TypeError
 type ErrType struct {
 	Msg string
@@ -0,0 +1,95 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+)
+
+// BudgetFetcher decorates a Fetcher with a total budget of bytes that can be
+// downloaded, on top of the per-file maximum length. This guards against a
+// malicious mirror serving many small files. The Updater resets the budget
+// at the start of each Refresh and GetTargetInfo call
+type BudgetFetcher struct {
+	Fetcher
+	budget int64
+	mu     sync.Mutex
+	used   int64
+}
+
+// NewBudgetFetcher creates a BudgetFetcher downloading with f up to budget
+// bytes in total
+func NewBudgetFetcher(f Fetcher, budget int64) *BudgetFetcher {
+	return &BudgetFetcher{Fetcher: f, budget: budget}
+}
+
+// DownloadFile downloads urlPath with the decorated Fetcher, the maximum
+// length is lowered to the remaining budget. Errors out with
+// ErrDownloadBudgetExceeded if the file doesn't fit the remaining budget
+func (b *BudgetFetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	b.mu.Lock()
+	remaining := b.budget - b.used
+	b.mu.Unlock()
+	if remaining <= 0 {
+		return nil, metadata.ErrDownloadBudgetExceeded{Msg: fmt.Sprintf("no budget left to download %s, %d bytes already downloaded", urlPath, b.budget)}
+	}
+	data, err := b.Fetcher.DownloadFile(urlPath, min(maxLength, remaining), timeout)
+	if err != nil {
+		if remaining < maxLength && errors.Is(err, metadata.ErrDownloadLengthMismatch{}) {
+			return nil, metadata.ErrDownloadBudgetExceeded{Msg: fmt.Sprintf("download of %s is larger than the remaining budget of %d bytes", urlPath, remaining)}
+		}
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += int64(len(data))
+	// concurrent downloads may have used up the budget meanwhile
+	if b.used > b.budget {
+		return nil, metadata.ErrDownloadBudgetExceeded{Msg: fmt.Sprintf("download of %s exceeds the budget of %d bytes", urlPath, b.budget)}
+	}
+	return data, nil
+}
+
+// Used returns the number of bytes downloaded since the last reset
+func (b *BudgetFetcher) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// CloseIdleConnections closes the idle connections of the decorated
+// Fetcher, if it keeps any
+func (b *BudgetFetcher) CloseIdleConnections() {
+	if f, ok := b.Fetcher.(interface{ CloseIdleConnections() }); ok {
+		f.CloseIdleConnections()
+	}
+}
+
+// SetAllowedURLPrefixes sets the allowed URL prefixes of the decorated
+// Fetcher, if it supports them
+func (b *BudgetFetcher) SetAllowedURLPrefixes(prefixes []string) {
+	if f, ok := b.Fetcher.(interface{ SetAllowedURLPrefixes([]string) }); ok {
+		f.SetAllowedURLPrefixes(prefixes)
+	}
+}
+
+// ResetBudget makes the whole budget available again
+func (b *BudgetFetcher) ResetBudget() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used = 0
+}
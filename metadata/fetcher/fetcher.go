@@ -12,10 +12,15 @@
 package fetcher
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
@@ -29,13 +34,106 @@ type Fetcher interface {
 // DefaultFetcher implements Fetcher
 type DefaultFetcher struct {
 	httpUserAgent string
+	// client is shared by all downloads so that connections are reused,
+	// it is created on first use
+	client     *http.Client
+	clientOnce sync.Once
+	// etags caches the ETag and body of previous responses by URL, they are
+	// used for conditional requests so unchanged files aren't downloaded again
+	etags   map[string]etagEntry
+	etagsMu sync.Mutex
+	// MaxClockSkew, if set, is the largest difference allowed between the
+	// local clock and the Date header of the server responses. A larger
+	// skew is logged, as it makes expiry checks misbehave
+	MaxClockSkew time.Duration
+	// StrictClockSkew makes downloads error out with ErrClockSkew instead
+	// of only logging a skew larger than MaxClockSkew
+	StrictClockSkew bool
+	// clockSkew is the skew seen in the last response with a Date header
+	clockSkew   time.Duration
+	clockSkewOK bool
+	clockSkewMu sync.Mutex
+	// allowedURLPrefixes, if not empty, restricts the redirects followed
+	allowedURLPrefixes []string
+}
+
+// etagEntry is a response body cached along with its ETag
+type etagEntry struct {
+	etag string
+	data []byte
+}
+
+// maxETagCacheLength is the largest response body kept for conditional
+// requests, so that large files like targets are not held in memory
+const maxETagCacheLength = 1 << 20
+
+// httpClient returns the HTTP client used for downloads, with its own
+// connection pool
+func (d *DefaultFetcher) httpClient() *http.Client {
+	d.clientOnce.Do(func() {
+		d.client = &http.Client{
+			Transport:     http.DefaultTransport.(*http.Transport).Clone(),
+			CheckRedirect: d.checkRedirect,
+		}
+	})
+	return d.client
+}
+
+// SetAllowedURLPrefixes restricts the redirects followed by downloads to
+// URLs matching one of prefixes, see URLAllowed, so that an allowed server
+// can't redirect to any other. The Updater sets them from the
+// AllowedURLPrefixes of its config when it's created, before any download
+func (d *DefaultFetcher) SetAllowedURLPrefixes(prefixes []string) {
+	d.allowedURLPrefixes = prefixes
+}
+
+// checkRedirect stops after 10 redirects as the default HTTP client does
+// and refuses to follow redirects to URLs which are not allowed
+func (d *DefaultFetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !URLAllowed(req.URL.String(), d.allowedURLPrefixes) {
+		return metadata.ErrDownloadURLNotAllowed{URL: req.URL.String()}
+	}
+	return nil
+}
+
+// URLAllowed reports whether urlPath starts with one of prefixes, matching
+// whole path segments only. An empty list of prefixes allows any URL
+func URLAllowed(urlPath string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		rest, ok := strings.CutPrefix(urlPath, prefix)
+		if ok && (rest == "" || strings.HasSuffix(prefix, "/") || strings.HasPrefix(rest, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseIdleConnections closes any idle connections kept for reuse by
+// previous downloads, it doesn't interrupt downloads in progress
+func (d *DefaultFetcher) CloseIdleConnections() {
+	d.httpClient().CloseIdleConnections()
 }
 
 // DownloadFile downloads a file from urlPath, errors out if it failed,
 // its length is larger than maxLength or the timeout is reached.
+// Responses with an ETag are cached and requested again with If-None-Match,
+// on an HTTP 304 Not Modified the cached file is returned.
 func (d *DefaultFetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
-	client := &http.Client{Timeout: timeout}
-	req, err := http.NewRequest("GET", urlPath, nil)
+	// the timeout covers the whole request including reading the body,
+	// a timeout of 0 means no timeout
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	client := d.httpClient()
+	req, err := http.NewRequestWithContext(ctx, "GET", urlPath, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -43,13 +141,32 @@ func (d *DefaultFetcher) DownloadFile(urlPath string, maxLength int64, timeout t
 	if d.httpUserAgent != "" {
 		req.Header.Set("User-Agent", d.httpUserAgent)
 	}
+	// Only download the file again if it changed since it was cached
+	cached, isCached := d.cachedETag(urlPath)
+	if isCached {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
 	// Execute the request.
 	res, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		// a redirect to a URL which is not allowed isn't a network error
+		var notAllowed metadata.ErrDownloadURLNotAllowed
+		if errors.As(err, &notAllowed) {
+			return nil, notAllowed
+		}
+		return nil, metadata.ErrDownloadNetwork{URL: urlPath, Err: err}
 	}
 	defer res.Body.Close()
+	if err := d.checkClockSkew(urlPath, res.Header.Get("Date")); err != nil {
+		return nil, err
+	}
 	// Handle HTTP status codes.
+	if res.StatusCode == http.StatusNotModified && isCached {
+		if int64(len(cached.data)) > maxLength {
+			return nil, metadata.ErrDownloadLengthMismatch{Msg: fmt.Sprintf("download failed for %s, length %d is larger than expected %d", urlPath, len(cached.data), maxLength)}
+		}
+		return bytes.Clone(cached.data), nil
+	}
 	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden || res.StatusCode != http.StatusOK {
 		return nil, metadata.ErrDownloadHTTP{StatusCode: res.StatusCode, URL: urlPath}
 	}
@@ -71,13 +188,70 @@ func (d *DefaultFetcher) DownloadFile(urlPath string, maxLength int64, timeout t
 	// surpased our set limit.
 	data, err := io.ReadAll(io.LimitReader(res.Body, maxLength+1))
 	if err != nil {
-		return nil, err
+		return nil, metadata.ErrDownloadNetwork{URL: urlPath, Err: err}
 	}
 	// Error if the reported size is greater than what is expected.
 	length = int64(len(data))
 	if length > maxLength {
 		return nil, metadata.ErrDownloadLengthMismatch{Msg: fmt.Sprintf("download failed for %s, length %d is larger than expected %d", urlPath, length, maxLength)}
 	}
+	d.cacheETag(urlPath, res.Header.Get("ETag"), data)
 
 	return data, nil
 }
+
+// ClockSkew returns how far the server clock was ahead of the local clock,
+// negative if it was behind, as seen in the Date header of the last
+// response. The boolean is false if no response had a valid Date header yet
+func (d *DefaultFetcher) ClockSkew() (time.Duration, bool) {
+	d.clockSkewMu.Lock()
+	defer d.clockSkewMu.Unlock()
+	return d.clockSkew, d.clockSkewOK
+}
+
+// checkClockSkew records the skew between the local clock and the server
+// date and, if MaxClockSkew is set, logs or errors out when it's too large
+func (d *DefaultFetcher) checkClockSkew(urlPath, date string) error {
+	if date == "" {
+		return nil
+	}
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return nil
+	}
+	skew := serverTime.Sub(time.Now())
+	d.clockSkewMu.Lock()
+	d.clockSkew, d.clockSkewOK = skew, true
+	d.clockSkewMu.Unlock()
+	if d.MaxClockSkew <= 0 || skew.Abs() <= d.MaxClockSkew {
+		return nil
+	}
+	if d.StrictClockSkew {
+		return metadata.ErrClockSkew{Msg: fmt.Sprintf("local clock differs from the date of %s by %s, more than the allowed %s", urlPath, skew.Round(time.Second), d.MaxClockSkew)}
+	}
+	metadata.GetLogger().Info("Local clock differs from the server date", "url", urlPath, "skew", skew.Round(time.Second), "max", d.MaxClockSkew)
+	return nil
+}
+
+// cachedETag returns the cached ETag and body for urlPath, if any
+func (d *DefaultFetcher) cachedETag(urlPath string) (etagEntry, bool) {
+	d.etagsMu.Lock()
+	defer d.etagsMu.Unlock()
+	entry, ok := d.etags[urlPath]
+	return entry, ok
+}
+
+// cacheETag caches data for urlPath under etag, or drops the cached entry
+// if the response has no ETag or is too large to be cached
+func (d *DefaultFetcher) cacheETag(urlPath, etag string, data []byte) {
+	d.etagsMu.Lock()
+	defer d.etagsMu.Unlock()
+	if etag == "" || len(data) > maxETagCacheLength {
+		delete(d.etags, urlPath)
+		return
+	}
+	if d.etags == nil {
+		d.etags = map[string]etagEntry{}
+	}
+	d.etags[urlPath] = etagEntry{etag: etag, data: bytes.Clone(data)}
+}
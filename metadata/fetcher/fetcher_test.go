@@ -12,7 +12,14 @@
 package fetcher
 
 import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -44,7 +51,7 @@ func TestDownLoadFile(t *testing.T) {
 			desc:    "URL does not exist",
 			url:     "https://somebadtufrepourl.com/metadata/",
 			data:    nil,
-			wantErr: &url.Error{},
+			wantErr: metadata.ErrDownloadNetwork{},
 		},
 		{
 			name:    "invalid url format",
@@ -86,3 +93,288 @@ func TestDownLoadFile(t *testing.T) {
 		})
 	}
 }
+
+func TestDownloadFileNetworkError(t *testing.T) {
+	// grab a free port and close it again so that connecting is refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	assert.NoError(t, listener.Close())
+
+	fetcher := DefaultFetcher{httpUserAgent: "Metadata_Unit_Test/1.0"}
+	urlPath := fmt.Sprintf("http://%s/metadata/1.root.json", addr)
+	data, err := fetcher.DownloadFile(urlPath, 512000, 15*time.Second)
+	assert.Nil(t, data)
+	assert.ErrorIs(t, err, metadata.ErrDownloadNetwork{})
+	assert.ErrorIs(t, err, metadata.ErrDownload{})
+	assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+	assert.NotErrorIs(t, err, metadata.ErrDownloadHTTP{})
+	var networkErr metadata.ErrDownloadNetwork
+	assert.ErrorAs(t, err, &networkErr)
+	assert.Equal(t, urlPath, networkErr.URL)
+
+	// a reachable server refusing the request is still an HTTP error
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	data, err = fetcher.DownloadFile(server.URL+"/metadata/1.root.json", 512000, 15*time.Second)
+	assert.Nil(t, data)
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{StatusCode: http.StatusNotFound, URL: server.URL + "/metadata/1.root.json"})
+	assert.NotErrorIs(t, err, metadata.ErrDownloadNetwork{})
+}
+
+func TestDownloadFileTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer server.Close()
+	fetcher := DefaultFetcher{}
+
+	// a timeout of 0 means no timeout
+	data, err := fetcher.DownloadFile(server.URL, 512000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	// a timeout shorter than the response errors out
+	data, err = fetcher.DownloadFile(server.URL, 512000, 10*time.Millisecond)
+	assert.Nil(t, data)
+	assert.ErrorIs(t, err, metadata.ErrDownloadNetwork{})
+}
+
+func TestDownloadFileRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("other"))
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tuf/moved.json":
+			http.Redirect(w, r, "/tuf/root.json", http.StatusFound)
+		case "/tuf/away.json":
+			http.Redirect(w, r, other.URL+"/tuf/root.json", http.StatusFound)
+		default:
+			_, _ = w.Write([]byte("data"))
+		}
+	}))
+	defer server.Close()
+
+	// redirects are followed to anywhere without allowed prefixes
+	fetcher := DefaultFetcher{}
+	data, err := fetcher.DownloadFile(server.URL+"/tuf/away.json", 512000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("other"), data)
+
+	// redirects are followed to allowed URLs only
+	fetcher = DefaultFetcher{}
+	fetcher.SetAllowedURLPrefixes([]string{server.URL + "/tuf"})
+	data, err = fetcher.DownloadFile(server.URL+"/tuf/moved.json", 512000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	data, err = fetcher.DownloadFile(server.URL+"/tuf/away.json", 512000, 0)
+	assert.Nil(t, data)
+	assert.ErrorIs(t, err, metadata.ErrDownloadURLNotAllowed{URL: other.URL + "/tuf/root.json"})
+}
+
+func TestURLAllowed(t *testing.T) {
+	assert.True(t, URLAllowed("https://example.com/tuf/root.json", nil))
+	assert.True(t, URLAllowed("https://example.com/tuf/root.json", []string{"https://example.com/tuf"}))
+	assert.True(t, URLAllowed("https://example.com/tuf/root.json", []string{"https://other.com", "https://example.com/tuf/"}))
+	assert.True(t, URLAllowed("https://example.com/tuf", []string{"https://example.com/tuf"}))
+	assert.False(t, URLAllowed("https://example.com/tuf-evil/root.json", []string{"https://example.com/tuf"}))
+	assert.False(t, URLAllowed("https://evil.com/tuf/root.json", []string{"https://example.com/tuf"}))
+}
+
+func TestDownloadFileReusesConnections(t *testing.T) {
+	var newConns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data"))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	// downloads share the idle connection
+	fetcher := DefaultFetcher{}
+	for i := 0; i < 2; i++ {
+		data, err := fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("data"), data)
+	}
+	assert.Equal(t, int32(1), newConns.Load())
+
+	// a new connection is needed once the idle ones are closed
+	fetcher.CloseIdleConnections()
+	data, err := fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	assert.Equal(t, int32(2), newConns.Load())
+}
+
+func TestMemoryFetcher(t *testing.T) {
+	fetcher := NewMemoryFetcher(map[string][]byte{"https://tuf.test/metadata/timestamp.json": []byte("timestamp")})
+
+	data, err := fetcher.DownloadFile("https://tuf.test/metadata/timestamp.json", 512000, 15*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("timestamp"), data)
+
+	_, err = fetcher.DownloadFile("https://tuf.test/metadata/timestamp.json", 4, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrDownloadLengthMismatch{})
+
+	_, err = fetcher.DownloadFile("https://tuf.test/metadata/1.root.json", 512000, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{StatusCode: http.StatusNotFound, URL: "https://tuf.test/metadata/1.root.json"})
+
+	fetcher.SetFile("https://tuf.test/metadata/1.root.json", []byte("root"))
+	data, err = fetcher.DownloadFile("https://tuf.test/metadata/1.root.json", 512000, 15*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("root"), data)
+
+	fetcher.DeleteFile("https://tuf.test/metadata/timestamp.json")
+	_, err = fetcher.DownloadFile("https://tuf.test/metadata/timestamp.json", 512000, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{})
+}
+
+func TestDownloadFileETag(t *testing.T) {
+	var downloads, notModified atomic.Int32
+	var mu sync.Mutex
+	body, etag := []byte("timestamp v1"), `"v1"`
+	publish := func(newBody []byte, newETag string) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, etag = newBody, newETag
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		body, etag := body, etag
+		mu.Unlock()
+		if etag != "" && r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads.Add(1)
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	// the cached bytes are reused on a 304
+	fetcher := DefaultFetcher{}
+	for i := 0; i < 3; i++ {
+		data, err := fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("timestamp v1"), data)
+	}
+	assert.Equal(t, int32(1), downloads.Load())
+	assert.Equal(t, int32(2), notModified.Load())
+
+	// the cached bytes still have to fit maxLength
+	_, err := fetcher.DownloadFile(server.URL, 4, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrDownloadLengthMismatch{})
+
+	// a changed file is downloaded again
+	publish([]byte("timestamp v2"), `"v2"`)
+	data, err := fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("timestamp v2"), data)
+	assert.Equal(t, int32(2), downloads.Load())
+
+	// responses without an ETag aren't cached
+	publish([]byte("timestamp v3"), "")
+	for i := 0; i < 2; i++ {
+		data, err = fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("timestamp v3"), data)
+	}
+	assert.Equal(t, int32(4), downloads.Load())
+	assert.Equal(t, int32(3), notModified.Load())
+}
+
+func TestBudgetFetcher(t *testing.T) {
+	files := map[string][]byte{}
+	for i := 0; i < 10; i++ {
+		files[fmt.Sprintf("https://tuf.test/metadata/%d.json", i)] = []byte("0123456789")
+	}
+	fetcher := NewBudgetFetcher(NewMemoryFetcher(files), 35)
+
+	// many small fetches collectively exceed the budget
+	for i := 0; i < 3; i++ {
+		data, err := fetcher.DownloadFile(fmt.Sprintf("https://tuf.test/metadata/%d.json", i), 512000, 15*time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("0123456789"), data)
+	}
+	assert.Equal(t, int64(30), fetcher.Used())
+	_, err := fetcher.DownloadFile("https://tuf.test/metadata/3.json", 512000, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrDownloadBudgetExceeded{Msg: "download of https://tuf.test/metadata/3.json is larger than the remaining budget of 5 bytes"})
+	assert.ErrorIs(t, err, metadata.ErrDownload{})
+	assert.Equal(t, int64(30), fetcher.Used())
+
+	// per-file length limits still apply
+	_, err = fetcher.DownloadFile("https://tuf.test/metadata/3.json", 4, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrDownloadLengthMismatch{})
+
+	// other errors are passed through
+	_, err = fetcher.DownloadFile("https://tuf.test/metadata/missing.json", 512000, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{})
+
+	// the whole budget is available again after a reset
+	fetcher.ResetBudget()
+	assert.Equal(t, int64(0), fetcher.Used())
+	data, err := fetcher.DownloadFile("https://tuf.test/metadata/3.json", 512000, 15*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("0123456789"), data)
+
+	// nothing is downloaded once the budget is used up
+	fetcher = NewBudgetFetcher(NewMemoryFetcher(files), 10)
+	_, err = fetcher.DownloadFile("https://tuf.test/metadata/0.json", 512000, 15*time.Second)
+	assert.NoError(t, err)
+	_, err = fetcher.DownloadFile("https://tuf.test/metadata/1.json", 512000, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrDownloadBudgetExceeded{Msg: "no budget left to download https://tuf.test/metadata/1.json, 10 bytes already downloaded"})
+}
+
+func TestDownloadFileClockSkew(t *testing.T) {
+	var date string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", date)
+		_, _ = w.Write([]byte("timestamp"))
+	}))
+	defer server.Close()
+
+	// no limit, the skew is only recorded
+	fetcher := DefaultFetcher{}
+	_, ok := fetcher.ClockSkew()
+	assert.False(t, ok)
+	date = time.Now().Add(-2 * time.Hour).UTC().Format(http.TimeFormat)
+	_, err := fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+	assert.NoError(t, err)
+	skew, ok := fetcher.ClockSkew()
+	assert.True(t, ok)
+	assert.InDelta(t, float64(-2*time.Hour), float64(skew), float64(5*time.Second))
+
+	// a skew above the limit is only logged by default
+	fetcher = DefaultFetcher{MaxClockSkew: time.Hour}
+	_, err = fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+	assert.NoError(t, err)
+
+	// and errors out in strict mode, whether the server is behind or ahead
+	fetcher = DefaultFetcher{MaxClockSkew: time.Hour, StrictClockSkew: true}
+	_, err = fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrClockSkew{})
+	assert.ErrorIs(t, err, metadata.ErrDownload{})
+	date = time.Now().Add(2 * time.Hour).UTC().Format(http.TimeFormat)
+	_, err = fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+	assert.ErrorIs(t, err, metadata.ErrClockSkew{})
+	skew, _ = fetcher.ClockSkew()
+	assert.InDelta(t, float64(2*time.Hour), float64(skew), float64(5*time.Second))
+
+	// a skew within the limit is fine
+	date = time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	data, err := fetcher.DownloadFile(server.URL, 512000, 15*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("timestamp"), data)
+}
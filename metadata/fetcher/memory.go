@@ -0,0 +1,67 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package fetcher
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+)
+
+// MemoryFetcher implements Fetcher by serving files from memory, keyed by
+// their full URL. It is meant for tests, no network access is done
+type MemoryFetcher struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// NewMemoryFetcher creates a MemoryFetcher serving files, a map of URL to
+// file content
+func NewMemoryFetcher(files map[string][]byte) *MemoryFetcher {
+	f := &MemoryFetcher{files: map[string][]byte{}}
+	for urlPath, data := range files {
+		f.files[urlPath] = data
+	}
+	return f
+}
+
+// SetFile serves data at urlPath, replacing any previous content
+func (f *MemoryFetcher) SetFile(urlPath string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[urlPath] = data
+}
+
+// DeleteFile stops serving urlPath
+func (f *MemoryFetcher) DeleteFile(urlPath string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, urlPath)
+}
+
+// DownloadFile returns the file served at urlPath, errors out with an HTTP
+// 404 error if there is none or if it's larger than maxLength
+func (f *MemoryFetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	data, ok := f.files[urlPath]
+	if !ok {
+		return nil, metadata.ErrDownloadHTTP{StatusCode: http.StatusNotFound, URL: urlPath}
+	}
+	if int64(len(data)) > maxLength {
+		return nil, metadata.ErrDownloadLengthMismatch{Msg: fmt.Sprintf("download failed for %s, length %d is larger than expected %d", urlPath, len(data), maxLength)}
+	}
+	return append([]byte{}, data...), nil
+}
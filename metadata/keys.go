@@ -12,17 +12,24 @@
 package metadata
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/secure-systems-lab/go-securesystemslib/cjson"
 	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
 )
 
 const (
@@ -31,15 +38,20 @@ const (
 	KeyTypeECDSA_SHA2_P256        = "ecdsa"
 	KeyTypeRSASSA_PSS_SHA256      = "rsa"
 	KeySchemeEd25519              = "ed25519"
+	KeySchemeEd25519ph            = "ed25519ph"
 	KeySchemeECDSA_SHA2_P256      = "ecdsa-sha2-nistp256"
+	KeySchemeECDSA_SHA2_P384      = "ecdsa-sha2-nistp384"
 	KeySchemeRSASSA_PSS_SHA256    = "rsassa-pss-sha256"
+	KeySchemeRSA_PKCS1v15_SHA256  = "rsa-pkcs1v15-sha256"
 )
 
-// ToPublicKey generate crypto.PublicKey from metadata type Key
+// ToPublicKey generate crypto.PublicKey from metadata type Key.
+// The key value may be PEM encoded, hex encoded DER (PKIX) or, for Ed25519
+// keys, the hex encoded raw public key
 func (k *Key) ToPublicKey() (crypto.PublicKey, error) {
 	switch k.Type {
 	case KeyTypeRSASSA_PSS_SHA256:
-		publicKey, err := cryptoutils.UnmarshalPEMToPublicKey([]byte(k.Value.PublicKey))
+		publicKey, err := decodePublicKey(k.Type, k.Value.PublicKey)
 		if err != nil {
 			return nil, err
 		}
@@ -53,7 +65,7 @@ func (k *Key) ToPublicKey() (crypto.PublicKey, error) {
 		}
 		return rsaKey, nil
 	case KeyTypeECDSA_SHA2_P256, KeyTypeECDSA_SHA2_P256_COMPAT: // handle "ecdsa" too as python-tuf/sslib keys are using it for keytype instead of https://theupdateframework.github.io/specification/latest/index.html#keytype-ecdsa-sha2-nistp256
-		publicKey, err := cryptoutils.UnmarshalPEMToPublicKey([]byte(k.Value.PublicKey))
+		publicKey, err := decodePublicKey(k.Type, k.Value.PublicKey)
 		if err != nil {
 			return nil, err
 		}
@@ -67,11 +79,14 @@ func (k *Key) ToPublicKey() (crypto.PublicKey, error) {
 		}
 		return ecdsaKey, nil
 	case KeyTypeEd25519:
-		publicKey, err := hex.DecodeString(k.Value.PublicKey)
+		publicKey, err := decodePublicKey(k.Type, k.Value.PublicKey)
 		if err != nil {
 			return nil, err
 		}
-		ed25519Key := ed25519.PublicKey(publicKey)
+		ed25519Key, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid ed25519 public key")
+		}
 		// done for verification - ref. https://github.com/theupdateframework/go-tuf/pull/357
 		if _, err := x509.MarshalPKIXPublicKey(ed25519Key); err != nil {
 			return nil, err
@@ -81,6 +96,32 @@ func (k *Key) ToPublicKey() (crypto.PublicKey, error) {
 	return nil, fmt.Errorf("unsupported public key type")
 }
 
+// decodePublicKey auto-detects the encoding of a key value and parses it.
+// PEM is tried first, then hex: 32 raw bytes are treated as an Ed25519 key
+// for Ed25519 key types, anything else is parsed as DER (PKIX)
+func decodePublicKey(keyType, value string) (crypto.PublicKey, error) {
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		return cryptoutils.UnmarshalPEMToPublicKey([]byte(value))
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("public key value is neither PEM nor hex encoded")
+	}
+	if keyType == KeyTypeEd25519 && len(data) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(data), nil
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		err = fmt.Errorf("failed to parse DER public key: %w", err)
+		if keyType == KeyTypeEd25519 {
+			// most likely a raw key of the wrong length
+			return nil, fmt.Errorf("invalid ed25519 public key: expected %d raw bytes, got %d: %w", ed25519.PublicKeySize, len(data), err)
+		}
+		return nil, err
+	}
+	return publicKey, nil
+}
+
 // KeyFromPublicKey generate metadata type Key from crypto.PublicKey
 func KeyFromPublicKey(k crypto.PublicKey) (*Key, error) {
 	key := &Key{}
@@ -96,6 +137,9 @@ func KeyFromPublicKey(k crypto.PublicKey) (*Key, error) {
 	case *ecdsa.PublicKey:
 		key.Type = KeyTypeECDSA_SHA2_P256
 		key.Scheme = KeySchemeECDSA_SHA2_P256
+		if k.Curve == elliptic.P384() {
+			key.Scheme = KeySchemeECDSA_SHA2_P384
+		}
 		pemKey, err := cryptoutils.MarshalPublicKeyToPEM(k)
 		if err != nil {
 			return nil, err
@@ -111,16 +155,249 @@ func KeyFromPublicKey(k crypto.PublicKey) (*Key, error) {
 	return key, nil
 }
 
+// KeyIDFromPEM returns the key ID of the PEM encoded public key pemBytes, as
+// it would be computed for the Key returned by KeyFromPublicKey
+func KeyIDFromPEM(pemBytes []byte) (string, error) {
+	publicKey, err := cryptoutils.UnmarshalPEMToPublicKey(pemBytes)
+	if err != nil {
+		return "", err
+	}
+	key, err := KeyFromPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return key.ID(), nil
+}
+
+// KeyIDFromFile returns the key ID of the PEM encoded public key in the file name
+func KeyIDFromFile(name string) (string, error) {
+	pemBytes, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return KeyIDFromPEM(pemBytes)
+}
+
+// Equal reports whether k and other are the same key, i.e. they have the
+// same key ID or the same scheme and public key, even if the public key is
+// encoded differently (e.g. PEM and hex encoded DER)
+func (k *Key) Equal(other *Key) bool {
+	if k == nil || other == nil {
+		return k == other
+	}
+	if k.ID() == other.ID() {
+		return true
+	}
+	if k.Scheme != other.Scheme {
+		return false
+	}
+	publicKey, err := k.ToPublicKey()
+	if err != nil {
+		return false
+	}
+	otherPublicKey, err := other.ToPublicKey()
+	if err != nil {
+		return false
+	}
+	equaler, ok := publicKey.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && equaler.Equal(otherPublicKey)
+}
+
+// DedupeKeys returns keys without the keys equal to an earlier one, see
+// Key.Equal. The order of the remaining keys is preserved
+func DedupeKeys(keys []*Key) []*Key {
+	res := []*Key{}
+	for _, key := range keys {
+		duplicate := false
+		for _, kept := range res {
+			if kept.Equal(key) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+// VerifySignature verifies sig over payload using the key. The hash function
+// and scheme are selected based on the key type. An ErrUnsignedMetadata error
+// is returned if the signature does not match
+func (k *Key) VerifySignature(sig Signature, payload []byte) error {
+	// convert to a PublicKey type
+	publicKey, err := k.ToPublicKey()
+	if err != nil {
+		return err
+	}
+	// Ed25519ph signatures are made over the SHA-512 digest of the payload
+	if k.Type == KeyTypeEd25519 && k.Scheme == KeySchemeEd25519ph {
+		ed25519Key, ok := publicKey.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("invalid ed25519 public key")
+		}
+		digest := sha512.Sum512(payload)
+		if err := ed25519.VerifyWithOptions(ed25519Key, digest[:], sig.Signature, &ed25519.Options{Hash: crypto.SHA512}); err != nil {
+			return ErrUnsignedMetadata{Msg: fmt.Sprintf("signature verification failed for key ID %s: %v", k.ID(), err)}
+		}
+		return nil
+	}
+	// load the verifiers for the scheme of this key, so that keys of
+	// different types can be used together in a role
+	verifiers, err := k.loadVerifiers(publicKey)
+	if err != nil {
+		return err
+	}
+	for _, verifier := range verifiers {
+		err = verifier.VerifySignature(bytes.NewReader(sig.Signature), bytes.NewReader(payload))
+		if err == nil {
+			return nil
+		}
+	}
+	return ErrUnsignedMetadata{Msg: fmt.Sprintf("signature verification failed for key ID %s: %v", k.ID(), err)}
+}
+
+// loadVerifiers returns the verifiers accepted for the key scheme, in order.
+// The scheme declared by the key is enforced, e.g. an rsassa-pss-sha256 key
+// doesn't verify PKCS #1 v1.5 signatures
+func (k *Key) loadVerifiers(publicKey crypto.PublicKey) ([]signature.Verifier, error) {
+	switch k.Scheme {
+	case KeySchemeEd25519:
+		if _, ok := publicKey.(ed25519.PublicKey); !ok {
+			return nil, fmt.Errorf("invalid ed25519 public key")
+		}
+		verifier, err := signature.LoadVerifier(publicKey, crypto.Hash(0))
+		if err != nil {
+			return nil, err
+		}
+		return []signature.Verifier{verifier}, nil
+	case KeySchemeECDSA_SHA2_P256, KeySchemeECDSA_SHA2_P384:
+		ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid ecdsa public key")
+		}
+		curve, hash := elliptic.P256(), crypto.SHA256
+		if k.Scheme == KeySchemeECDSA_SHA2_P384 {
+			curve, hash = elliptic.P384(), crypto.SHA384
+		}
+		if ecdsaKey.Curve != curve {
+			return nil, fmt.Errorf("ecdsa public key curve %s doesn't match scheme %s", ecdsaKey.Curve.Params().Name, k.Scheme)
+		}
+		verifier, err := signature.LoadECDSAVerifier(ecdsaKey, hash)
+		if err != nil {
+			return nil, err
+		}
+		return []signature.Verifier{verifier}, nil
+	case KeySchemeRSASSA_PSS_SHA256:
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid rsa public key")
+		}
+		verifier, err := signature.LoadRSAPSSVerifier(rsaKey, crypto.SHA256, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		if err != nil {
+			return nil, err
+		}
+		return []signature.Verifier{verifier}, nil
+	case KeySchemeRSA_PKCS1v15_SHA256:
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid rsa public key")
+		}
+		verifier, err := signature.LoadRSAPKCS1v15Verifier(rsaKey, crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		return []signature.Verifier{verifier}, nil
+	default:
+		return nil, ErrValue{Msg: fmt.Sprintf("unsupported key scheme %s", k.Scheme)}
+	}
+}
+
+// SchemeSigner is implemented by signers whose key scheme can't be derived
+// from the type of their public key, e.g. Ed25519ph signers. The key ID of
+// the signatures they make is computed using that scheme
+type SchemeSigner interface {
+	signature.Signer
+	KeyScheme() string
+}
+
+// ED25519phSigner is a SchemeSigner making Ed25519ph (pre-hashed) signatures
+// over the SHA-512 digest of the message. The signatures must be verified
+// with a key using the KeySchemeEd25519ph scheme
+type ED25519phSigner struct {
+	privateKey ed25519.PrivateKey
+}
+
+// LoadED25519phSigner returns an ED25519phSigner for privateKey
+func LoadED25519phSigner(privateKey ed25519.PrivateKey) (*ED25519phSigner, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid ed25519 private key")
+	}
+	return &ED25519phSigner{privateKey: privateKey}, nil
+}
+
+// SignMessage signs the SHA-512 digest of message with Ed25519ph
+func (s *ED25519phSigner) SignMessage(message io.Reader, _ ...signature.SignOption) ([]byte, error) {
+	data, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha512.Sum512(data)
+	return s.privateKey.Sign(nil, digest[:], &ed25519.Options{Hash: crypto.SHA512})
+}
+
+// PublicKey returns the Ed25519 public key of the signer
+func (s *ED25519phSigner) PublicKey(_ ...signature.PublicKeyOption) (crypto.PublicKey, error) {
+	return s.privateKey.Public(), nil
+}
+
+// KeyScheme returns KeySchemeEd25519ph
+func (s *ED25519phSigner) KeyScheme() string {
+	return KeySchemeEd25519ph
+}
+
 // ID returns the keyID value for the given Key
 func (k *Key) ID() string {
-	// the identifier is a hexdigest of the SHA-256 hash of the canonical form of the key
+	// the identifier is a hexdigest of the SHA-256 hash of the canonical form of the key,
+	// unless the legacy "keyid_hash_algorithms" field asks for another algorithm
 	if k.id == "" {
 		data, err := cjson.EncodeCanonical(k)
 		if err != nil {
 			panic(fmt.Errorf("error creating key ID: %w", err))
 		}
-		digest := sha256.Sum256(data)
-		k.id = hex.EncodeToString(digest[:])
+		var digest []byte
+		switch k.keyIDHashAlgorithm() {
+		case "sha512":
+			sum := sha512.Sum512(data)
+			digest = sum[:]
+		default:
+			sum := sha256.Sum256(data)
+			digest = sum[:]
+		}
+		k.id = hex.EncodeToString(digest)
 	}
 	return k.id
 }
+
+// keyIDHashAlgorithm returns the first supported hash algorithm listed in the
+// legacy "keyid_hash_algorithms" field of the key, defaulting to sha256
+func (k *Key) keyIDHashAlgorithm() string {
+	var algorithms []string
+	switch value := k.UnrecognizedFields["keyid_hash_algorithms"].(type) {
+	case []string:
+		algorithms = value
+	case []any:
+		for _, algorithm := range value {
+			if algorithm, ok := algorithm.(string); ok {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	for _, algorithm := range algorithms {
+		if algorithm == "sha256" || algorithm == "sha512" {
+			return algorithm
+		}
+	}
+	return "sha256"
+}
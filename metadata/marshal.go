@@ -12,9 +12,15 @@
 package metadata
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 )
 
 // The following marshal/unmarshal methods override the default behavior for for each TUF type
@@ -29,13 +35,16 @@ func (signed RootType) MarshalJSON() ([]byte, error) {
 	dict["spec_version"] = signed.SpecVersion
 	dict["consistent_snapshot"] = signed.ConsistentSnapshot
 	dict["version"] = signed.Version
-	dict["expires"] = signed.Expires
+	dict["expires"] = signed.Expires.UTC()
 	dict["keys"] = signed.Keys
 	dict["roles"] = signed.Roles
 	return json.Marshal(dict)
 }
 
 func (signed *RootType) UnmarshalJSON(data []byte) error {
+	if err := checkExpires(data); err != nil {
+		return err
+	}
 	type Alias RootType
 	var s Alias
 	if err := json.Unmarshal(data, &s); err != nil {
@@ -43,8 +52,8 @@ func (signed *RootType) UnmarshalJSON(data []byte) error {
 	}
 	*signed = RootType(s)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "_type")
@@ -66,12 +75,15 @@ func (signed SnapshotType) MarshalJSON() ([]byte, error) {
 	dict["_type"] = signed.Type
 	dict["spec_version"] = signed.SpecVersion
 	dict["version"] = signed.Version
-	dict["expires"] = signed.Expires
+	dict["expires"] = signed.Expires.UTC()
 	dict["meta"] = signed.Meta
 	return json.Marshal(dict)
 }
 
 func (signed *SnapshotType) UnmarshalJSON(data []byte) error {
+	if err := checkExpires(data); err != nil {
+		return err
+	}
 	type Alias SnapshotType
 	var s Alias
 	if err := json.Unmarshal(data, &s); err != nil {
@@ -79,8 +91,8 @@ func (signed *SnapshotType) UnmarshalJSON(data []byte) error {
 	}
 	*signed = SnapshotType(s)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "_type")
@@ -100,12 +112,15 @@ func (signed TimestampType) MarshalJSON() ([]byte, error) {
 	dict["_type"] = signed.Type
 	dict["spec_version"] = signed.SpecVersion
 	dict["version"] = signed.Version
-	dict["expires"] = signed.Expires
+	dict["expires"] = signed.Expires.UTC()
 	dict["meta"] = signed.Meta
 	return json.Marshal(dict)
 }
 
 func (signed *TimestampType) UnmarshalJSON(data []byte) error {
+	if err := checkExpires(data); err != nil {
+		return err
+	}
 	type Alias TimestampType
 	var s Alias
 	if err := json.Unmarshal(data, &s); err != nil {
@@ -113,8 +128,8 @@ func (signed *TimestampType) UnmarshalJSON(data []byte) error {
 	}
 	*signed = TimestampType(s)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "_type")
@@ -134,7 +149,7 @@ func (signed TargetsType) MarshalJSON() ([]byte, error) {
 	dict["_type"] = signed.Type
 	dict["spec_version"] = signed.SpecVersion
 	dict["version"] = signed.Version
-	dict["expires"] = signed.Expires
+	dict["expires"] = signed.Expires.UTC()
 	dict["targets"] = signed.Targets
 	if signed.Delegations != nil {
 		dict["delegations"] = signed.Delegations
@@ -143,6 +158,9 @@ func (signed TargetsType) MarshalJSON() ([]byte, error) {
 }
 
 func (signed *TargetsType) UnmarshalJSON(data []byte) error {
+	if err := checkExpires(data); err != nil {
+		return err
+	}
 	type Alias TargetsType
 	var s Alias
 	if err := json.Unmarshal(data, &s); err != nil {
@@ -154,9 +172,12 @@ func (signed *TargetsType) UnmarshalJSON(data []byte) error {
 	for name, targetFile := range signed.Targets {
 		targetFile.Path = name
 	}
+	if err := signed.ValidateDelegations(); err != nil {
+		return err
+	}
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "_type")
@@ -175,7 +196,7 @@ func (signed MetaFiles) MarshalJSON() ([]byte, error) {
 		copyMapValues(signed.UnrecognizedFields, dict)
 	}
 	// length and hashes are optional
-	if signed.Length != 0 {
+	if signed.HasLength() {
 		dict["length"] = signed.Length
 	}
 	if len(signed.Hashes) != 0 {
@@ -193,11 +214,12 @@ func (signed *MetaFiles) UnmarshalJSON(data []byte) error {
 	}
 	*signed = MetaFiles(s)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
-	delete(dict, "length")
+	// tell an explicit zero length apart from an absent one
+	signed.optionalLength.unmarshal(dict, signed.Length)
 	delete(dict, "hashes")
 	delete(dict, "version")
 	signed.UnrecognizedFields = dict
@@ -209,7 +231,10 @@ func (signed TargetFiles) MarshalJSON() ([]byte, error) {
 	if len(signed.UnrecognizedFields) != 0 {
 		copyMapValues(signed.UnrecognizedFields, dict)
 	}
-	dict["length"] = signed.Length
+	// length is optional, e.g. for ecosystems relying solely on hashes
+	if signed.HasLength() {
+		dict["length"] = signed.Length
+	}
 	dict["hashes"] = signed.Hashes
 	if signed.Custom != nil {
 		dict["custom"] = signed.Custom
@@ -225,13 +250,17 @@ func (signed *TargetFiles) UnmarshalJSON(data []byte) error {
 	}
 	*signed = TargetFiles(s)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
-	delete(dict, "length")
+	// tell an explicit zero length apart from an absent one
+	signed.optionalLength.unmarshal(dict, signed.Length)
 	delete(dict, "hashes")
-	delete(dict, "custom")
+	// an explicit "custom": null is kept as is so the signed bytes don't change
+	if signed.Custom != nil {
+		delete(dict, "custom")
+	}
 	signed.UnrecognizedFields = dict
 	return nil
 }
@@ -256,8 +285,8 @@ func (key *Key) UnmarshalJSON(data []byte) error {
 	// nolint
 	*key = Key(a)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "keytype")
@@ -279,8 +308,8 @@ func (meta Metadata[T]) MarshalJSON() ([]byte, error) {
 
 func (meta *Metadata[T]) UnmarshalJSON(data []byte) error {
 	tmp := any(new(T))
-	var m map[string]any
-	if err := json.Unmarshal(data, &m); err != nil {
+	m, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	switch tmp.(type) {
@@ -355,8 +384,8 @@ func (s *Signature) UnmarshalJSON(data []byte) error {
 	}
 	*s = Signature(a)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "keyid")
@@ -382,8 +411,8 @@ func (kv *KeyVal) UnmarshalJSON(data []byte) error {
 	}
 	*kv = KeyVal(a)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "public")
@@ -409,8 +438,8 @@ func (role *Role) UnmarshalJSON(data []byte) error {
 	}
 	*role = Role(a)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "keyids")
@@ -441,9 +470,22 @@ func (d *Delegations) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	*d = Delegations(a)
+	// delegated role names must be unique and can't be top-level role names
+	names := map[string]bool{}
+	for _, role := range d.Roles {
+		for _, topLevelRole := range TOP_LEVEL_ROLE_NAMES {
+			if role.Name == topLevelRole {
+				return ErrValue{Msg: fmt.Sprintf("failed to unmarshal delegations: delegated role name %s is reserved for a top-level role", role.Name)}
+			}
+		}
+		if names[role.Name] {
+			return ErrValue{Msg: fmt.Sprintf("failed to unmarshal delegations: multiple delegated roles named %s", role.Name)}
+		}
+		names[role.Name] = true
+	}
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "keys")
@@ -482,8 +524,20 @@ func (role *DelegatedRole) UnmarshalJSON(data []byte) error {
 	}
 	*role = DelegatedRole(a)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
+		return err
+	}
+	// make sure we have exactly one of the two (per spec)
+	_, hasPaths := dict["paths"]
+	_, hasPathHashPrefixes := dict["path_hash_prefixes"]
+	if hasPaths && hasPathHashPrefixes {
+		return ErrValue{Msg: fmt.Sprintf("failed to unmarshal delegated role %s: not allowed to have both \"paths\" and \"path_hash_prefixes\" present", role.Name)}
+	}
+	if !hasPaths && !hasPathHashPrefixes {
+		return ErrValue{Msg: fmt.Sprintf("failed to unmarshal delegated role %s: one of \"paths\" or \"path_hash_prefixes\" must be present", role.Name)}
+	}
+	if err := role.validatePaths(); err != nil {
 		return err
 	}
 	delete(dict, "name")
@@ -516,8 +570,8 @@ func (role *SuccinctRoles) UnmarshalJSON(data []byte) error {
 	}
 	*role = SuccinctRoles(a)
 
-	var dict map[string]any
-	if err := json.Unmarshal(data, &dict); err != nil {
+	dict, err := unmarshalFields(data)
+	if err != nil {
 		return err
 	}
 	delete(dict, "keyids")
@@ -528,6 +582,32 @@ func (role *SuccinctRoles) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (h *Hashes) UnmarshalJSON(data []byte) error {
+	type Alias Hashes
+	var a Alias
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	// the digest length must match the hash algorithm, unknown algorithms
+	// are rejected when verifying
+	for algorithm, digest := range a {
+		var size int
+		switch algorithm {
+		case "sha256":
+			size = sha256.Size
+		case "sha512":
+			size = sha512.Size
+		default:
+			continue
+		}
+		if len(digest) != size {
+			return ErrValue{Msg: fmt.Sprintf("failed to unmarshal hashes: %s hash must be %d bytes long, got %d", algorithm, size, len(digest))}
+		}
+	}
+	*h = Hashes(a)
+	return nil
+}
+
 func (b *HexBytes) UnmarshalJSON(data []byte) error {
 	if len(data) < 2 || len(data)%2 != 0 || data[0] != '"' || data[len(data)-1] != '"' {
 		return errors.New("tuf: invalid JSON hex bytes")
@@ -553,6 +633,41 @@ func (b HexBytes) String() string {
 	return hex.EncodeToString(b)
 }
 
+// checkExpires verifies that the expires field of a Signed portion, if
+// present, is an RFC3339 timestamp in UTC, i.e. using the "Z" suffix
+func checkExpires(data []byte) error {
+	var signed struct {
+		Expires *string `json:"expires"`
+	}
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return ErrInvalidExpiry{Msg: fmt.Sprintf("expires must be a string: %v", err)}
+	}
+	if signed.Expires == nil {
+		return nil
+	}
+	expires := *signed.Expires
+	if _, err := time.Parse(time.RFC3339Nano, expires); err != nil {
+		return ErrInvalidExpiry{Msg: fmt.Sprintf("expires %q is not a valid RFC3339 timestamp", expires)}
+	}
+	if !strings.HasSuffix(expires, "Z") {
+		return ErrInvalidExpiry{Msg: fmt.Sprintf("expires %q must be in UTC", expires)}
+	}
+	return nil
+}
+
+// unmarshalFields decodes the JSON object in data into a map, keeping
+// numbers as json.Number so that large integers, e.g. in unrecognized
+// fields, are not rounded through float64 and re-serialize unchanged
+func unmarshalFields(data []byte) (map[string]any, error) {
+	var dict map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&dict); err != nil {
+		return nil, err
+	}
+	return dict, nil
+}
+
 // copyMapValues copies the values of the src map to dst
 func copyMapValues(src, dst map[string]any) {
 	for k, v := range src {
@@ -13,7 +13,7 @@ package metadata
 
 import (
 	"bytes"
-	"crypto"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -21,21 +21,32 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
 	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/secure-systems-lab/go-securesystemslib/cjson"
 	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/options"
 	"golang.org/x/exp/slices"
 )
 
+// trailingNewline is set with SetTrailingNewline, it is read and written
+// atomically since metadata may be serialized concurrently
+var trailingNewline atomic.Bool
+
+// utf8BOM is the UTF-8 byte order mark
+var utf8BOM = []byte("\xef\xbb\xbf")
+
 // Root return new metadata instance of type Root
 func Root(expires ...time.Time) *Metadata[RootType] {
 	// expire now if there's nothing set
@@ -133,8 +144,9 @@ func Targets(expires ...time.Time) *Metadata[TargetsType] {
 // TargetFile return new metadata instance of type TargetFiles
 func TargetFile() *TargetFiles {
 	return &TargetFiles{
-		Length: 0,
-		Hashes: Hashes{},
+		Length:         0,
+		Hashes:         Hashes{},
+		optionalLength: optionalLength{zeroLength: true},
 	}
 }
 
@@ -152,6 +164,69 @@ func MetaFile(version int64) *MetaFiles {
 	}
 }
 
+// NewDelegatedRole returns a new DelegatedRole named name with the keys and
+// threshold of role, trusted for paths
+func NewDelegatedRole(name string, role *Role, paths []string, terminating bool) *DelegatedRole {
+	return &DelegatedRole{
+		Name:        name,
+		KeyIDs:      slices.Clone(role.KeyIDs),
+		Threshold:   role.Threshold,
+		Terminating: terminating,
+		Paths:       slices.Clone(paths),
+	}
+}
+
+// BuildSnapshot returns new snapshot metadata with a meta entry for each of
+// the targets metadata, keyed by role name. Each entry holds the version,
+// length and sha256 hash of the targets metadata as written by ToFile(name, false)
+func BuildSnapshot(targets map[string]*Metadata[TargetsType], expires ...time.Time) (*Metadata[SnapshotType], error) {
+	snapshot := Snapshot(expires...)
+	snapshot.Signed.Meta = make(map[string]*MetaFiles, len(targets))
+	for roleName, role := range targets {
+		data, err := role.ToBytes(false)
+		if err != nil {
+			return nil, err
+		}
+		metaFile, err := buildMetaFile(role.Signed.Version, data)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Signed.Meta[fmt.Sprintf("%s.json", roleName)] = metaFile
+	}
+	return snapshot, nil
+}
+
+// BuildTimestamp returns new timestamp metadata with a meta entry holding the
+// version, length and sha256 hash of the snapshot metadata as written by
+// ToFile(name, false)
+func BuildTimestamp(snapshot *Metadata[SnapshotType], expires ...time.Time) (*Metadata[TimestampType], error) {
+	data, err := snapshot.ToBytes(false)
+	if err != nil {
+		return nil, err
+	}
+	metaFile, err := buildMetaFile(snapshot.Signed.Version, data)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := Timestamp(expires...)
+	timestamp.Signed.Meta = map[string]*MetaFiles{
+		fmt.Sprintf("%s.json", SNAPSHOT): metaFile,
+	}
+	return timestamp, nil
+}
+
+// buildMetaFile returns the MetaFiles describing the metadata serialized as data
+func buildMetaFile(version int64, data []byte) (*MetaFiles, error) {
+	targetFile, err := TargetFile().FromBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	metaFile := MetaFile(version)
+	metaFile.Length = targetFile.Length
+	metaFile.Hashes = targetFile.Hashes
+	return metaFile, nil
+}
+
 // FromFile load metadata from file
 func (meta *Metadata[T]) FromFile(name string) (*Metadata[T], error) {
 	in, err := os.Open(name)
@@ -183,13 +258,110 @@ func (meta *Metadata[T]) FromBytes(data []byte) (*Metadata[T], error) {
 	return meta, nil
 }
 
-// ToBytes serialize metadata to bytes
+// LoadGeneric deserializes the envelope of metadata of any type, including
+// types unknown to this package, e.g. roles added by future specification
+// versions. The signed part is returned as is and isn't otherwise validated
+func LoadGeneric(data []byte) (*GenericMetadata, error) {
+	var envelope struct {
+		Signed     json.RawMessage `json:"signed"`
+		Signatures []Signature     `json:"signatures"`
+	}
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	var signed struct {
+		Type *string `json:"_type"`
+	}
+	if len(envelope.Signed) > 0 {
+		if err := json.Unmarshal(envelope.Signed, &signed); err != nil {
+			return nil, err
+		}
+	}
+	if signed.Type == nil {
+		return nil, ErrValue{Msg: "failed to read metadata type: missing signed._type"}
+	}
+	if err := checkUniqueSignatures(envelope.Signatures); err != nil {
+		return nil, err
+	}
+	log.Info("Loaded generic metadata from bytes", "type", *signed.Type)
+	return &GenericMetadata{
+		Type:       *signed.Type,
+		Signed:     envelope.Signed,
+		Signatures: envelope.Signatures,
+	}, nil
+}
+
+// ToBytes serialize metadata to bytes. It fails if Signed was modified after
+// the existing signatures were created or loaded, see ClearSignatures
 func (meta *Metadata[T]) ToBytes(pretty bool) ([]byte, error) {
 	log.Info("Writing metadata to bytes")
+	if err := meta.checkStaleSignatures(); err != nil {
+		return nil, err
+	}
+	return meta.UnsafeToBytes(pretty)
+}
+
+// UnsafeToBytes serialize metadata to bytes without checking for stale
+// signatures, so the result may carry signatures over a different payload
+func (meta *Metadata[T]) UnsafeToBytes(pretty bool) ([]byte, error) {
+	var data []byte
+	var err error
 	if pretty {
-		return json.MarshalIndent(*meta, "", "\t")
+		data, err = json.MarshalIndent(*meta, "", "\t")
+	} else {
+		data, err = json.Marshal(*meta)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if trailingNewline.Load() {
+		data = append(data, '\n')
+	}
+	return data, nil
+}
+
+// HashBytes returns the hashes, sha256 by default, and the length of meta as
+// serialized by ToBytes(false), i.e. the exact bytes to publish, ready to be
+// set in the MetaFiles describing it in snapshot or timestamp
+func (meta *Metadata[T]) HashBytes(algorithms ...string) (Hashes, int64, error) {
+	data, err := meta.ToBytes(false)
+	if err != nil {
+		return nil, 0, err
+	}
+	targetFile, err := TargetFile().FromBytes("", data, algorithms...)
+	if err != nil {
+		return nil, 0, err
 	}
-	return json.Marshal(*meta)
+	return targetFile.Hashes, targetFile.Length, nil
+}
+
+// SetTrailingNewline sets whether ToBytes, UnsafeToBytes and ToFile append a
+// trailing newline to the serialized metadata. The newline is not part of
+// the signed payload. Defaults to false
+func SetTrailingNewline(enabled bool) {
+	trailingNewline.Store(enabled)
+}
+
+// GetTrailingNewline reports whether a trailing newline is appended to the
+// serialized metadata, see SetTrailingNewline
+func GetTrailingNewline() bool {
+	return trailingNewline.Load()
+}
+
+// ToCanonicalBytes serialize metadata to canonical JSON with the signatures
+// sorted by key ID, so unchanged metadata is always serialized to the same
+// bytes regardless of the order it was signed in
+func (meta *Metadata[T]) ToCanonicalBytes() ([]byte, error) {
+	if err := meta.checkStaleSignatures(); err != nil {
+		return nil, err
+	}
+	sorted := *meta
+	sorted.Signatures = slices.Clone(meta.Signatures)
+	slices.SortStableFunc(sorted.Signatures, func(a, b Signature) bool {
+		return a.KeyID < b.KeyID
+	})
+	return encodeCanonical(sorted)
 }
 
 // ToFile save metadata to file
@@ -204,18 +376,29 @@ func (meta *Metadata[T]) ToFile(name string, pretty bool) error {
 
 // Sign create signature over Signed and assign it to Signatures
 func (meta *Metadata[T]) Sign(signer signature.Signer) (*Signature, error) {
+	return meta.SignWithContext(context.Background(), signer)
+}
+
+// SignWithContext create signature over Signed and assign it to Signatures.
+// The context is passed to the signer, e.g. for cancelling a remote (KMS)
+// signing request. Errors returned by the signer are wrapped so callers can
+// inspect them, e.g. to retry on transient failures
+func (meta *Metadata[T]) SignWithContext(ctx context.Context, signer signature.Signer) (*Signature, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsignedMetadata{Msg: "problem signing metadata"}, err)
+	}
 	// encode the Signed part to canonical JSON so signatures are consistent
-	payload, err := cjson.EncodeCanonical(meta.Signed)
+	payload, err := meta.SignedBytes()
 	if err != nil {
 		return nil, err
 	}
 	// sign the Signed part
-	sb, err := signer.SignMessage(bytes.NewReader(payload))
+	sb, err := signer.SignMessage(bytes.NewReader(payload), options.WithContext(ctx))
 	if err != nil {
-		return nil, ErrUnsignedMetadata{Msg: "problem signing metadata"}
+		return nil, fmt.Errorf("%w: %w", ErrUnsignedMetadata{Msg: "problem signing metadata"}, err)
 	}
 	// get the signer's PublicKey
-	publ, err := signer.PublicKey()
+	publ, err := signer.PublicKey(options.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -224,6 +407,15 @@ func (meta *Metadata[T]) Sign(signer signature.Signer) (*Signature, error) {
 	if err != nil {
 		return nil, err
 	}
+	if schemeSigner, ok := signer.(SchemeSigner); ok {
+		key.Scheme = schemeSigner.KeyScheme()
+	}
+	// drop signatures made over a previous version of the Signed part
+	digest := sha256.Sum256(payload)
+	if len(meta.Signatures) > 0 && meta.signedDigest != nil && !bytes.Equal(meta.signedDigest, digest[:]) {
+		log.Info("Clearing stale signatures before signing")
+		meta.Signatures = []Signature{}
+	}
 	// build signature
 	sig := &Signature{
 		KeyID:     key.ID(),
@@ -231,16 +423,160 @@ func (meta *Metadata[T]) Sign(signer signature.Signer) (*Signature, error) {
 	}
 	// update the Signatures part
 	meta.Signatures = append(meta.Signatures, *sig)
+	meta.signedDigest = digest[:]
 	// return the new signature
 	log.Info("Signed metadata with key", "ID", key.ID())
 	return sig, nil
 }
 
+// SignedBytes returns the canonical JSON encoding of the Signed part, i.e. the
+// exact payload that Sign passes to the signer
+func (meta *Metadata[T]) SignedBytes() ([]byte, error) {
+	return encodeCanonical(meta.Signed)
+}
+
+// AddSignature appends a signature that was produced externally over
+// SignedBytes, e.g. by a remote signing service. The signature must be made
+// by key over the current Signed part. Adding a signature which is already
+// present does nothing, while a different signature for a key ID already
+// present is rejected
+func (meta *Metadata[T]) AddSignature(sig Signature, key *Key) error {
+	if sig.KeyID == "" {
+		return ErrValue{Msg: "signature has no key ID"}
+	}
+	if key == nil {
+		return ErrValue{Msg: fmt.Sprintf("no key to verify signature for key ID %s", sig.KeyID)}
+	}
+	if sig.KeyID != key.ID() {
+		return ErrValue{Msg: fmt.Sprintf("signature key ID %s does not match key ID %s", sig.KeyID, key.ID())}
+	}
+	payload, err := meta.SignedBytes()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(payload)
+	digest := sum[:]
+	stale := len(meta.Signatures) > 0 && meta.signedDigest != nil && !bytes.Equal(meta.signedDigest, digest)
+	if !stale {
+		for _, s := range meta.Signatures {
+			if s.KeyID != sig.KeyID {
+				continue
+			}
+			if bytes.Equal(s.Signature, sig.Signature) {
+				log.Info("Signature for key already present", "ID", sig.KeyID)
+				return nil
+			}
+			return ErrValue{Msg: fmt.Sprintf("conflicting signature for key ID %s, a different signature by that key is already present", sig.KeyID)}
+		}
+	}
+	if err := key.VerifySignature(sig, payload); err != nil {
+		return err
+	}
+	// drop signatures made over a previous version of the Signed part
+	if stale {
+		log.Info("Clearing stale signatures before adding signature")
+		meta.Signatures = []Signature{}
+	}
+	meta.Signatures = append(meta.Signatures, sig)
+	meta.signedDigest = digest
+	log.Info("Added signature for key", "ID", sig.KeyID)
+	return nil
+}
+
+// CollectSignatures merges sigs, e.g. collected from several signers over
+// time, into Signatures and reports whether threshold signatures by keys,
+// a map of key ID to key, are now present. Every new signature must be made
+// by one of keys over the current Signed part, otherwise none of sigs is
+// merged. Signatures already present are skipped, while a different signature
+// for a key ID already present or earlier in sigs rejects the whole batch.
+// Signatures made over a previous version of the Signed part are dropped first
+// and signatures already present only count towards threshold if they verify
+func (meta *Metadata[T]) CollectSignatures(sigs []Signature, keys map[string]*Key, threshold int) (bool, error) {
+	if threshold < 1 {
+		return false, ErrValue{Msg: fmt.Sprintf("threshold must be at least 1, got %d", threshold)}
+	}
+	payload, err := meta.SignedBytes()
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(payload)
+	digest := sum[:]
+	collected := meta.Signatures
+	if len(collected) > 0 && meta.signedDigest != nil && !bytes.Equal(meta.signedDigest, digest) {
+		log.Info("Clearing stale signatures before collecting signatures")
+		collected = []Signature{}
+	}
+	present := map[string]HexBytes{}
+	for _, sig := range collected {
+		present[sig.KeyID] = sig.Signature
+	}
+	// validate all of the new signatures before merging any
+	newSigs := []Signature{}
+	verified := map[string]bool{}
+	for _, sig := range sigs {
+		if presentSig, ok := present[sig.KeyID]; ok {
+			if !bytes.Equal(presentSig, sig.Signature) {
+				return false, ErrValue{Msg: fmt.Sprintf("conflicting signature for key ID %s, a different signature by that key is already present", sig.KeyID)}
+			}
+			log.Info("Skipping signature for key already collected", "ID", sig.KeyID)
+			continue
+		}
+		key, ok := keys[sig.KeyID]
+		if !ok {
+			return false, ErrValue{Msg: fmt.Sprintf("no key to verify signature for key ID %s", sig.KeyID)}
+		}
+		if err := key.VerifySignature(sig, payload); err != nil {
+			return false, err
+		}
+		present[sig.KeyID] = sig.Signature
+		verified[sig.KeyID] = true
+		newSigs = append(newSigs, sig)
+	}
+	meta.Signatures = append(append([]Signature{}, collected...), newSigs...)
+	meta.signedDigest = digest
+	for _, sig := range collected {
+		key, ok := keys[sig.KeyID]
+		if !ok || verified[sig.KeyID] {
+			continue
+		}
+		if err := key.VerifySignature(sig, payload); err != nil {
+			log.Info("Not counting invalid signature for key", "ID", sig.KeyID)
+			continue
+		}
+		verified[sig.KeyID] = true
+	}
+	count := len(verified)
+	log.Info("Collected signatures", "new", len(newSigs), "valid", count, "threshold", threshold)
+	return count >= threshold, nil
+}
+
 // VerifyDelegate verifies that delegatedMetadata is signed with the required
 // threshold of keys for the delegated role delegatedRole
 func (meta *Metadata[T]) VerifyDelegate(delegatedRole string, delegatedMetadata any) error {
+	return meta.verifyDelegate(delegatedRole, delegatedMetadata, false, 1)
+}
+
+// VerifyDelegateStrict is like VerifyDelegate but also verifies the signatures
+// beyond the threshold. Any invalid signature from a key of the delegated role
+// fails the verification, even if the threshold is met, as it may indicate a
+// compromised key or corrupted metadata
+func (meta *Metadata[T]) VerifyDelegateStrict(delegatedRole string, delegatedMetadata any) error {
+	return meta.verifyDelegate(delegatedRole, delegatedMetadata, true, 1)
+}
+
+// VerifyDelegateConcurrent is like VerifyDelegate but verifies the signatures
+// of the delegated role keys concurrently, with at most workers goroutines.
+// It speeds up the verification of roles with many keys that are slow to
+// verify, e.g. RSA keys. The outcome is the same as VerifyDelegate's, a
+// workers value of 1 or less verifies sequentially
+func (meta *Metadata[T]) VerifyDelegateConcurrent(delegatedRole string, delegatedMetadata any, workers int) error {
+	return meta.verifyDelegate(delegatedRole, delegatedMetadata, false, workers)
+}
+
+func (meta *Metadata[T]) verifyDelegate(delegatedRole string, delegatedMetadata any, strict bool, workers int) error {
 	i := any(meta)
 	signingKeys := map[string]bool{}
+	invalidKeyIDs := []string{}
 	var keys map[string]*Key
 	var roleKeyIDs []string
 	var roleThreshold int
@@ -290,79 +626,85 @@ func (meta *Metadata[T]) VerifyDelegate(delegatedRole string, delegatedMetadata
 	if len(roleKeyIDs) == 0 {
 		return ErrValue{Msg: fmt.Sprintf("no delegation found for %s", delegatedRole)}
 	}
-	// loop through each role keyID
-	for _, keyID := range roleKeyIDs {
-		key, ok := keys[keyID]
-		if !ok {
+	// collect the signature of each role keyID and the payload we'll verify
+	// based on the Signed part of the delegated metadata
+	signatures := make([]Signature, len(roleKeyIDs))
+	var payload []byte
+	for idx, keyID := range roleKeyIDs {
+		if _, ok := keys[keyID]; !ok {
 			return ErrValue{Msg: fmt.Sprintf("key with ID %s not found in %s keyids", keyID, delegatedRole)}
 		}
-		sign := Signature{}
-		var payload []byte
-		// convert to a PublicKey type
-		publicKey, err := key.ToPublicKey()
-		if err != nil {
-			return err
-		}
-		// use corresponding hash function for key type
-		hash := crypto.Hash(0)
-		if key.Type != KeyTypeEd25519 {
-			hash = crypto.SHA256
-		}
-		// load a verifier based on that key
-		verifier, err := signature.LoadVerifier(publicKey, hash)
-		if err != nil {
-			return err
-		}
-		// collect the signature for that key and build the payload we'll verify
-		// based on the Signed part of the delegated metadata
+		var delegatedSignatures []Signature
+		var err error
 		switch d := delegatedMetadata.(type) {
 		case *Metadata[RootType]:
-			for _, signature := range d.Signatures {
-				if signature.KeyID == keyID {
-					sign = signature
-				}
-			}
-			payload, err = cjson.EncodeCanonical(d.Signed)
-			if err != nil {
-				return err
+			delegatedSignatures = d.Signatures
+			if payload == nil {
+				payload, err = encodeCanonical(d.Signed)
 			}
 		case *Metadata[SnapshotType]:
-			for _, signature := range d.Signatures {
-				if signature.KeyID == keyID {
-					sign = signature
-				}
-			}
-			payload, err = cjson.EncodeCanonical(d.Signed)
-			if err != nil {
-				return err
+			delegatedSignatures = d.Signatures
+			if payload == nil {
+				payload, err = encodeCanonical(d.Signed)
 			}
 		case *Metadata[TimestampType]:
-			for _, signature := range d.Signatures {
-				if signature.KeyID == keyID {
-					sign = signature
-				}
-			}
-			payload, err = cjson.EncodeCanonical(d.Signed)
-			if err != nil {
-				return err
+			delegatedSignatures = d.Signatures
+			if payload == nil {
+				payload, err = encodeCanonical(d.Signed)
 			}
 		case *Metadata[TargetsType]:
-			for _, signature := range d.Signatures {
-				if signature.KeyID == keyID {
-					sign = signature
-				}
-			}
-			payload, err = cjson.EncodeCanonical(d.Signed)
-			if err != nil {
-				return err
+			delegatedSignatures = d.Signatures
+			if payload == nil {
+				payload, err = encodeCanonical(d.Signed)
 			}
 		default:
 			return ErrType{Msg: "unknown delegated metadata type"}
 		}
-		// verify if the signature for that payload corresponds to the given key
-		if err := verifier.VerifySignature(bytes.NewReader(sign.Signature), bytes.NewReader(payload)); err != nil {
+		if err != nil {
+			return err
+		}
+		for _, signature := range delegatedSignatures {
+			if signature.KeyID == keyID {
+				signatures[idx] = signature
+			}
+		}
+	}
+	// verify if the signature for that payload corresponds to the given key,
+	// the results are processed in the order of the role keyIDs whether they
+	// were verified concurrently or not
+	results := make([]error, len(roleKeyIDs))
+	verify := func(idx int) {
+		results[idx] = keys[roleKeyIDs[idx]].VerifySignature(signatures[idx], payload)
+	}
+	if workers > 1 && len(roleKeyIDs) > 1 {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, workers)
+		for idx := range roleKeyIDs {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(idx int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				verify(idx)
+			}(idx)
+		}
+		wg.Wait()
+	} else {
+		for idx := range roleKeyIDs {
+			verify(idx)
+		}
+	}
+	for idx, keyID := range roleKeyIDs {
+		if err := results[idx]; err != nil {
+			if !errors.Is(err, ErrUnsignedMetadata{}) {
+				return err
+			}
 			// failed to verify the metadata with that key ID
 			log.Info("Failed to verify %s with key ID %s", delegatedRole, keyID)
+			// a signature was made with that key ID but it is invalid
+			if signatures[idx].KeyID != "" {
+				invalidKeyIDs = append(invalidKeyIDs, keyID)
+			}
 		} else {
 			// save the verified keyID only if verification passed
 			signingKeys[keyID] = true
@@ -374,10 +716,80 @@ func (meta *Metadata[T]) VerifyDelegate(delegatedRole string, delegatedMetadata
 		log.Info("Verifying failed, not enough signatures", "role", delegatedRole, "got", len(signingKeys), "want", roleThreshold)
 		return ErrUnsignedMetadata{Msg: fmt.Sprintf("Verifying %s failed, not enough signatures, got %d, want %d", delegatedRole, len(signingKeys), roleThreshold)}
 	}
+	if strict && len(invalidKeyIDs) > 0 {
+		log.Info("Verifying failed, invalid signatures", "role", delegatedRole, "IDs", invalidKeyIDs)
+		return ErrUnsignedMetadata{Msg: fmt.Sprintf("Verifying %s failed, invalid signatures from key IDs %s", delegatedRole, strings.Join(invalidKeyIDs, ", "))}
+	}
 	log.Info("Verified successfully", "role", delegatedRole)
 	return nil
 }
 
+// VerifyRootChain verifies the root metadata versions in next, in order,
+// starting from the trusted root. Each new root must have the version
+// following its predecessor and be signed by a threshold of keys of both
+// its predecessor and itself. The final trusted root is returned, or the
+// error of the first root failing verification.
+// Note that expiry is not checked.
+func VerifyRootChain(trusted *Metadata[RootType], next ...[]byte) (*Metadata[RootType], error) {
+	return verifyRootChain(trusted, 1, next...)
+}
+
+// VerifyRootChainConcurrent is like VerifyRootChain but verifies the
+// signatures of each root concurrently, see VerifyDelegateConcurrent
+func VerifyRootChainConcurrent(trusted *Metadata[RootType], workers int, next ...[]byte) (*Metadata[RootType], error) {
+	return verifyRootChain(trusted, workers, next...)
+}
+
+func verifyRootChain(trusted *Metadata[RootType], workers int, next ...[]byte) (*Metadata[RootType], error) {
+	for _, rootData := range next {
+		newRoot, err := Root().FromBytes(rootData)
+		if err != nil {
+			return nil, err
+		}
+		// check metadata type matches root
+		if newRoot.Signed.Type != ROOT {
+			return nil, ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", ROOT, newRoot.Signed.Type)}
+		}
+		// verify that new root is signed by trusted root
+		err = trusted.VerifyDelegateConcurrent(ROOT, newRoot, workers)
+		if err != nil {
+			return nil, err
+		}
+		// verify version
+		if newRoot.Signed.Version != trusted.Signed.Version+1 {
+			return nil, ErrBadVersionNumber{Msg: fmt.Sprintf("bad version number, expected %d, got %d", trusted.Signed.Version+1, newRoot.Signed.Version)}
+		}
+		// verify that new root is signed by itself
+		err = newRoot.VerifyDelegateConcurrent(ROOT, newRoot, workers)
+		if err != nil {
+			return nil, err
+		}
+		trusted = newRoot
+	}
+	return trusted, nil
+}
+
+// Expires returns the expiration time of the Signed portion of metadata
+func (meta *Metadata[T]) Expires() time.Time {
+	switch signed := any(&meta.Signed).(type) {
+	case *RootType:
+		return signed.Expires
+	case *SnapshotType:
+		return signed.Expires
+	case *TimestampType:
+		return signed.Expires
+	case *TargetsType:
+		return signed.Expires
+	}
+	return time.Time{}
+}
+
+// IsExpired returns true if metadata is expired.
+// It checks if referenceTime is after the expiration time of Signed
+func (meta *Metadata[T]) IsExpired(referenceTime time.Time) bool {
+	return referenceTime.After(meta.Expires())
+}
+
 // IsExpired returns true if metadata is expired.
 // It checks if referenceTime is after Signed.Expires
 func (signed *RootType) IsExpired(referenceTime time.Time) bool {
@@ -402,6 +814,26 @@ func (signed *TargetsType) IsExpired(referenceTime time.Time) bool {
 	return referenceTime.After(signed.Expires)
 }
 
+// RoleVersion returns the version of roleName's metadata recorded in the
+// snapshot meta and whether there is one
+func (signed *SnapshotType) RoleVersion(roleName string) (int64, bool) {
+	metaFile, ok := signed.Meta[fmt.Sprintf("%s.json", roleName)]
+	if !ok || metaFile == nil {
+		return 0, false
+	}
+	return metaFile.Version, true
+}
+
+// SnapshotVersion returns the version of the snapshot metadata recorded in
+// the timestamp meta and whether there is one
+func (signed *TimestampType) SnapshotVersion() (int64, bool) {
+	metaFile, ok := signed.Meta[fmt.Sprintf("%s.json", SNAPSHOT)]
+	if !ok || metaFile == nil {
+		return 0, false
+	}
+	return metaFile.Version, true
+}
+
 // VerifyLengthHashes checks whether the MetaFiles data matches its corresponding
 // length and hashes
 func (f *MetaFiles) VerifyLengthHashes(data []byte) error {
@@ -412,7 +844,7 @@ func (f *MetaFiles) VerifyLengthHashes(data []byte) error {
 			return err
 		}
 	}
-	if f.Length != 0 {
+	if f.HasLength() {
 		err := verifyLength(data, f.Length)
 		if err != nil {
 			return err
@@ -421,20 +853,171 @@ func (f *MetaFiles) VerifyLengthHashes(data []byte) error {
 	return nil
 }
 
+// HasLength reports whether a length is committed to, which may be zero if
+// it was set with SetLength() or loaded from an explicit "length": 0
+func (f *MetaFiles) HasLength() bool {
+	return f.optionalLength.has(f.Length)
+}
+
+// SetLength commits to length, unlike assigning Length it allows committing
+// to a length of zero
+func (f *MetaFiles) SetLength(length int64) {
+	f.optionalLength.set(&f.Length, length)
+}
+
+// ClearLength removes the committed length
+func (f *MetaFiles) ClearLength() {
+	f.optionalLength.clear(&f.Length)
+}
+
 // VerifyLengthHashes checks whether the TargetFiles data matches its corresponding
-// length and hashes
-func (f *TargetFiles) VerifyLengthHashes(data []byte) error {
-	err := verifyHashes(data, f.Hashes)
+// length and hashes. Hashes are always verified, the length only if committed to.
+// If algorithms are given, only the hashes of those algorithms are verified,
+// and each of them must be listed by the target file
+func (f *TargetFiles) VerifyLengthHashes(data []byte, algorithms ...string) error {
+	hashes, err := f.SelectHashes(algorithms...)
 	if err != nil {
 		return err
 	}
-	err = verifyLength(data, f.Length)
+	err = verifyHashes(data, hashes)
 	if err != nil {
 		return err
 	}
+	if f.HasLength() {
+		err = verifyLength(data, f.Length)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// SelectHashes returns the hashes of the given algorithms, or all hashes if
+// none is given. Each of the algorithms must be listed by the target file
+func (f *TargetFiles) SelectHashes(algorithms ...string) (Hashes, error) {
+	if len(algorithms) == 0 {
+		return f.Hashes, nil
+	}
+	hashes := Hashes{}
+	for _, algorithm := range algorithms {
+		digest, ok := f.Hashes[algorithm]
+		if !ok {
+			return nil, ErrValue{Msg: fmt.Sprintf("target file has no %s hash to verify", algorithm)}
+		}
+		hashes[algorithm] = digest
+	}
+	return hashes, nil
+}
+
+// HasLength reports whether a length is committed to, which may be zero if
+// it was set with SetLength() or loaded from an explicit "length": 0
+func (f *TargetFiles) HasLength() bool {
+	return f.optionalLength.has(f.Length)
+}
+
+// SetLength commits to length, unlike assigning Length it allows committing
+// to a length of zero
+func (f *TargetFiles) SetLength(length int64) {
+	f.optionalLength.set(&f.Length, length)
+}
+
+// ClearLength removes the committed length
+func (f *TargetFiles) ClearLength() {
+	f.optionalLength.clear(&f.Length)
+}
+
+// has reports whether length is committed to
+func (l *optionalLength) has(length int64) bool {
+	return length != 0 || l.zeroLength
+}
+
+// set commits to value, which may be zero, as *length
+func (l *optionalLength) set(length *int64, value int64) {
+	*length = value
+	l.zeroLength = value == 0
+}
+
+// clear removes the committed *length
+func (l *optionalLength) clear(length *int64) {
+	*length = 0
+	l.zeroLength = false
+}
+
+// unmarshal records whether the unmarshalled length was an explicit
+// "length": 0 in dict, the unrecognized fields of the JSON object, and
+// removes it from dict
+func (l *optionalLength) unmarshal(dict map[string]any, length int64) {
+	if _, ok := dict["length"]; ok && length == 0 {
+		l.zeroLength = true
+	}
+	delete(dict, "length")
+}
+
+// VerifyTarget checks data against a length and hashes known out-of-band,
+// e.g. from a signed provenance document, the same way target files are
+// verified. At least one hash is required
+func VerifyTarget(data []byte, length int64, hashes Hashes) error {
+	if len(hashes) == 0 {
+		return ErrLengthOrHashMismatch{Msg: "hash verification failed - no hashes to verify against"}
+	}
+	if err := verifyHashes(data, hashes); err != nil {
+		return err
+	}
+	return verifyLength(data, length)
+}
+
+// VerifyTargetAgainstTargets checks data against the target file listed
+// for targetPath in targets, e.g. a trusted top-level targets metadata, with
+// no network access. Delegations are not followed: if targetPath is not
+// listed but delegated, the error names the delegated roles whose metadata
+// is needed to verify it
+func VerifyTargetAgainstTargets(data []byte, targetPath string, targets *Metadata[TargetsType]) error {
+	if targets == nil {
+		return ErrValue{Msg: "targets metadata is nil"}
+	}
+	targetFile, ok := targets.Signed.Targets[targetPath]
+	if ok {
+		return targetFile.VerifyLengthHashes(data)
+	}
+	if targets.Signed.Delegations != nil {
+		roles := targets.Signed.Delegations.GetRolesForTarget(targetPath)
+		if len(roles) > 0 {
+			roleNames := make([]string, 0, len(roles))
+			for roleName := range roles {
+				roleNames = append(roleNames, roleName)
+			}
+			slices.Sort(roleNames)
+			return ErrValue{Msg: fmt.Sprintf("target %s is delegated, supply the delegated metadata of %s to verify it", targetPath, strings.Join(roleNames, ", "))}
+		}
+	}
+	return ErrValue{Msg: fmt.Sprintf("target %s not found", targetPath)}
+}
+
+// DetectAndVerify checks data against a hex encoded digest of an unknown
+// hash algorithm, e.g. a checksum provided outside of TUF. The algorithm is
+// detected from the digest length, trying sha256 then sha512, and is returned
+// if data matches
+func DetectAndVerify(data []byte, expected string) (string, error) {
+	digest, err := hex.DecodeString(strings.TrimSpace(expected))
+	if err != nil {
+		return "", ErrValue{Msg: fmt.Sprintf("failed to decode expected digest: %v", err)}
+	}
+	var algorithm string
+	switch len(digest) {
+	case sha256.Size:
+		algorithm = "sha256"
+	case sha512.Size:
+		algorithm = "sha512"
+	default:
+		return "", ErrValue{Msg: fmt.Sprintf("unsupported digest length %d", len(digest))}
+	}
+	err = verifyHashes(data, Hashes{algorithm: digest})
+	if err != nil {
+		return "", err
+	}
+	return algorithm, nil
+}
+
 // Equal checks whether the source target file matches another
 func (source *TargetFiles) Equal(expected TargetFiles) bool {
 	if source.Length == expected.Length && source.Hashes.Equal(expected.Hashes) {
@@ -476,7 +1059,7 @@ func (t *TargetFiles) FromBytes(localPath string, data []byte, hashes ...string)
 	if err != nil {
 		return nil, err
 	}
-	targetFile.Length = len
+	targetFile.SetLength(len)
 	for _, v := range hashes {
 		switch v {
 		case "sha256":
@@ -500,6 +1083,43 @@ func (t *TargetFiles) FromBytes(localPath string, data []byte, hashes ...string)
 func (meta *Metadata[T]) ClearSignatures() {
 	log.Info("Cleared signatures")
 	meta.Signatures = []Signature{}
+	meta.signedDigest = nil
+}
+
+// checkStaleSignatures fails if Signed was modified since the current
+// signatures were created or loaded, see hasStaleSignatures
+func (meta *Metadata[T]) checkStaleSignatures() error {
+	stale, err := meta.hasStaleSignatures()
+	if err != nil {
+		return err
+	}
+	if stale {
+		return ErrValue{Msg: "signed metadata was modified after signing, re-sign or clear the stale signatures"}
+	}
+	return nil
+}
+
+// hasStaleSignatures reports whether Signed was modified since the current
+// signatures were created or loaded
+func (meta *Metadata[T]) hasStaleSignatures() (bool, error) {
+	if len(meta.Signatures) == 0 || meta.signedDigest == nil {
+		return false, nil
+	}
+	digest, err := meta.computeSignedDigest()
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(meta.signedDigest, digest), nil
+}
+
+// computeSignedDigest returns the SHA-256 digest of the canonical Signed payload
+func (meta *Metadata[T]) computeSignedDigest() ([]byte, error) {
+	payload, err := meta.SignedBytes()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(payload)
+	return digest[:], nil
 }
 
 // IsDelegatedPath determines whether the given "targetFilepath" is in one of
@@ -526,6 +1146,83 @@ func (role *DelegatedRole) IsDelegatedPath(targetFilepath string) (bool, error)
 	return false, nil
 }
 
+// IsDelegatedNormalizedPath is like IsDelegatedPath, but compares the
+// normalized forms of targetFilepath and of the path patterns, see
+// NormalizeTargetPath. Hash bin delegations use the hash of the normalized
+// target path
+func (role *DelegatedRole) IsDelegatedNormalizedPath(targetFilepath string) (bool, error) {
+	targetFilepath = NormalizeTargetPath(targetFilepath)
+	if len(role.Paths) > 0 {
+		for _, pathPattern := range role.Paths {
+			if isTargetInPathPattern(targetFilepath, NormalizeTargetPath(pathPattern)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return role.IsDelegatedPath(targetFilepath)
+}
+
+// validatePaths rejects path patterns which are not valid glob patterns and
+// so could never match a target
+func (role *DelegatedRole) validatePaths() error {
+	for _, pathPattern := range role.Paths {
+		for _, part := range strings.Split(pathPattern, "/") {
+			if _, err := filepath.Match(part, ""); err != nil {
+				return ErrInvalidDelegation{Msg: fmt.Sprintf("delegated role %s has malformed path pattern %q: %v", role.Name, pathPattern, err)}
+			}
+		}
+	}
+	return nil
+}
+
+// ToRole returns a Role with the keys and threshold of the delegated role,
+// e.g. to promote it to a top-level role
+func (role *DelegatedRole) ToRole() *Role {
+	return &Role{
+		KeyIDs:    slices.Clone(role.KeyIDs),
+		Threshold: role.Threshold,
+	}
+}
+
+// ExpandDelegationCoverage returns the paths in candidatePaths that role is
+// trusted to provide, in their original order. It is meant to be used while
+// building a repository, e.g. to sanity-check how delegations partition the
+// targets before signing
+func ExpandDelegationCoverage(role *DelegatedRole, candidatePaths []string) []string {
+	if role == nil {
+		return nil
+	}
+	res := []string{}
+	for _, candidatePath := range candidatePaths {
+		if ok, err := role.IsDelegatedPath(candidatePath); err == nil && ok {
+			res = append(res, candidatePath)
+		}
+	}
+	return res
+}
+
+// ValidateTargetCoverage returns the sorted target paths of targets which
+// delegatedRole is not trusted to provide. Clients ignore such targets, so
+// any of them means the delegated role signed targets outside of its
+// delegation. A nil delegatedRole covers no targets
+func ValidateTargetCoverage(delegatedRole *DelegatedRole, targets *TargetsType) []string {
+	res := []string{}
+	if targets == nil {
+		return res
+	}
+	for targetPath := range targets.Targets {
+		if delegatedRole != nil {
+			if ok, err := delegatedRole.IsDelegatedPath(targetPath); err == nil && ok {
+				continue
+			}
+		}
+		res = append(res, targetPath)
+	}
+	slices.Sort(res)
+	return res
+}
+
 // Determine whether “targetpath“ matches the “pathpattern“.
 func isTargetInPathPattern(targetpath string, pathpattern string) bool {
 	// We need to make sure that targetpath and pathpattern are pointing to
@@ -566,6 +1263,188 @@ func (role *Delegations) GetRolesForTarget(targetFilepath string) map[string]boo
 	return res
 }
 
+// GetRolesForNormalizedTarget is like GetRolesForTarget, but matches the
+// normalized form of targetFilepath, see DelegatedRole.IsDelegatedNormalizedPath
+func (role *Delegations) GetRolesForNormalizedTarget(targetFilepath string) map[string]bool {
+	res := map[string]bool{}
+	if role.Roles != nil {
+		for _, r := range role.Roles {
+			ok, err := r.IsDelegatedNormalizedPath(targetFilepath)
+			if err == nil && ok {
+				res[r.Name] = r.Terminating
+			}
+		}
+	} else if role.SuccinctRoles != nil {
+		res = role.SuccinctRoles.GetRolesForTarget(NormalizeTargetPath(targetFilepath))
+	}
+	return res
+}
+
+// GetRoles returns the names of all roles delegated by Delegations
+func (role *Delegations) GetRoles() []string {
+	res := []string{}
+	if role.Roles != nil {
+		for _, r := range role.Roles {
+			res = append(res, r.Name)
+		}
+	} else if role.SuccinctRoles != nil {
+		res = role.SuccinctRoles.GetRoles()
+	}
+	return res
+}
+
+// ValidateDelegations checks that the delegations key store is consistent
+// with the delegated roles: every key ID used by a delegated role must be in
+// Delegations.Keys and every key in Delegations.Keys must be used by a role.
+// Loading targets metadata fails if it doesn't pass this check
+func (signed *TargetsType) ValidateDelegations() error {
+	if signed.Delegations == nil {
+		return nil
+	}
+	referenced := map[string]bool{}
+	for _, role := range signed.Delegations.Roles {
+		for _, keyID := range role.KeyIDs {
+			referenced[keyID] = true
+		}
+	}
+	if signed.Delegations.SuccinctRoles != nil {
+		for _, keyID := range signed.Delegations.SuccinctRoles.KeyIDs {
+			referenced[keyID] = true
+		}
+	}
+	dangling := []string{}
+	for keyID := range referenced {
+		if _, ok := signed.Delegations.Keys[keyID]; !ok {
+			dangling = append(dangling, keyID)
+		}
+	}
+	unreferenced := []string{}
+	for keyID := range signed.Delegations.Keys {
+		if !referenced[keyID] {
+			unreferenced = append(unreferenced, keyID)
+		}
+	}
+	if len(dangling) > 0 {
+		slices.Sort(dangling)
+		return ErrValue{Msg: fmt.Sprintf("delegated key IDs missing from delegations keys: %s", strings.Join(dangling, ", "))}
+	}
+	if len(unreferenced) > 0 {
+		slices.Sort(unreferenced)
+		return ErrValue{Msg: fmt.Sprintf("delegations keys not used by any delegated role: %s", strings.Join(unreferenced, ", "))}
+	}
+	return nil
+}
+
+// ValidateDelegationGraph walks the delegation graph rooted at the top-level
+// targets role and errors out if it contains a cycle or a delegation chain
+// deeper than maxDepth. The top-level targets role is at depth 0.
+// Delegated roles missing from targets are not traversed.
+func ValidateDelegationGraph(targets map[string]*Metadata[TargetsType], maxDepth int) error {
+	if _, ok := targets[TARGETS]; !ok {
+		return ErrValue{Msg: fmt.Sprintf("no %s metadata found", TARGETS)}
+	}
+	return validateDelegationPath(targets, []string{TARGETS}, maxDepth, map[delegationEdge]int{})
+}
+
+// delegationEdge is a delegation from parent to role
+type delegationEdge struct {
+	role   string
+	parent string
+}
+
+// validateDelegationPath recursively validates the delegations of the last
+// role in path. visited records the deepest depth each delegation was
+// validated at, a delegation reached again at the same or a lower depth is
+// skipped so that graphs with shared roles aren't walked once per path
+func validateDelegationPath(targets map[string]*Metadata[TargetsType], path []string, maxDepth int, visited map[delegationEdge]int) error {
+	parent := path[len(path)-1]
+	current, ok := targets[parent]
+	if !ok || current.Signed.Delegations == nil {
+		return nil
+	}
+	for _, child := range current.Signed.Delegations.GetRoles() {
+		childPath := append(slices.Clone(path), child)
+		if slices.Contains(path, child) {
+			return ErrValue{Msg: fmt.Sprintf("delegation cycle found: %s", strings.Join(childPath, " -> "))}
+		}
+		depth := len(childPath) - 1
+		if depth > maxDepth {
+			return ErrValue{Msg: fmt.Sprintf("delegation chain exceeds max depth %d: %s", maxDepth, strings.Join(childPath, " -> "))}
+		}
+		edge := delegationEdge{role: child, parent: parent}
+		if visitedDepth, ok := visited[edge]; ok && visitedDepth >= depth {
+			continue
+		}
+		if err := validateDelegationPath(targets, childPath, maxDepth, visited); err != nil {
+			return err
+		}
+		visited[edge] = depth
+	}
+	return nil
+}
+
+// NewDelegationGraph walks the delegations of targets, starting from the
+// top-level targets role, and returns the delegation edges in pre-order
+// depth-first order. Delegated roles missing from targets are listed as
+// children but not traversed, each role's delegations are listed only once.
+func NewDelegationGraph(targets map[string]*Metadata[TargetsType]) *DelegationGraph {
+	graph := &DelegationGraph{Edges: []DelegationEdge{}}
+	visited := map[string]bool{}
+	var walk func(parent string)
+	walk = func(parent string) {
+		current, ok := targets[parent]
+		if !ok || visited[parent] {
+			return
+		}
+		visited[parent] = true
+		if current.Signed.Delegations == nil {
+			return
+		}
+		if succinct := current.Signed.Delegations.SuccinctRoles; succinct != nil {
+			graph.Edges = append(graph.Edges, DelegationEdge{
+				Parent:      parent,
+				Child:       fmt.Sprintf("%s-*", succinct.NamePrefix),
+				Terminating: true,
+			})
+			return
+		}
+		for _, role := range current.Signed.Delegations.Roles {
+			graph.Edges = append(graph.Edges, DelegationEdge{
+				Parent:           parent,
+				Child:            role.Name,
+				Paths:            role.Paths,
+				PathHashPrefixes: role.PathHashPrefixes,
+				Terminating:      role.Terminating,
+			})
+			walk(role.Name)
+		}
+	}
+	walk(TARGETS)
+	return graph
+}
+
+// DOT returns the delegation graph in the Graphviz DOT language, each edge
+// is labelled with the delegated paths and whether it is terminating
+func (graph *DelegationGraph) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph delegations {\n")
+	for _, edge := range graph.Edges {
+		labels := []string{}
+		if len(edge.Paths) > 0 {
+			labels = append(labels, fmt.Sprintf("paths: %s", strings.Join(edge.Paths, ", ")))
+		}
+		if len(edge.PathHashPrefixes) > 0 {
+			labels = append(labels, fmt.Sprintf("path_hash_prefixes: %s", strings.Join(edge.PathHashPrefixes, ", ")))
+		}
+		if edge.Terminating {
+			labels = append(labels, "terminating")
+		}
+		fmt.Fprintf(&sb, "\t%q -> %q [label=%q];\n", edge.Parent, edge.Child, strings.Join(labels, "\n"))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
 // GetRolesForTarget calculate the name of the delegated role responsible for "targetFilepath".
 // The target at path "targetFilepath" is assigned to a bin by casting
 // the left-most "BitLength" of bits of the file path hash digest to
@@ -690,6 +1569,100 @@ func (signed *RootType) RevokeKey(keyID, role string) error {
 	return nil
 }
 
+// WouldMeetThresholdAfterRevoke reports whether existingSigs would still
+// meet the threshold of “role“ once the key “keyID“ is revoked from it.
+// Only signatures from distinct keys still authorized for the role count,
+// the signatures themselves are not verified, e.g. they are the signatures
+// of metadata which already passed VerifyDelegate. signed is not modified.
+func (signed *RootType) WouldMeetThresholdAfterRevoke(keyID, role string, existingSigs []Signature) (bool, error) {
+	roleInfo, ok := signed.Roles[role]
+	if !ok {
+		return false, ErrValue{Msg: fmt.Sprintf("role %s doesn't exist", role)}
+	}
+	if !slices.Contains(roleInfo.KeyIDs, keyID) {
+		return false, ErrValue{Msg: fmt.Sprintf("key with id %s is not used by %s", keyID, role)}
+	}
+	signingKeys := map[string]bool{}
+	for _, sig := range existingSigs {
+		if sig.KeyID != keyID && slices.Contains(roleInfo.KeyIDs, sig.KeyID) {
+			signingKeys[sig.KeyID] = true
+		}
+	}
+	return len(signingKeys) >= roleInfo.Threshold, nil
+}
+
+// Diff returns the key IDs added and removed, and the threshold changes,
+// for each role between signed and the newer root version other.
+// A role present only in one of the versions is reported with all of its
+// key IDs added (or removed) and a zero old (or new) threshold
+func (signed *RootType) Diff(other *RootType) *RootDiff {
+	diff := &RootDiff{Roles: map[string]*RoleDiff{}}
+	roleNames := []string{}
+	for name := range signed.Roles {
+		roleNames = append(roleNames, name)
+	}
+	for name := range other.Roles {
+		if _, ok := signed.Roles[name]; !ok {
+			roleNames = append(roleNames, name)
+		}
+	}
+	for _, name := range roleNames {
+		roleDiff := &RoleDiff{AddedKeyIDs: []string{}, RemovedKeyIDs: []string{}}
+		oldKeyIDs, newKeyIDs := []string{}, []string{}
+		if role, ok := signed.Roles[name]; ok {
+			oldKeyIDs = role.KeyIDs
+			roleDiff.OldThreshold = role.Threshold
+		}
+		if role, ok := other.Roles[name]; ok {
+			newKeyIDs = role.KeyIDs
+			roleDiff.NewThreshold = role.Threshold
+		}
+		for _, keyID := range newKeyIDs {
+			if !slices.Contains(oldKeyIDs, keyID) {
+				roleDiff.AddedKeyIDs = append(roleDiff.AddedKeyIDs, keyID)
+			}
+		}
+		for _, keyID := range oldKeyIDs {
+			if !slices.Contains(newKeyIDs, keyID) {
+				roleDiff.RemovedKeyIDs = append(roleDiff.RemovedKeyIDs, keyID)
+			}
+		}
+		if len(roleDiff.AddedKeyIDs) > 0 || len(roleDiff.RemovedKeyIDs) > 0 || roleDiff.OldThreshold != roleDiff.NewThreshold {
+			diff.Roles[name] = roleDiff
+		}
+	}
+	return diff
+}
+
+// CheckRotationSafety reports if the newer root version next shares none of
+// the root role keys of signed. The specification allows rotating to an
+// entirely new key set in one step, but it is an easy way to lock clients
+// out, so tooling can use this advisory check to have operators confirm the
+// rotation is intentional. It is not enforced by the Updater
+func (signed *RootType) CheckRotationSafety(next *RootType) error {
+	oldRole, ok := signed.Roles[ROOT]
+	if !ok {
+		return ErrValue{Msg: fmt.Sprintf("root version %d has no %s role", signed.Version, ROOT)}
+	}
+	newRole, ok := next.Roles[ROOT]
+	if !ok {
+		return ErrValue{Msg: fmt.Sprintf("root version %d has no %s role", next.Version, ROOT)}
+	}
+	for _, oldKeyID := range oldRole.KeyIDs {
+		for _, newKeyID := range newRole.KeyIDs {
+			if oldKeyID == newKeyID {
+				return nil
+			}
+			// the same key may be listed under a different key ID
+			oldKey, newKey := signed.Keys[oldKeyID], next.Keys[newKeyID]
+			if oldKey != nil && newKey != nil && oldKey.Equal(newKey) {
+				return nil
+			}
+		}
+	}
+	return ErrValue{Msg: fmt.Sprintf("root version %d shares no %s keys with root version %d", next.Version, ROOT, signed.Version)}
+}
+
 // AddKey adds new signing key for delegated role "role"
 // key: Signing key to be added for “role“.
 // role: Name of the role, for which “key“ is added.
@@ -795,6 +1768,109 @@ func (signed *TargetsType) RevokeKey(keyID string, role string) error {
 	return ErrValue{Msg: fmt.Sprintf("delegated role %s doesn't exist", role)}
 }
 
+// DiffTargets returns the target paths added, removed and modified between
+// signed and the newer targets version other. A target is modified if its
+// length or hashes changed, see TargetFiles.Equal
+func (signed *TargetsType) DiffTargets(other *TargetsType) *TargetsDiff {
+	diff := &TargetsDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	for targetPath, targetFile := range signed.Targets {
+		otherFile, ok := other.Targets[targetPath]
+		if !ok {
+			diff.Removed = append(diff.Removed, targetPath)
+		} else if !targetFile.Equal(*otherFile) {
+			diff.Modified = append(diff.Modified, targetPath)
+		}
+	}
+	for targetPath := range other.Targets {
+		if _, ok := signed.Targets[targetPath]; !ok {
+			diff.Added = append(diff.Added, targetPath)
+		}
+	}
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	slices.Sort(diff.Modified)
+	return diff
+}
+
+// AddTargetsFromDir walks the directory tree at root and adds a TargetFiles
+// entry for each regular file found, using the given hash algorithms.
+// Targets are keyed by their path relative to root, using forward slashes
+func (signed *TargetsType) AddTargetsFromDir(root string, hashes ...string) error {
+	log.Info("Adding targets from directory", "path", root)
+	if signed.Targets == nil {
+		signed.Targets = map[string]*TargetFiles{}
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		targetFile, err := TargetFile().FromFile(path, hashes...)
+		if err != nil {
+			return err
+		}
+		targetFile.Path = filepath.ToSlash(relPath)
+		signed.AddTarget(targetFile)
+		return nil
+	})
+}
+
+// AddTarget lists targetFile under its normalized path, see
+// NormalizeTargetPath, and sets its Path accordingly
+func (signed *TargetsType) AddTarget(targetFile *TargetFiles) {
+	if signed.Targets == nil {
+		signed.Targets = map[string]*TargetFiles{}
+	}
+	targetFile.Path = NormalizeTargetPath(targetFile.Path)
+	signed.Targets[targetFile.Path] = targetFile
+}
+
+// FindTarget returns the target file listed under targetPath or, failing
+// that, under a path with the same normalized form, see NormalizeTargetPath.
+// The normalized path itself is preferred, then the first matching path in
+// lexical order, so that the result is deterministic
+func (signed *TargetsType) FindTarget(targetPath string) (*TargetFiles, bool) {
+	if targetFile, ok := signed.Targets[targetPath]; ok {
+		return targetFile, true
+	}
+	normalized := NormalizeTargetPath(targetPath)
+	if targetFile, ok := signed.Targets[normalized]; ok {
+		return targetFile, true
+	}
+	matches := []string{}
+	for listedPath := range signed.Targets {
+		if NormalizeTargetPath(listedPath) == normalized {
+			matches = append(matches, listedPath)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, false
+	}
+	slices.Sort(matches)
+	return signed.Targets[matches[0]], true
+}
+
+// NormalizeTargetPath returns the canonical form of a target path: empty
+// and "." path segments are removed, so leading, trailing and repeated
+// slashes and "./" prefixes are dropped, e.g. "/dir//./file.txt" becomes
+// "dir/file.txt". ".." segments are kept as is, and URL-encoded characters
+// are not decoded since e.g. "%2F" may be part of a literal file name
+func NormalizeTargetPath(targetPath string) string {
+	segments := []string{}
+	for _, segment := range strings.Split(targetPath, "/") {
+		if segment != "" && segment != "." {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
 // Equal checks whether one hash set equals another
 func (source Hashes) Equal(expected Hashes) bool {
 	hashChecked := false
@@ -847,6 +1923,8 @@ func verifyHashes(data []byte, hashes Hashes) error {
 // that the data corresponds to the caller struct type
 func fromBytes[T Roles](data []byte) (*Metadata[T], error) {
 	meta := &Metadata[T]{}
+	// some tools prefix JSON with a UTF-8 byte order mark, which isn't valid JSON
+	data = bytes.TrimPrefix(data, utf8BOM)
 	// verify that the type we used to create the object is the same as the type of the metadata file
 	if err := checkType[T](data); err != nil {
 		return nil, err
@@ -856,16 +1934,24 @@ func fromBytes[T Roles](data []byte) (*Metadata[T], error) {
 		return nil, err
 	}
 	// Make sure signature key IDs are unique
-	if err := checkUniqueSignatures(*meta); err != nil {
+	if err := checkUniqueSignatures(meta.Signatures); err != nil {
 		return nil, err
 	}
+	// remember the payload the loaded signatures were made over
+	if len(meta.Signatures) > 0 {
+		digest, err := meta.computeSignedDigest()
+		if err != nil {
+			return nil, err
+		}
+		meta.signedDigest = digest
+	}
 	return meta, nil
 }
 
 // checkUniqueSignatures verifies if the signature key IDs are unique for that metadata
-func checkUniqueSignatures[T Roles](meta Metadata[T]) error {
+func checkUniqueSignatures(sigs []Signature) error {
 	signatures := []string{}
-	for _, sig := range meta.Signatures {
+	for _, sig := range sigs {
 		if slices.Contains(signatures, sig.KeyID) {
 			return ErrValue{Msg: fmt.Sprintf("multiple signatures found for key ID %s", sig.KeyID)}
 		}
@@ -876,31 +1962,38 @@ func checkUniqueSignatures[T Roles](meta Metadata[T]) error {
 
 // checkType verifies if the generic type used to create the object is the same as the type of the metadata file in bytes
 func checkType[T Roles](data []byte) error {
-	var m map[string]any
+	var m struct {
+		Signed *struct {
+			Type *string `json:"_type"`
+		} `json:"signed"`
+	}
 	i := any(new(T))
 	if err := json.Unmarshal(data, &m); err != nil {
 		return err
 	}
-	signedType := m["signed"].(map[string]any)["_type"].(string)
+	if m.Signed == nil || m.Signed.Type == nil {
+		return ErrMetadataType{Msg: "failed to read metadata type: missing signed._type"}
+	}
+	signedType := *m.Signed.Type
 	switch i.(type) {
 	case *RootType:
 		if ROOT != signedType {
-			return ErrValue{Msg: fmt.Sprintf("expected metadata type %s, got - %s", ROOT, signedType)}
+			return ErrMetadataType{Msg: fmt.Sprintf("expected metadata type %s, got - %s", ROOT, signedType)}
 		}
 	case *SnapshotType:
 		if SNAPSHOT != signedType {
-			return ErrValue{Msg: fmt.Sprintf("expected metadata type %s, got - %s", SNAPSHOT, signedType)}
+			return ErrMetadataType{Msg: fmt.Sprintf("expected metadata type %s, got - %s", SNAPSHOT, signedType)}
 		}
 	case *TimestampType:
 		if TIMESTAMP != signedType {
-			return ErrValue{Msg: fmt.Sprintf("expected metadata type %s, got - %s", TIMESTAMP, signedType)}
+			return ErrMetadataType{Msg: fmt.Sprintf("expected metadata type %s, got - %s", TIMESTAMP, signedType)}
 		}
 	case *TargetsType:
 		if TARGETS != signedType {
-			return ErrValue{Msg: fmt.Sprintf("expected metadata type %s, got - %s", TARGETS, signedType)}
+			return ErrMetadataType{Msg: fmt.Sprintf("expected metadata type %s, got - %s", TARGETS, signedType)}
 		}
 	default:
-		return ErrValue{Msg: fmt.Sprintf("unrecognized metadata type - %s", signedType)}
+		return ErrMetadataType{Msg: fmt.Sprintf("unrecognized metadata type - %s", signedType)}
 	}
 	// all okay
 	return nil
@@ -13,10 +13,21 @@ package metadata
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -50,13 +61,15 @@ func TestGenericRead(t *testing.T) {
 	// Assert that it chokes correctly on an unknown metadata type
 	badMetadata := "{\"signed\": {\"_type\": \"bad-metadata\"}}"
 	_, err := Root().FromBytes([]byte(badMetadata))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type root, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type root, got - bad-metadata"})
 	_, err = Snapshot().FromBytes([]byte(badMetadata))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type snapshot, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type snapshot, got - bad-metadata"})
 	_, err = Targets().FromBytes([]byte(badMetadata))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type targets, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type targets, got - bad-metadata"})
 	_, err = Timestamp().FromBytes([]byte(badMetadata))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type timestamp, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type timestamp, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{})
+	assert.ErrorIs(t, err, ErrValue{})
 
 	badMetadataPath := filepath.Join(testutils.RepoDir, "bad-metadata.json")
 	err = os.WriteFile(badMetadataPath, []byte(badMetadata), 0644)
@@ -64,13 +77,13 @@ func TestGenericRead(t *testing.T) {
 	assert.FileExists(t, badMetadataPath)
 
 	_, err = Root().FromFile(badMetadataPath)
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type root, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type root, got - bad-metadata"})
 	_, err = Snapshot().FromFile(badMetadataPath)
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type snapshot, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type snapshot, got - bad-metadata"})
 	_, err = Targets().FromFile(badMetadataPath)
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type targets, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type targets, got - bad-metadata"})
 	_, err = Timestamp().FromFile(badMetadataPath)
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type timestamp, got - bad-metadata"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type timestamp, got - bad-metadata"})
 
 	err = os.RemoveAll(badMetadataPath)
 	assert.NoError(t, err)
@@ -80,35 +93,35 @@ func TestGenericRead(t *testing.T) {
 func TestGenericReadFromMismatchingRoles(t *testing.T) {
 	// Test failing to load other roles from root metadata
 	_, err := Snapshot().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type snapshot, got - root"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type snapshot, got - root"})
 	_, err = Timestamp().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type timestamp, got - root"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type timestamp, got - root"})
 	_, err = Targets().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type targets, got - root"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type targets, got - root"})
 
 	// Test failing to load other roles from targets metadata
 	_, err = Snapshot().FromFile(filepath.Join(testutils.RepoDir, "targets.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type snapshot, got - targets"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type snapshot, got - targets"})
 	_, err = Timestamp().FromFile(filepath.Join(testutils.RepoDir, "targets.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type timestamp, got - targets"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type timestamp, got - targets"})
 	_, err = Root().FromFile(filepath.Join(testutils.RepoDir, "targets.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type root, got - targets"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type root, got - targets"})
 
 	// Test failing to load other roles from timestamp metadata
 	_, err = Snapshot().FromFile(filepath.Join(testutils.RepoDir, "timestamp.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type snapshot, got - timestamp"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type snapshot, got - timestamp"})
 	_, err = Targets().FromFile(filepath.Join(testutils.RepoDir, "timestamp.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type targets, got - timestamp"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type targets, got - timestamp"})
 	_, err = Root().FromFile(filepath.Join(testutils.RepoDir, "timestamp.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type root, got - timestamp"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type root, got - timestamp"})
 
 	// Test failing to load other roles from snapshot metadata
 	_, err = Targets().FromFile(filepath.Join(testutils.RepoDir, "snapshot.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type targets, got - snapshot"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type targets, got - snapshot"})
 	_, err = Timestamp().FromFile(filepath.Join(testutils.RepoDir, "snapshot.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type timestamp, got - snapshot"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type timestamp, got - snapshot"})
 	_, err = Root().FromFile(filepath.Join(testutils.RepoDir, "snapshot.json"))
-	assert.ErrorIs(t, err, ErrValue{"expected metadata type root, got - snapshot"})
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type root, got - snapshot"})
 }
 
 func TestMDReadWriteFileExceptions(t *testing.T) {
@@ -365,7 +378,7 @@ func TestSignVerify(t *testing.T) {
 	targetsPublicKey, err := targetsKey.ToPublicKey()
 	assert.NoError(t, err)
 	targetsHash := crypto.SHA256
-	targetsVerifier, err := signature.LoadVerifier(targetsPublicKey, targetsHash)
+	targetsVerifier, err := signature.LoadRSAPSSVerifier(targetsPublicKey.(*rsa.PublicKey), targetsHash, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
 	assert.NoError(t, err)
 	err = targetsVerifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(data))
 	assert.NoError(t, err)
@@ -375,13 +388,13 @@ func TestSignVerify(t *testing.T) {
 	snapshotPublicKey, err := snapshotKey.ToPublicKey()
 	assert.NoError(t, err)
 	snapshotHash := crypto.SHA256
-	snapshotVerifier, err := signature.LoadVerifier(snapshotPublicKey, snapshotHash)
+	snapshotVerifier, err := signature.LoadRSAPSSVerifier(snapshotPublicKey.(*rsa.PublicKey), snapshotHash, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
 	assert.NoError(t, err)
 	err = snapshotVerifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(data))
 	assert.ErrorContains(t, err, "crypto/rsa: verification error")
 
 	// Append a new signature with the unrelated key and assert that ...
-	signer, err := signature.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "snapshot_key"), crypto.SHA256, cryptoutils.SkipPassword)
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "snapshot_key"))
 	assert.NoError(t, err)
 	snapshotSig, err := targets.Sign(signer)
 	assert.NoError(t, err)
@@ -396,7 +409,7 @@ func TestSignVerify(t *testing.T) {
 	assert.Equal(t, snapshotSig.KeyID, snapshotKeyID)
 
 	// Clear all signatures and add a new signature with the unrelated key and assert that ...
-	signer, err = signature.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "timestamp_key"), crypto.SHA256, cryptoutils.SkipPassword)
+	signer, err = testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "timestamp_key"))
 	assert.NoError(t, err)
 	targets.ClearSignatures()
 	assert.Equal(t, 0, len(targets.Signatures))
@@ -409,7 +422,7 @@ func TestSignVerify(t *testing.T) {
 	timestampPublicKey, err := timestampKey.ToPublicKey()
 	assert.NoError(t, err)
 	timestampHash := crypto.SHA256
-	timestampVerifier, err := signature.LoadVerifier(timestampPublicKey, timestampHash)
+	timestampVerifier, err := signature.LoadRSAPSSVerifier(timestampPublicKey.(*rsa.PublicKey), timestampHash, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
 	assert.NoError(t, err)
 
 	err = timestampVerifier.VerifySignature(bytes.NewReader(timestampSig.Signature), bytes.NewReader(data))
@@ -418,6 +431,540 @@ func TestSignVerify(t *testing.T) {
 	assert.ErrorContains(t, err, "crypto/rsa: verification error")
 }
 
+func TestStaleSignatures(t *testing.T) {
+	// Test that unmodified signed metadata serializes fine
+	targets, err := Targets().FromFile(filepath.Join(testutils.RepoDir, "targets.json"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, targets.Signatures)
+	_, err = targets.ToBytes(false)
+	assert.NoError(t, err)
+
+	// Test that mutating Signed makes the loaded signatures stale
+	targets.Signed.Version += 1
+	_, err = targets.ToBytes(false)
+	assert.ErrorIs(t, err, ErrValue{"signed metadata was modified after signing, re-sign or clear the stale signatures"})
+	err = targets.ToFile(filepath.Join(t.TempDir(), "targets.json"), false)
+	assert.ErrorIs(t, err, ErrValue{"signed metadata was modified after signing, re-sign or clear the stale signatures"})
+	// ... unless explicitly serialized without the check
+	data, err := targets.UnsafeToBytes(false)
+	assert.NoError(t, err)
+	unsafeTargets, err := Targets().FromBytes(data)
+	assert.NoError(t, err)
+	assert.Equal(t, targets.Signatures, unsafeTargets.Signatures)
+
+	// Test that re-signing after mutation drops the stale signatures
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "snapshot_key"))
+	assert.NoError(t, err)
+	sig, err := targets.Sign(signer)
+	assert.NoError(t, err)
+	assert.Equal(t, []Signature{*sig}, targets.Signatures)
+	data, err = targets.ToBytes(false)
+	assert.NoError(t, err)
+	resigned, err := Targets().FromBytes(data)
+	assert.NoError(t, err)
+	assert.Len(t, resigned.Signatures, 1)
+	assert.Equal(t, sig.KeyID, resigned.Signatures[0].KeyID)
+
+	// Test that signing again with the same payload keeps existing signatures
+	signer, err = testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "timestamp_key"))
+	assert.NoError(t, err)
+	_, err = targets.Sign(signer)
+	assert.NoError(t, err)
+	assert.Len(t, targets.Signatures, 2)
+
+	// Test that clearing signatures after mutation allows serializing
+	root, err := Root().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
+	assert.NoError(t, err)
+	err = root.Signed.RevokeKey(root.Signed.Roles[TARGETS].KeyIDs[0], TARGETS)
+	assert.NoError(t, err)
+	_, err = root.ToBytes(false)
+	assert.ErrorIs(t, err, ErrValue{"signed metadata was modified after signing, re-sign or clear the stale signatures"})
+	root.ClearSignatures()
+	data, err = root.ToBytes(false)
+	assert.NoError(t, err)
+	cleared, err := Root().FromBytes(data)
+	assert.NoError(t, err)
+	assert.Empty(t, cleared.Signatures)
+}
+
+func TestTrailingNewline(t *testing.T) {
+	defer SetTrailingNewline(false)
+	root, err := Root().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
+	assert.NoError(t, err)
+
+	// Test the default output has no trailing newline
+	assert.False(t, GetTrailingNewline())
+	for _, pretty := range []bool{false, true} {
+		data, err := root.ToBytes(pretty)
+		assert.NoError(t, err)
+		assert.False(t, bytes.HasSuffix(data, []byte("\n")))
+	}
+	compact, err := root.ToBytes(false)
+	assert.NoError(t, err)
+	pretty, err := root.ToBytes(true)
+	assert.NoError(t, err)
+
+	// Test enabling appends exactly one newline to both ToBytes and ToFile
+	SetTrailingNewline(true)
+	assert.True(t, GetTrailingNewline())
+	data, err := root.ToBytes(false)
+	assert.NoError(t, err)
+	assert.Equal(t, append(bytes.Clone(compact), '\n'), data)
+	data, err = root.ToBytes(true)
+	assert.NoError(t, err)
+	assert.Equal(t, append(bytes.Clone(pretty), '\n'), data)
+	dst := filepath.Join(t.TempDir(), "root.json")
+	err = root.ToFile(dst, true)
+	assert.NoError(t, err)
+	fileData, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, data, fileData)
+
+	// Test the newline isn't part of the signed payload
+	loaded, err := Root().FromFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, root.Signed, loaded.Signed)
+	err = root.VerifyDelegate(ROOT, loaded)
+	assert.NoError(t, err)
+	signedBytes, err := loaded.SignedBytes()
+	assert.NoError(t, err)
+	expectedSignedBytes, err := root.SignedBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, expectedSignedBytes, signedBytes)
+
+	// Test disabling restores the default
+	SetTrailingNewline(false)
+	data, err = root.ToBytes(false)
+	assert.NoError(t, err)
+	assert.Equal(t, compact, data)
+}
+
+func TestToCanonicalBytes(t *testing.T) {
+	// Test that repeated calls produce identical output
+	targets, err := Targets().FromFile(filepath.Join(testutils.RepoDir, "targets.json"))
+	assert.NoError(t, err)
+	data, err := targets.ToCanonicalBytes()
+	assert.NoError(t, err)
+	again, err := targets.ToCanonicalBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, data, again)
+	decoded, err := Targets().FromBytes(data)
+	assert.NoError(t, err)
+	assert.Equal(t, targets.Signed, decoded.Signed)
+	assert.Equal(t, targets.Signatures, decoded.Signatures)
+
+	// Test that the signature order doesn't change the output
+	snapshotSigner, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "snapshot_key"))
+	assert.NoError(t, err)
+	timestampSigner, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "timestamp_key"))
+	assert.NoError(t, err)
+	first := Targets(fixedExpire)
+	_, err = first.Sign(snapshotSigner)
+	assert.NoError(t, err)
+	_, err = first.Sign(timestampSigner)
+	assert.NoError(t, err)
+	second := Targets(fixedExpire)
+	second.Signatures = []Signature{first.Signatures[1], first.Signatures[0]}
+	firstData, err := first.ToCanonicalBytes()
+	assert.NoError(t, err)
+	secondData, err := second.ToCanonicalBytes()
+	assert.NoError(t, err)
+	assert.Equal(t, firstData, secondData)
+	// ... and that the input signatures are left untouched
+	assert.Equal(t, first.Signatures[1], second.Signatures[0])
+
+	// Test that stale signatures are rejected
+	first.Signed.Version += 1
+	_, err = first.ToCanonicalBytes()
+	assert.ErrorIs(t, err, ErrValue{"signed metadata was modified after signing, re-sign or clear the stale signatures"})
+}
+
+func TestVerifyDelegateStrict(t *testing.T) {
+	root := Root(fixedExpire)
+	targets := Targets(fixedExpire)
+	signers := []signature.Signer{}
+	keyIDs := []string{}
+	for i := 0; i < 2; i++ {
+		publicKey, privateKey, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		signer, err := signature.LoadSigner(privateKey, crypto.Hash(0))
+		assert.NoError(t, err)
+		key, err := KeyFromPublicKey(publicKey)
+		assert.NoError(t, err)
+		err = root.Signed.AddKey(key, TARGETS)
+		assert.NoError(t, err)
+		signers = append(signers, signer)
+		keyIDs = append(keyIDs, key.ID())
+	}
+	root.Signed.Roles[TARGETS].Threshold = 1
+
+	// Test that a missing signature beyond the threshold is accepted
+	_, err := targets.Sign(signers[0])
+	assert.NoError(t, err)
+	err = root.VerifyDelegate(TARGETS, targets)
+	assert.NoError(t, err)
+	err = root.VerifyDelegateStrict(TARGETS, targets)
+	assert.NoError(t, err)
+
+	// Test that an invalid signature beyond the threshold is only reported
+	// in strict mode
+	sig, err := targets.Sign(signers[1])
+	assert.NoError(t, err)
+	sig.Signature[0] ^= 0xff
+	targets.Signatures[1] = *sig
+	err = root.VerifyDelegate(TARGETS, targets)
+	assert.NoError(t, err)
+	err = root.VerifyDelegateStrict(TARGETS, targets)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{Msg: fmt.Sprintf("Verifying targets failed, invalid signatures from key IDs %s", keyIDs[1])})
+
+	// Test that the threshold is still checked first
+	root.Signed.Roles[TARGETS].Threshold = 2
+	err = root.VerifyDelegateStrict(TARGETS, targets)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{Msg: "Verifying targets failed, not enough signatures, got 1, want 2"})
+}
+
+// newMultiKeyRoot returns a root delegating role to n keys with a threshold
+// of n, with the signers of its keys, which are RSA keys if rsaKeys is set
+// and ed25519 keys otherwise. The root isn't signed
+func newMultiKeyRoot(tb testing.TB, role string, n int, rsaKeys bool) (*Metadata[RootType], []signature.Signer) {
+	root := Root(fixedExpire)
+	signers := []signature.Signer{}
+	for i := 0; i < n; i++ {
+		var signer signature.Signer
+		var publicKey crypto.PublicKey
+		if rsaKeys {
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			assert.NoError(tb, err)
+			signer, err = signature.LoadRSAPSSSigner(privateKey, crypto.SHA256, &rsa.PSSOptions{Hash: crypto.SHA256})
+			assert.NoError(tb, err)
+			publicKey = privateKey.Public()
+		} else {
+			public, privateKey, err := ed25519.GenerateKey(nil)
+			assert.NoError(tb, err)
+			signer, err = signature.LoadSigner(privateKey, crypto.Hash(0))
+			assert.NoError(tb, err)
+			publicKey = public
+		}
+		key, err := KeyFromPublicKey(publicKey)
+		assert.NoError(tb, err)
+		err = root.Signed.AddKey(key, role)
+		assert.NoError(tb, err)
+		signers = append(signers, signer)
+	}
+	root.Signed.Roles[role].Threshold = n
+	return root, signers
+}
+
+func TestVerifyDelegateConcurrent(t *testing.T) {
+	root, signers := newMultiKeyRoot(t, TARGETS, 10, false)
+	targets := Targets(fixedExpire)
+	for _, signer := range signers {
+		_, err := targets.Sign(signer)
+		assert.NoError(t, err)
+	}
+	keyIDs := root.Signed.Roles[TARGETS].KeyIDs
+
+	tests := []struct {
+		name     string
+		modify   func(root *Metadata[RootType], targets *Metadata[TargetsType])
+		expected error
+	}{
+		{
+			name:   "all signatures valid",
+			modify: func(root *Metadata[RootType], targets *Metadata[TargetsType]) {},
+		},
+		{
+			name: "invalid signature",
+			modify: func(root *Metadata[RootType], targets *Metadata[TargetsType]) {
+				targets.Signatures[3].Signature[0] ^= 0xff
+			},
+			expected: ErrUnsignedMetadata{Msg: "Verifying targets failed, not enough signatures, got 9, want 10"},
+		},
+		{
+			name: "missing signatures within threshold",
+			modify: func(root *Metadata[RootType], targets *Metadata[TargetsType]) {
+				targets.Signatures = targets.Signatures[:7]
+				root.Signed.Roles[TARGETS].Threshold = 7
+			},
+		},
+		{
+			name: "missing signatures below threshold",
+			modify: func(root *Metadata[RootType], targets *Metadata[TargetsType]) {
+				targets.Signatures = targets.Signatures[:7]
+				root.Signed.Roles[TARGETS].Threshold = 8
+			},
+			expected: ErrUnsignedMetadata{Msg: "Verifying targets failed, not enough signatures, got 7, want 8"},
+		},
+		{
+			name: "missing key",
+			modify: func(root *Metadata[RootType], targets *Metadata[TargetsType]) {
+				delete(root.Signed.Keys, keyIDs[5])
+			},
+			expected: ErrValue{Msg: fmt.Sprintf("key with ID %s not found in targets keyids", keyIDs[5])},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootData, err := root.ToBytes(false)
+			assert.NoError(t, err)
+			delegator, err := Root().FromBytes(rootData)
+			assert.NoError(t, err)
+			targetsData, err := targets.ToBytes(false)
+			assert.NoError(t, err)
+			delegated, err := Targets().FromBytes(targetsData)
+			assert.NoError(t, err)
+			tt.modify(delegator, delegated)
+
+			expected := delegator.VerifyDelegate(TARGETS, delegated)
+			assert.Equal(t, tt.expected, expected)
+			for _, workers := range []int{0, 1, 3, 10, 32} {
+				err := delegator.VerifyDelegateConcurrent(TARGETS, delegated, workers)
+				assert.Equal(t, expected, err, "workers %d", workers)
+			}
+		})
+	}
+}
+
+func BenchmarkVerifyDelegate(b *testing.B) {
+	root, signers := newMultiKeyRoot(b, ROOT, 10, true)
+	for _, signer := range signers {
+		_, err := root.Sign(signer)
+		assert.NoError(b, err)
+	}
+
+	b.Run("Sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := root.VerifyDelegate(ROOT, root); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Concurrent", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := root.VerifyDelegateConcurrent(ROOT, root, 10); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// flakySigner fails the first failures signing attempts with errTransientSigner
+type flakySigner struct {
+	signature.Signer
+	failures int
+	attempts int
+}
+
+var errTransientSigner = errors.New("transient signer error")
+
+func (s *flakySigner) SignMessage(message io.Reader, opts ...signature.SignOption) ([]byte, error) {
+	s.attempts++
+	if s.attempts <= s.failures {
+		return nil, errTransientSigner
+	}
+	return s.Signer.SignMessage(message, opts...)
+}
+
+func TestSignWrapsSignerErrors(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	ed25519Signer, err := signature.LoadSigner(privateKey, crypto.Hash(0))
+	assert.NoError(t, err)
+	signer := &flakySigner{Signer: ed25519Signer, failures: 1}
+
+	// Test that the underlying signer error is retrievable
+	root := Root(fixedExpire)
+	_, err = root.Sign(signer)
+	assert.ErrorIs(t, err, errTransientSigner)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+	assert.Empty(t, root.Signatures)
+
+	// Test that retrying succeeds once the signer recovers
+	sig, err := root.Sign(signer)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, signer.attempts)
+	assert.Equal(t, []Signature{*sig}, root.Signatures)
+	key, err := KeyFromPublicKey(privateKey.Public())
+	assert.NoError(t, err)
+	payload, err := root.Signed.MarshalJSON()
+	assert.NoError(t, err)
+	assert.NoError(t, key.VerifySignature(*sig, payload))
+
+	// Test that a cancelled context stops signing before calling the signer
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = root.SignWithContext(ctx, signer)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, signer.attempts)
+	assert.Len(t, root.Signatures, 1)
+}
+
+// recordingSigner records the last message it was asked to sign
+type recordingSigner struct {
+	signature.Signer
+	message []byte
+}
+
+func (s *recordingSigner) SignMessage(message io.Reader, opts ...signature.SignOption) ([]byte, error) {
+	data, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	s.message = data
+	return s.Signer.SignMessage(bytes.NewReader(data), opts...)
+}
+
+func TestSignedBytesAndAddSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	ed25519Signer, err := signature.LoadSigner(privateKey, crypto.Hash(0))
+	assert.NoError(t, err)
+	signer := &recordingSigner{Signer: ed25519Signer}
+
+	// Test that SignedBytes is the payload passed to the signer
+	root := Root(fixedExpire)
+	payload, err := root.SignedBytes()
+	assert.NoError(t, err)
+	_, err = root.Sign(signer)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, signer.message)
+
+	// Test that a signature made externally over SignedBytes can be added
+	targets := Targets(fixedExpire)
+	payload, err = targets.SignedBytes()
+	assert.NoError(t, err)
+	key, err := KeyFromPublicKey(publicKey)
+	assert.NoError(t, err)
+	sig := Signature{KeyID: key.ID(), Signature: ed25519.Sign(privateKey, payload)}
+	err = targets.AddSignature(sig, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []Signature{sig}, targets.Signatures)
+	root.Signed.Keys[key.ID()] = key
+	root.Signed.Roles[TARGETS].KeyIDs = []string{key.ID()}
+	err = root.VerifyDelegate(TARGETS, targets)
+	assert.NoError(t, err)
+
+	// Test that adding the same signature again does nothing
+	err = targets.AddSignature(sig, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []Signature{sig}, targets.Signatures)
+
+	// Test that a different signature by the same key and empty key IDs
+	// are rejected
+	conflicting := Signature{KeyID: sig.KeyID, Signature: bytes.Clone(sig.Signature)}
+	conflicting.Signature[0] ^= 0xff
+	err = targets.AddSignature(conflicting, key)
+	assert.ErrorIs(t, err, ErrValue{fmt.Sprintf("conflicting signature for key ID %s, a different signature by that key is already present", key.ID())})
+	assert.Equal(t, []Signature{sig}, targets.Signatures)
+	err = targets.AddSignature(Signature{Signature: sig.Signature}, key)
+	assert.ErrorIs(t, err, ErrValue{"signature has no key ID"})
+
+	// Test that invalid signatures are rejected
+	snapshot := Snapshot(fixedExpire)
+	err = snapshot.AddSignature(sig, key)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	otherKey, err := KeyFromPublicKey(otherPublicKey)
+	assert.NoError(t, err)
+	err = snapshot.AddSignature(sig, otherKey)
+	assert.ErrorIs(t, err, ErrValue{fmt.Sprintf("signature key ID %s does not match key ID %s", key.ID(), otherKey.ID())})
+	assert.Empty(t, snapshot.Signatures)
+	assert.Len(t, targets.Signatures, 1)
+}
+
+func TestCollectSignatures(t *testing.T) {
+	targets := Targets(fixedExpire)
+	payload, err := targets.SignedBytes()
+	assert.NoError(t, err)
+	keys := map[string]*Key{}
+	sigs := []Signature{}
+	for i := 0; i < 3; i++ {
+		publicKey, privateKey, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		key, err := KeyFromPublicKey(publicKey)
+		assert.NoError(t, err)
+		keys[key.ID()] = key
+		sigs = append(sigs, Signature{KeyID: key.ID(), Signature: ed25519.Sign(privateKey, payload)})
+	}
+
+	// Test signatures arriving one at a time until the threshold of 2
+	met, err := targets.CollectSignatures(nil, keys, 2)
+	assert.NoError(t, err)
+	assert.False(t, met)
+	met, err = targets.CollectSignatures(sigs[:1], keys, 2)
+	assert.NoError(t, err)
+	assert.False(t, met)
+	// duplicates are skipped
+	met, err = targets.CollectSignatures(sigs[:1], keys, 2)
+	assert.NoError(t, err)
+	assert.False(t, met)
+	assert.Len(t, targets.Signatures, 1)
+	met, err = targets.CollectSignatures(sigs[1:2], keys, 2)
+	assert.NoError(t, err)
+	assert.True(t, met)
+	met, err = targets.CollectSignatures(sigs, keys, 2)
+	assert.NoError(t, err)
+	assert.True(t, met)
+	assert.Equal(t, sigs, targets.Signatures)
+
+	// Test a different signature by a key already collected, or twice in
+	// the same batch, rejects the whole batch
+	conflicting := Signature{KeyID: sigs[0].KeyID, Signature: bytes.Clone(sigs[0].Signature)}
+	conflicting.Signature[0] ^= 0xff
+	_, err = targets.CollectSignatures([]Signature{conflicting}, keys, 2)
+	assert.ErrorIs(t, err, ErrValue{fmt.Sprintf("conflicting signature for key ID %s, a different signature by that key is already present", sigs[0].KeyID)})
+	assert.Equal(t, sigs, targets.Signatures)
+	batch := Targets(fixedExpire)
+	_, err = batch.CollectSignatures([]Signature{sigs[0], conflicting}, keys, 1)
+	assert.ErrorIs(t, err, ErrValue{fmt.Sprintf("conflicting signature for key ID %s, a different signature by that key is already present", sigs[0].KeyID)})
+	assert.Empty(t, batch.Signatures)
+
+	// Test the collected signatures verify
+	root := Root(fixedExpire)
+	root.Signed.Roles[TARGETS].Threshold = 3
+	for _, key := range keys {
+		assert.NoError(t, root.Signed.AddKey(key, TARGETS))
+	}
+	assert.NoError(t, root.VerifyDelegate(TARGETS, targets))
+
+	// Test invalid signatures and unknown keys reject the whole batch
+	snapshot := Snapshot(fixedExpire)
+	snapshotPayload, err := snapshot.SignedBytes()
+	assert.NoError(t, err)
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	unknown := Signature{KeyID: "unknown", Signature: ed25519.Sign(privateKey, snapshotPayload)}
+	_, err = snapshot.CollectSignatures([]Signature{unknown}, keys, 1)
+	assert.ErrorIs(t, err, ErrValue{"no key to verify signature for key ID unknown"})
+	_, err = snapshot.CollectSignatures(sigs, keys, 1)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+	assert.Empty(t, snapshot.Signatures)
+	_, err = snapshot.CollectSignatures(sigs, keys, 0)
+	assert.ErrorIs(t, err, ErrValue{"threshold must be at least 1, got 0"})
+
+	// Test signatures over a previous Signed part are dropped
+	targets.Signed.Version++
+	met, err = targets.CollectSignatures(nil, keys, 1)
+	assert.NoError(t, err)
+	assert.False(t, met)
+	assert.Empty(t, targets.Signatures)
+
+	// Test signatures already present that don't verify aren't counted
+	invalid := Targets(fixedExpire)
+	invalid.Signed.Version++
+	invalid.Signatures = append([]Signature{}, sigs...)
+	met, err = invalid.CollectSignatures(nil, keys, 1)
+	assert.NoError(t, err)
+	assert.False(t, met)
+	invalidPayload, err := invalid.SignedBytes()
+	assert.NoError(t, err)
+	invalid.Signatures[0].Signature = ed25519.Sign(privateKey, invalidPayload)
+	met, err = invalid.CollectSignatures(nil, keys, 1)
+	assert.NoError(t, err)
+	assert.False(t, met)
+}
+
 func TestKeyVerifyFailures(t *testing.T) {
 	root, err := Root().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
 	assert.NoError(t, err)
@@ -453,7 +1000,7 @@ func TestKeyVerifyFailures(t *testing.T) {
 	timestampPublicKey, err = timestampKey.ToPublicKey()
 	assert.NoError(t, err)
 	timestampHash = crypto.SHA256
-	timestampVerifier, err = signature.LoadVerifier(timestampPublicKey, timestampHash)
+	timestampVerifier, err = signature.LoadRSAPSSVerifier(timestampPublicKey.(*rsa.PublicKey), timestampHash, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
 	assert.NoError(t, err)
 	err = timestampVerifier.VerifySignature(bytes.NewReader(timestampSig), bytes.NewReader(data))
 	assert.NoError(t, err)
@@ -463,7 +1010,7 @@ func TestKeyVerifyFailures(t *testing.T) {
 	public := timestampKey.Value.PublicKey
 	timestampKey.Value.PublicKey = "ffff"
 	timestampBrokenPublicKey, err := timestampKey.ToPublicKey()
-	assert.ErrorContains(t, err, "PEM decoding failed")
+	assert.ErrorContains(t, err, "failed to parse DER public key")
 	timestampHash = crypto.SHA256
 	timestampNilVerifier, err := signature.LoadVerifier(timestampBrokenPublicKey, timestampHash)
 	assert.ErrorContains(t, err, "unsupported public key type")
@@ -502,6 +1049,91 @@ func TestMetadataSignedIsExpired(t *testing.T) {
 	assert.False(t, isExpired)
 }
 
+func TestRoleVersion(t *testing.T) {
+	snapshot, err := Snapshot().FromFile(filepath.Join(testutils.RepoDir, "snapshot.json"))
+	assert.NoError(t, err)
+	snapshot.Signed.Meta["role1.json"] = MetaFile(5)
+
+	// Test present roles
+	version, ok := snapshot.Signed.RoleVersion(TARGETS)
+	assert.True(t, ok)
+	assert.Equal(t, snapshot.Signed.Meta["targets.json"].Version, version)
+	version, ok = snapshot.Signed.RoleVersion("role1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), version)
+
+	// Test absent roles
+	_, ok = snapshot.Signed.RoleVersion("role3")
+	assert.False(t, ok)
+	_, ok = snapshot.Signed.RoleVersion("role1.json")
+	assert.False(t, ok)
+
+	timestamp, err := Timestamp().FromFile(filepath.Join(testutils.RepoDir, "timestamp.json"))
+	assert.NoError(t, err)
+	version, ok = timestamp.Signed.SnapshotVersion()
+	assert.True(t, ok)
+	assert.Equal(t, timestamp.Signed.Meta["snapshot.json"].Version, version)
+	delete(timestamp.Signed.Meta, "snapshot.json")
+	_, ok = timestamp.Signed.SnapshotVersion()
+	assert.False(t, ok)
+}
+
+func TestVerifyRootChain(t *testing.T) {
+	newRootKey := func() (signature.Signer, *Key) {
+		public, private, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		signer, err := signature.LoadSigner(private, crypto.Hash(0))
+		assert.NoError(t, err)
+		key, err := KeyFromPublicKey(public)
+		assert.NoError(t, err)
+		return signer, key
+	}
+	newRoot := func(version int64, key *Key, signers ...signature.Signer) *Metadata[RootType] {
+		root := Root(fixedExpire)
+		root.Signed.Version = version
+		assert.NoError(t, root.Signed.AddKey(key, ROOT))
+		for _, signer := range signers {
+			_, err := root.Sign(signer)
+			assert.NoError(t, err)
+		}
+		return root
+	}
+	toBytes := func(root *Metadata[RootType]) []byte {
+		data, err := root.ToBytes(false)
+		assert.NoError(t, err)
+		return data
+	}
+	signer1, key1 := newRootKey()
+	signer2, key2 := newRootKey()
+	root1 := newRoot(1, key1, signer1)
+	// v2 rotates the root key and is signed by both the old and new key
+	root2 := newRoot(2, key2, signer1, signer2)
+	root3 := newRoot(3, key2, signer2)
+
+	// Test a valid chain
+	trusted, err := VerifyRootChain(root1, toBytes(root2), toBytes(root3))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), trusted.Signed.Version)
+	trusted, err = VerifyRootChain(root1)
+	assert.NoError(t, err)
+	assert.Same(t, root1, trusted)
+
+	// Test a chain missing the new root self-signature
+	root2 = newRoot(2, key2, signer1)
+	_, err = VerifyRootChain(root1, toBytes(root2), toBytes(root3))
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{Msg: "Verifying root failed, not enough signatures, got 0, want 1"})
+
+	// Test a chain missing the predecessor signature
+	root2 = newRoot(2, key2, signer2)
+	_, err = VerifyRootChain(root1, toBytes(root2))
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{Msg: "Verifying root failed, not enough signatures, got 0, want 1"})
+
+	// Test a chain skipping a version
+	root3 = newRoot(3, key2, signer1, signer2)
+	_, err = VerifyRootChain(root1, toBytes(root3))
+	assert.ErrorIs(t, err, ErrBadVersionNumber{Msg: "bad version number, expected 2, got 3"})
+}
+
 func TestMetadataVerifyDelegate(t *testing.T) {
 
 	root, err := Root().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
@@ -581,7 +1213,7 @@ func TestMetadataVerifyDelegate(t *testing.T) {
 
 	// Verify succeeds when we correct the new signature and reach the
 	// threshold of 2 keys
-	signer, err := signature.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "timestamp_key"), crypto.SHA256, cryptoutils.SkipPassword)
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "timestamp_key"))
 	assert.NoError(t, err)
 	_, err = snapshot.Sign(signer)
 	assert.NoError(t, err)
@@ -589,12 +1221,38 @@ func TestMetadataVerifyDelegate(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSignatureUnrecognizedFields(t *testing.T) {
+	root, err := Root().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
+	assert.NoError(t, err)
+	snapshot, err := Snapshot().FromFile(filepath.Join(testutils.RepoDir, "snapshot.json"))
+	assert.NoError(t, err)
+	data, err := snapshot.ToBytes(false)
+	assert.NoError(t, err)
+	assert.Len(t, snapshot.Signatures, 1)
+
+	// Annotate the signature with a method field, keys are serialized sorted
+	annotated := bytes.Replace(data, []byte(`","sig":"`), []byte(`","method":"ed25519","sig":"`), 1)
+	assert.NotEqual(t, data, annotated)
+	snapshot, err = Snapshot().FromBytes(annotated)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"method": "ed25519"}, snapshot.Signatures[0].UnrecognizedFields)
+
+	// Test the annotated signature still verifies
+	err = root.VerifyDelegate(SNAPSHOT, snapshot)
+	assert.NoError(t, err)
+
+	// Test the annotation survives a round trip
+	roundTrip, err := snapshot.ToBytes(false)
+	assert.NoError(t, err)
+	assert.Equal(t, annotated, roundTrip)
+}
+
 func TestRootAddKeyAndRevokeKey(t *testing.T) {
 	root, err := Root().FromFile(filepath.Join(testutils.RepoDir, "root.json"))
 	assert.NoError(t, err)
 
 	// Create a new key
-	signer, err := signature.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "root_key2"), crypto.SHA256, cryptoutils.SkipPassword)
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "root_key2"))
 	assert.NoError(t, err)
 	key, err := signer.PublicKey()
 	assert.NoError(t, err)
@@ -651,6 +1309,198 @@ func TestRootAddKeyAndRevokeKey(t *testing.T) {
 	assert.ErrorIs(t, err, ErrValue{"role nosuchrole doesn't exist"})
 }
 
+func TestRootDiff(t *testing.T) {
+	newKey := func() *Key {
+		public, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		key, err := KeyFromPublicKey(public)
+		assert.NoError(t, err)
+		return key
+	}
+	oldRoot := Root()
+	keys := map[string]*Key{}
+	for _, role := range TOP_LEVEL_ROLE_NAMES {
+		keys[role] = newKey()
+		assert.NoError(t, oldRoot.Signed.AddKey(keys[role], role))
+	}
+	newRoot, err := Root().FromBytes(mustToBytes(t, oldRoot))
+	assert.NoError(t, err)
+
+	// Test no differences
+	assert.Empty(t, oldRoot.Signed.Diff(&newRoot.Signed).Roles)
+
+	// Revoke and rotate the timestamp key, bump the targets threshold
+	assert.NoError(t, newRoot.Signed.RevokeKey(keys[TIMESTAMP].ID(), TIMESTAMP))
+	rotatedKey := newKey()
+	assert.NoError(t, newRoot.Signed.AddKey(rotatedKey, TIMESTAMP))
+	newRoot.Signed.Roles[TARGETS].Threshold = 2
+
+	diff := oldRoot.Signed.Diff(&newRoot.Signed)
+	assert.Len(t, diff.Roles, 2)
+	assert.Equal(t, &RoleDiff{
+		AddedKeyIDs:   []string{rotatedKey.ID()},
+		RemovedKeyIDs: []string{keys[TIMESTAMP].ID()},
+		OldThreshold:  1,
+		NewThreshold:  1,
+	}, diff.Roles[TIMESTAMP])
+	assert.Equal(t, &RoleDiff{
+		AddedKeyIDs:   []string{},
+		RemovedKeyIDs: []string{},
+		OldThreshold:  1,
+		NewThreshold:  2,
+	}, diff.Roles[TARGETS])
+}
+
+func TestHashBytes(t *testing.T) {
+	targets, err := Targets().FromFile(filepath.Join(testutils.RepoDir, "targets.json"))
+	assert.NoError(t, err)
+	data, err := targets.ToBytes(false)
+	assert.NoError(t, err)
+
+	// Test the default sha256 hash over the published bytes
+	hashes, length, err := targets.HashBytes()
+	assert.NoError(t, err)
+	assert.Len(t, hashes, 1)
+	assert.Contains(t, hashes, "sha256")
+	assert.Equal(t, int64(len(data)), length)
+	assert.NoError(t, verifyHashes(data, hashes))
+	assert.NoError(t, verifyLength(data, length))
+
+	// Test the result fits in the snapshot meta
+	metaFile := MetaFile(targets.Signed.Version)
+	metaFile.SetLength(length)
+	metaFile.Hashes = hashes
+	assert.NoError(t, metaFile.VerifyLengthHashes(data))
+
+	// Test multiple algorithms
+	hashes, _, err = targets.HashBytes("sha256", "sha512")
+	assert.NoError(t, err)
+	assert.Len(t, hashes, 2)
+	assert.NoError(t, verifyHashes(data, hashes))
+
+	// Test the hashes follow changes to the metadata
+	targets.ClearSignatures()
+	targets.Signed.Version += 1
+	newHashes, _, err := targets.HashBytes()
+	assert.NoError(t, err)
+	assert.Error(t, verifyHashes(data, newHashes))
+
+	// Test an unsupported algorithm
+	_, _, err = targets.HashBytes("md5")
+	assert.ErrorContains(t, err, "unsupported hashing algorithm - md5")
+}
+
+func TestDiffTargets(t *testing.T) {
+	newTarget := func(content string) *TargetFiles {
+		targetFile, err := TargetFile().FromBytes("", []byte(content))
+		assert.NoError(t, err)
+		return targetFile
+	}
+	oldTargets := Targets(fixedExpire)
+	oldTargets.Signed.Targets["unchanged.txt"] = newTarget("unchanged")
+	oldTargets.Signed.Targets["content.txt"] = newTarget("old content")
+	oldTargets.Signed.Targets["length.txt"] = newTarget("old")
+	oldTargets.Signed.Targets["removed.txt"] = newTarget("removed")
+	newTargets, err := Targets().FromBytes(mustToBytes(t, oldTargets))
+	assert.NoError(t, err)
+
+	// Test no differences
+	diff := oldTargets.Signed.DiffTargets(&newTargets.Signed)
+	assert.Equal(t, &TargetsDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}, diff)
+
+	// Test each kind of change, a hash change with the same length and a
+	// length change with the same hashes both count as modified
+	newTargets.Signed.Targets["content.txt"] = newTarget("new content")
+	newTargets.Signed.Targets["length.txt"].Length = 42
+	newTargets.Signed.Targets["added.txt"] = newTarget("added")
+	newTargets.Signed.Targets["dir/added.txt"] = newTarget("added")
+	delete(newTargets.Signed.Targets, "removed.txt")
+	diff = oldTargets.Signed.DiffTargets(&newTargets.Signed)
+	assert.Equal(t, []string{"added.txt", "dir/added.txt"}, diff.Added)
+	assert.Equal(t, []string{"removed.txt"}, diff.Removed)
+	assert.Equal(t, []string{"content.txt", "length.txt"}, diff.Modified)
+
+	// Test the reverse direction
+	diff = newTargets.Signed.DiffTargets(&oldTargets.Signed)
+	assert.Equal(t, []string{"removed.txt"}, diff.Added)
+	assert.Equal(t, []string{"added.txt", "dir/added.txt"}, diff.Removed)
+	assert.Equal(t, []string{"content.txt", "length.txt"}, diff.Modified)
+}
+
+func TestWouldMeetThresholdAfterRevoke(t *testing.T) {
+	root := Root()
+	keyIDs := []string{}
+	for i := 0; i < 3; i++ {
+		public, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		key, err := KeyFromPublicKey(public)
+		assert.NoError(t, err)
+		assert.NoError(t, root.Signed.AddKey(key, TARGETS))
+		keyIDs = append(keyIDs, key.ID())
+	}
+	root.Signed.Roles[TARGETS].Threshold = 2
+	sigs := []Signature{{KeyID: keyIDs[0]}, {KeyID: keyIDs[1]}, {KeyID: "unauthorized"}}
+
+	// Test revoking a key which didn't sign
+	ok, err := root.Signed.WouldMeetThresholdAfterRevoke(keyIDs[2], TARGETS, sigs)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Test revoking a signing key drops the role below threshold
+	ok, err = root.Signed.WouldMeetThresholdAfterRevoke(keyIDs[0], TARGETS, sigs)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, root.Signed.Roles[TARGETS].KeyIDs, keyIDs[0])
+
+	// Test duplicate signatures from one key count once
+	ok, err = root.Signed.WouldMeetThresholdAfterRevoke(keyIDs[0], TARGETS, append(sigs, Signature{KeyID: keyIDs[1]}))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Test a third signature keeps the role at threshold
+	ok, err = root.Signed.WouldMeetThresholdAfterRevoke(keyIDs[0], TARGETS, append(sigs, Signature{KeyID: keyIDs[2]}))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Test invalid role and key
+	_, err = root.Signed.WouldMeetThresholdAfterRevoke(keyIDs[0], "nosuchrole", sigs)
+	assert.ErrorIs(t, err, ErrValue{"role nosuchrole doesn't exist"})
+	_, err = root.Signed.WouldMeetThresholdAfterRevoke("unauthorized", TARGETS, sigs)
+	assert.ErrorIs(t, err, ErrValue{"key with id unauthorized is not used by targets"})
+}
+
+func TestCheckRotationSafety(t *testing.T) {
+	newKey := func() *Key {
+		public, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		key, err := KeyFromPublicKey(public)
+		assert.NoError(t, err)
+		return key
+	}
+	oldRoot := Root()
+	oldKey := newKey()
+	assert.NoError(t, oldRoot.Signed.AddKey(oldKey, ROOT))
+	newRoot, err := Root().FromBytes(mustToBytes(t, oldRoot))
+	assert.NoError(t, err)
+	newRoot.Signed.Version = 2
+
+	// Test overlapping root key sets
+	assert.NoError(t, oldRoot.Signed.CheckRotationSafety(&newRoot.Signed))
+	rotatedKey := newKey()
+	assert.NoError(t, newRoot.Signed.AddKey(rotatedKey, ROOT))
+	assert.NoError(t, oldRoot.Signed.CheckRotationSafety(&newRoot.Signed))
+
+	// Test disjoint root key sets
+	assert.NoError(t, newRoot.Signed.RevokeKey(oldKey.ID(), ROOT))
+	err = oldRoot.Signed.CheckRotationSafety(&newRoot.Signed)
+	assert.ErrorIs(t, err, ErrValue{"root version 2 shares no root keys with root version 1"})
+
+	// Test other roles sharing keys don't count
+	assert.NoError(t, newRoot.Signed.AddKey(oldKey, TARGETS))
+	err = oldRoot.Signed.CheckRotationSafety(&newRoot.Signed)
+	assert.ErrorIs(t, err, ErrValue{"root version 2 shares no root keys with root version 1"})
+}
+
 func TestTargetsKeyAPI(t *testing.T) {
 	targets, err := Targets().FromFile(filepath.Join(testutils.RepoDir, "targets.json"))
 	assert.NoError(t, err)
@@ -845,6 +1695,32 @@ func TestLengthAndHashValidation(t *testing.T) {
 	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{"hash verification failed - mismatch for algorithm sha256"})
 }
 
+func TestDetectAndVerify(t *testing.T) {
+	data := []byte("externally provided file")
+	sha256Digest := sha256.Sum256(data)
+	sha512Digest := sha512.Sum512(data)
+
+	// Test the algorithm is detected from the digest length
+	algorithm, err := DetectAndVerify(data, hex.EncodeToString(sha256Digest[:]))
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256", algorithm)
+	algorithm, err = DetectAndVerify(data, hex.EncodeToString(sha512Digest[:]))
+	assert.NoError(t, err)
+	assert.Equal(t, "sha512", algorithm)
+
+	// Test failure on mismatching data
+	_, err = DetectAndVerify([]byte("other file"), hex.EncodeToString(sha256Digest[:]))
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+	_, err = DetectAndVerify([]byte("other file"), hex.EncodeToString(sha512Digest[:]))
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha512"})
+
+	// Test failure on unsupported or malformed digests
+	_, err = DetectAndVerify(data, hex.EncodeToString(sha256Digest[:20]))
+	assert.ErrorIs(t, err, ErrValue{Msg: "unsupported digest length 20"})
+	_, err = DetectAndVerify(data, "not hex")
+	assert.ErrorContains(t, err, "failed to decode expected digest")
+}
+
 func TestTargetFileFromFile(t *testing.T) {
 	// Test with an existing file and valid hash algorithm
 	targetFilePath := filepath.Join(testutils.TargetsDir, "file1.txt")
@@ -867,6 +1743,92 @@ func TestTargetFileFromFile(t *testing.T) {
 	assert.ErrorIs(t, err, ErrValue{"failed generating TargetFile - unsupported hashing algorithm - 123"})
 }
 
+func TestBuildSnapshotAndTimestamp(t *testing.T) {
+	targets := map[string]*Metadata[TargetsType]{}
+	for _, roleName := range []string{TARGETS, "role1", "role2"} {
+		role, err := Targets().FromFile(filepath.Join(testutils.RepoDir, fmt.Sprintf("%s.json", roleName)))
+		assert.NoError(t, err)
+		targets[roleName] = role
+	}
+	repoSnapshot, err := Snapshot().FromFile(filepath.Join(testutils.RepoDir, "snapshot.json"))
+	assert.NoError(t, err)
+
+	// Test the snapshot meta matches the targets metadata
+	snapshot, err := BuildSnapshot(targets, fixedExpire)
+	assert.NoError(t, err)
+	assert.Equal(t, fixedExpire, snapshot.Signed.Expires)
+	assert.Len(t, snapshot.Signed.Meta, len(targets))
+	for roleName, role := range targets {
+		metaFile := snapshot.Signed.Meta[fmt.Sprintf("%s.json", roleName)]
+		if assert.NotNil(t, metaFile) {
+			assert.Equal(t, repoSnapshot.Signed.Meta[fmt.Sprintf("%s.json", roleName)].Version, metaFile.Version)
+			data, err := role.ToBytes(false)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(data)), metaFile.Length)
+			assert.NoError(t, metaFile.VerifyLengthHashes(data))
+		}
+	}
+	// Test building is deterministic
+	rebuilt, err := BuildSnapshot(targets, fixedExpire)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot.Signed, rebuilt.Signed)
+
+	// Test the timestamp meta matches the snapshot metadata
+	snapshot.Signed.Version = 3
+	timestamp, err := BuildTimestamp(snapshot, fixedExpire)
+	assert.NoError(t, err)
+	assert.Equal(t, fixedExpire, timestamp.Signed.Expires)
+	assert.Len(t, timestamp.Signed.Meta, 1)
+	metaFile := timestamp.Signed.Meta["snapshot.json"]
+	assert.Equal(t, int64(3), metaFile.Version)
+	data, err := snapshot.ToBytes(false)
+	assert.NoError(t, err)
+	assert.NoError(t, metaFile.VerifyLengthHashes(data))
+
+	// Test failure on targets modified after signing
+	targets["role1"].Signed.Version += 1
+	_, err = BuildSnapshot(targets, fixedExpire)
+	assert.ErrorIs(t, err, ErrValue{Msg: "signed metadata was modified after signing, re-sign or clear the stale signatures"})
+}
+
+func TestTargetsAddTargetsFromDir(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"file1.txt":           "file1 content",
+		"dir/file2.txt":       "file2 content",
+		"dir/nested/file3.go": "file3 content",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "empty"), 0750))
+
+	targets := Targets(fixedExpire)
+	err := targets.Signed.AddTargetsFromDir(root, "sha256", "sha512")
+	assert.NoError(t, err)
+	assert.Len(t, targets.Signed.Targets, len(files))
+	for name, content := range files {
+		targetFile, ok := targets.Signed.Targets[name]
+		if !assert.True(t, ok, "missing target %s", name) {
+			continue
+		}
+		assert.Equal(t, name, targetFile.Path)
+		assert.Contains(t, targetFile.Hashes, "sha256")
+		assert.Contains(t, targetFile.Hashes, "sha512")
+		assert.NoError(t, targetFile.VerifyLengthHashes([]byte(content)))
+	}
+
+	// Test with an unsupported algorithm
+	err = Targets(fixedExpire).Signed.AddTargetsFromDir(root, "123")
+	assert.ErrorIs(t, err, ErrValue{"failed generating TargetFile - unsupported hashing algorithm - 123"})
+
+	// Test with a non-existing directory
+	err = Targets(fixedExpire).Signed.AddTargetsFromDir(filepath.Join(root, "non-existing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
 func TestTargetFileCustom(t *testing.T) {
 	// Test creating TargetFile and accessing custom.
 	targetFile := TargetFile()
@@ -926,6 +1888,28 @@ func TestIsDelegatedRole(t *testing.T) {
 	assert.True(t, matching)
 }
 
+func TestDelegatedRoleConversion(t *testing.T) {
+	role := &Role{KeyIDs: []string{"keyid1", "keyid2"}, Threshold: 2}
+	delegatedRole := NewDelegatedRole("role1", role, []string{"files/*"}, true)
+	assert.Equal(t, &DelegatedRole{
+		Name:        "role1",
+		KeyIDs:      []string{"keyid1", "keyid2"},
+		Threshold:   2,
+		Terminating: true,
+		Paths:       []string{"files/*"},
+	}, delegatedRole)
+
+	// Test round-tripping the shared fields
+	assert.Equal(t, role, delegatedRole.ToRole())
+
+	// Test the converted roles don't share the key IDs
+	delegatedRole.KeyIDs[0] = "keyid3"
+	assert.Equal(t, []string{"keyid1", "keyid2"}, role.KeyIDs)
+	converted := delegatedRole.ToRole()
+	converted.KeyIDs[1] = "keyid4"
+	assert.Equal(t, []string{"keyid3", "keyid2"}, delegatedRole.KeyIDs)
+}
+
 func TestIsDelegatedRoleInSuccinctRoles(t *testing.T) {
 	succinctRoles := &SuccinctRoles{
 		KeyIDs:     []string{},
@@ -976,3 +1960,518 @@ func TestGetRolesInSuccinctRoles(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf("bin-%s", expectedBinSuffix), roleName)
 	}
 }
+
+func TestValidateDelegationGraph(t *testing.T) {
+	delegate := func(delegator *Metadata[TargetsType], names ...string) {
+		delegator.Signed.Delegations = &Delegations{Keys: map[string]*Key{}}
+		for _, name := range names {
+			delegator.Signed.Delegations.Roles = append(delegator.Signed.Delegations.Roles, DelegatedRole{
+				Name:      name,
+				KeyIDs:    []string{},
+				Threshold: 1,
+				Paths:     []string{"*"},
+			})
+		}
+	}
+	targets := map[string]*Metadata[TargetsType]{
+		TARGETS: Targets(),
+		"a":     Targets(),
+		"b":     Targets(),
+		"c":     Targets(),
+	}
+	delegate(targets[TARGETS], "a", "b")
+	delegate(targets["a"], "c")
+
+	// Test a valid graph, "b" reached twice is not a cycle
+	delegate(targets["c"], "b")
+	assert.NoError(t, ValidateDelegationGraph(targets, 3))
+
+	// Test an over-depth chain
+	err := ValidateDelegationGraph(targets, 2)
+	assert.ErrorIs(t, err, ErrValue{"delegation chain exceeds max depth 2: targets -> a -> c -> b"})
+
+	// Test a cyclic delegation
+	delegate(targets["c"], "a")
+	err = ValidateDelegationGraph(targets, 32)
+	assert.ErrorIs(t, err, ErrValue{"delegation cycle found: targets -> a -> c -> a"})
+
+	// Test missing top-level targets
+	delete(targets, TARGETS)
+	err = ValidateDelegationGraph(targets, 32)
+	assert.ErrorIs(t, err, ErrValue{"no targets metadata found"})
+
+	// Test a role reached again deeper is validated again
+	targets = map[string]*Metadata[TargetsType]{
+		TARGETS: Targets(),
+		"p":     Targets(),
+		"q":     Targets(),
+		"x":     Targets(),
+	}
+	delegate(targets[TARGETS], "p", "q")
+	delegate(targets["q"], "p")
+	delegate(targets["p"], "x")
+	delegate(targets["x"], "y")
+	err = ValidateDelegationGraph(targets, 3)
+	assert.ErrorIs(t, err, ErrValue{"delegation chain exceeds max depth 3: targets -> q -> p -> x -> y"})
+
+	// Test a graph of stacked diamonds isn't walked once per path
+	targets = map[string]*Metadata[TargetsType]{TARGETS: Targets()}
+	delegate(targets[TARGETS], "left-0", "right-0")
+	for i := 0; i < 64; i++ {
+		for _, side := range []string{"left", "right"} {
+			name := fmt.Sprintf("%s-%d", side, i)
+			targets[name] = Targets()
+			delegate(targets[name], fmt.Sprintf("left-%d", i+1), fmt.Sprintf("right-%d", i+1))
+		}
+	}
+	assert.NoError(t, ValidateDelegationGraph(targets, 65))
+	err = ValidateDelegationGraph(targets, 64)
+	assert.ErrorContains(t, err, "delegation chain exceeds max depth 64")
+}
+
+func TestDelegationGraph(t *testing.T) {
+	targets := map[string]*Metadata[TargetsType]{
+		TARGETS:  Targets(),
+		"a":      Targets(),
+		"a-bins": Targets(),
+	}
+	targets[TARGETS].Signed.Delegations = &Delegations{
+		Keys: map[string]*Key{},
+		Roles: []DelegatedRole{
+			{Name: "a", KeyIDs: []string{}, Threshold: 1, Paths: []string{"a/*", "shared/*"}, Terminating: true},
+			{Name: "b", KeyIDs: []string{}, Threshold: 1, PathHashPrefixes: []string{"8f"}},
+		},
+	}
+	targets["a"].Signed.Delegations = &Delegations{
+		Keys: map[string]*Key{},
+		Roles: []DelegatedRole{
+			{Name: "a-bins", KeyIDs: []string{}, Threshold: 1, Paths: []string{"a/bins/*"}},
+		},
+	}
+	targets["a-bins"].Signed.Delegations = &Delegations{
+		Keys:          map[string]*Key{},
+		SuccinctRoles: &SuccinctRoles{KeyIDs: []string{}, Threshold: 1, BitLength: 8, NamePrefix: "bin"},
+	}
+
+	// Test the edges of a two-level delegation, "b" isn't loaded
+	graph := NewDelegationGraph(targets)
+	assert.Equal(t, []DelegationEdge{
+		{Parent: TARGETS, Child: "a", Paths: []string{"a/*", "shared/*"}, Terminating: true},
+		{Parent: "a", Child: "a-bins", Paths: []string{"a/bins/*"}},
+		{Parent: "a-bins", Child: "bin-*", Terminating: true},
+		{Parent: TARGETS, Child: "b", PathHashPrefixes: []string{"8f"}},
+	}, graph.Edges)
+
+	// Test the DOT output
+	assert.Equal(t, `digraph delegations {
+	"targets" -> "a" [label="paths: a/*, shared/*\nterminating"];
+	"a" -> "a-bins" [label="paths: a/bins/*"];
+	"a-bins" -> "bin-*" [label="terminating"];
+	"targets" -> "b" [label="path_hash_prefixes: 8f"];
+}
+`, graph.DOT())
+
+	// Test a graph without delegations
+	assert.Empty(t, NewDelegationGraph(map[string]*Metadata[TargetsType]{TARGETS: Targets()}).Edges)
+	assert.Equal(t, "digraph delegations {\n}\n", NewDelegationGraph(nil).DOT())
+}
+
+func TestKeyToPublicKeyEncodings(t *testing.T) {
+	ed25519Public, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	ecdsaPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	toPEM := func(k crypto.PublicKey) string {
+		pemKey, err := cryptoutils.MarshalPublicKeyToPEM(k)
+		assert.NoError(t, err)
+		return string(pemKey)
+	}
+	toHexDER := func(k crypto.PublicKey) string {
+		der, err := x509.MarshalPKIXPublicKey(k)
+		assert.NoError(t, err)
+		return hex.EncodeToString(der)
+	}
+
+	tests := []struct {
+		name     string
+		keyType  string
+		value    string
+		expected crypto.PublicKey
+	}{
+		{"ed25519 raw hex", KeyTypeEd25519, hex.EncodeToString(ed25519Public), ed25519Public},
+		{"ed25519 PEM", KeyTypeEd25519, toPEM(ed25519Public), ed25519Public},
+		{"ed25519 DER hex", KeyTypeEd25519, toHexDER(ed25519Public), ed25519Public},
+		{"ecdsa PEM", KeyTypeECDSA_SHA2_P256, toPEM(ecdsaPrivate.Public()), ecdsaPrivate.Public()},
+		{"ecdsa DER hex", KeyTypeECDSA_SHA2_P256_COMPAT, toHexDER(ecdsaPrivate.Public()), ecdsaPrivate.Public()},
+		{"rsa PEM", KeyTypeRSASSA_PSS_SHA256, toPEM(rsaPrivate.Public()), rsaPrivate.Public()},
+		{"rsa DER hex", KeyTypeRSASSA_PSS_SHA256, toHexDER(rsaPrivate.Public()), rsaPrivate.Public()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &Key{Type: tt.keyType, Value: KeyVal{PublicKey: tt.value}}
+			publicKey, err := key.ToPublicKey()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, publicKey)
+		})
+	}
+
+	// Test KeyFromPublicKey emits the conventional encoding per key type
+	key, err := KeyFromPublicKey(ed25519Public)
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(ed25519Public), key.Value.PublicKey)
+	key, err = KeyFromPublicKey(ecdsaPrivate.Public())
+	assert.NoError(t, err)
+	assert.Equal(t, toPEM(ecdsaPrivate.Public()), key.Value.PublicKey)
+	key, err = KeyFromPublicKey(rsaPrivate.Public())
+	assert.NoError(t, err)
+	assert.Equal(t, toPEM(rsaPrivate.Public()), key.Value.PublicKey)
+
+	// Test a raw ed25519 key verifies signatures
+	ed25519Public, ed25519Private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	key = &Key{Type: KeyTypeEd25519, Scheme: KeySchemeEd25519, Value: KeyVal{PublicKey: hex.EncodeToString(ed25519Public)}}
+	publicKey, err := key.ToPublicKey()
+	assert.NoError(t, err)
+	assert.Len(t, publicKey, ed25519.PublicKeySize)
+	payload := []byte("payload")
+	err = key.VerifySignature(Signature{KeyID: key.ID(), Signature: ed25519.Sign(ed25519Private, payload)}, payload)
+	assert.NoError(t, err)
+
+	// Test failure on raw ed25519 keys of the wrong length
+	for _, length := range []int{ed25519.PublicKeySize - 1, ed25519.PublicKeySize + 1} {
+		key = &Key{Type: KeyTypeEd25519, Value: KeyVal{PublicKey: hex.EncodeToString(make([]byte, length))}}
+		_, err = key.ToPublicKey()
+		assert.ErrorContains(t, err, fmt.Sprintf("invalid ed25519 public key: expected 32 raw bytes, got %d", length))
+	}
+
+	// Test failure on a value that is neither PEM nor hex
+	key = &Key{Type: KeyTypeEd25519, Value: KeyVal{PublicKey: "not-a-key"}}
+	_, err = key.ToPublicKey()
+	assert.ErrorContains(t, err, "public key value is neither PEM nor hex encoded")
+
+	// Test failure on a key value that doesn't match the key type
+	key = &Key{Type: KeyTypeRSASSA_PSS_SHA256, Value: KeyVal{PublicKey: toPEM(ecdsaPrivate.Public())}}
+	_, err = key.ToPublicKey()
+	assert.ErrorContains(t, err, "invalid rsa public key")
+}
+
+func TestKeyIDHashAlgorithms(t *testing.T) {
+	// Key IDs computed with securesystemslib-style canonical JSON and the
+	// first algorithm listed in keyid_hash_algorithms
+	keyFmt := `{"keytype": "ecdsa-sha2-nistp256", "scheme": "ecdsa-sha2-nistp256", "keyid_hash_algorithms": %s, "keyval": {"public": "-----BEGIN PUBLIC KEY-----\nMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEEXsz3SZXFb8jMV42j6pJlyjbjR8K\nN3Bwocexq6LMIb5qsWKOQvLN16NUefLc4HswOoumRsVVaajSpQS6fobkRw==\n-----END PUBLIC KEY-----\n"}}`
+	tests := []struct {
+		name       string
+		algorithms string
+		expectedID string
+	}{
+		{"sha256 first", `["sha256", "sha512"]`, "25a0eb450fd3ee2bd79218c963dce3f1cc6118badf251bf149f0bd07d5cabe99"},
+		{"sha512 first", `["sha512", "sha256"]`, "6a1d69d002a17d3eb2b059b314d2d35b6d3377ebd6f21027a99876353d2da1979bd3ebd378506005a15ab98ec9b972c2904ff30f457e7588a6567c8c6b2a40fd"},
+		{"unsupported algorithm skipped", `["md5", "sha512"]`, "1370755b7a8ea62ac8d6a9b680cbf372fb4f5bf93513aa9cf791f78edd5816e8f9710fb0fa2c333e80c329f8a4b0c5b13fa27a19df3484915d8e2748ea5e7845"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &Key{}
+			err := json.Unmarshal([]byte(fmt.Sprintf(keyFmt, tt.algorithms)), key)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedID, key.ID())
+		})
+	}
+
+	// Test keys without the field default to sha256
+	key, err := KeyFromPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	assert.NoError(t, err)
+	assert.Len(t, key.ID(), sha256.Size*2)
+}
+
+func TestKeyVerifySignature(t *testing.T) {
+	_, ed25519Private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	ecdsaPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	ecdsaP384Private, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	rsaPSSSigner, err := signature.LoadRSAPSSSigner(rsaPrivate, crypto.SHA256, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	assert.NoError(t, err)
+	rsaPKCS1v15Signer, err := signature.LoadRSAPKCS1v15Signer(rsaPrivate, crypto.SHA256)
+	assert.NoError(t, err)
+	loadSigner := func(privateKey crypto.Signer, hash crypto.Hash) signature.Signer {
+		signer, err := signature.LoadSigner(privateKey, hash)
+		assert.NoError(t, err)
+		return signer
+	}
+
+	payload := []byte("signed payload")
+	tests := []struct {
+		name       string
+		privateKey crypto.Signer
+		signer     signature.Signer
+		scheme     string
+	}{
+		{"ed25519", ed25519Private, loadSigner(ed25519Private, crypto.Hash(0)), KeySchemeEd25519},
+		{"ecdsa", ecdsaPrivate, loadSigner(ecdsaPrivate, crypto.SHA256), KeySchemeECDSA_SHA2_P256},
+		{"ecdsa p384", ecdsaP384Private, loadSigner(ecdsaP384Private, crypto.SHA384), KeySchemeECDSA_SHA2_P384},
+		{"rsa", rsaPrivate, rsaPSSSigner, KeySchemeRSASSA_PSS_SHA256},
+		{"rsa pkcs1v15", rsaPrivate, rsaPKCS1v15Signer, KeySchemeRSA_PKCS1v15_SHA256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sigBytes, err := tt.signer.SignMessage(bytes.NewReader(payload))
+			assert.NoError(t, err)
+			key, err := KeyFromPublicKey(tt.privateKey.Public())
+			assert.NoError(t, err)
+			key.Scheme = tt.scheme
+			sig := Signature{KeyID: key.ID(), Signature: sigBytes}
+
+			// Test a valid signature
+			assert.NoError(t, key.VerifySignature(sig, payload))
+
+			// Test a tampered payload
+			err = key.VerifySignature(sig, []byte("tampered payload"))
+			assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+
+			// Test a tampered signature
+			tampered := Signature{KeyID: sig.KeyID, Signature: append([]byte{}, sigBytes...)}
+			tampered.Signature[0] ^= 0xff
+			err = key.VerifySignature(tampered, payload)
+			assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+		})
+	}
+
+	// Test the declared scheme is enforced, an rsassa-pss-sha256 key doesn't
+	// verify PKCS #1 v1.5 signatures and a P-384 key isn't verified as P-256
+	rsaKey, err := KeyFromPublicKey(rsaPrivate.Public())
+	assert.NoError(t, err)
+	assert.Equal(t, KeySchemeRSASSA_PSS_SHA256, rsaKey.Scheme)
+	pkcs1v15Sig, err := rsaPKCS1v15Signer.SignMessage(bytes.NewReader(payload))
+	assert.NoError(t, err)
+	err = rsaKey.VerifySignature(Signature{KeyID: rsaKey.ID(), Signature: pkcs1v15Sig}, payload)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+	p384Key, err := KeyFromPublicKey(ecdsaP384Private.Public())
+	assert.NoError(t, err)
+	assert.Equal(t, KeySchemeECDSA_SHA2_P384, p384Key.Scheme)
+	p384Key.Scheme = KeySchemeECDSA_SHA2_P256
+	err = p384Key.VerifySignature(Signature{KeyID: p384Key.ID()}, payload)
+	assert.ErrorContains(t, err, "ecdsa public key curve P-384 doesn't match scheme ecdsa-sha2-nistp256")
+
+	// Test failure on an unknown scheme
+	rsaKey.Scheme = "rsassa-pss-sha512"
+	err = rsaKey.VerifySignature(Signature{KeyID: rsaKey.ID()}, payload)
+	assert.ErrorIs(t, err, ErrValue{Msg: "unsupported key scheme rsassa-pss-sha512"})
+
+	// Test failure on a key that cannot be loaded
+	key := &Key{Type: KeyTypeEd25519, Value: KeyVal{PublicKey: "not-a-key"}}
+	err = key.VerifySignature(Signature{}, payload)
+	assert.ErrorContains(t, err, "public key value is neither PEM nor hex encoded")
+	assert.NotErrorIs(t, err, ErrUnsignedMetadata{})
+}
+
+func TestVerifyDelegateMixedKeyTypes(t *testing.T) {
+	_, ed25519Private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	ecdsaPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ed25519Signer, err := signature.LoadSigner(ed25519Private, crypto.Hash(0))
+	assert.NoError(t, err)
+	ecdsaSigner, err := signature.LoadSigner(ecdsaPrivate, crypto.SHA256)
+	assert.NoError(t, err)
+	rsaPSSSigner, err := signature.LoadRSAPSSSigner(rsaPrivate, crypto.SHA256, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	assert.NoError(t, err)
+
+	root := Root(fixedExpire)
+	for _, privateKey := range []crypto.Signer{ed25519Private, ecdsaPrivate} {
+		key, err := KeyFromPublicKey(privateKey.Public())
+		assert.NoError(t, err)
+		assert.NoError(t, root.Signed.AddKey(key, ROOT))
+	}
+	root.Signed.Roles[ROOT].Threshold = 2
+
+	// Test a single signature doesn't meet the threshold
+	_, err = root.Sign(ed25519Signer)
+	assert.NoError(t, err)
+	err = root.VerifyDelegate(ROOT, root)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{Msg: "Verifying root failed, not enough signatures, got 1, want 2"})
+
+	// Test Ed25519 and ECDSA signatures both count towards the threshold
+	_, err = root.Sign(ecdsaSigner)
+	assert.NoError(t, err)
+	assert.NoError(t, root.VerifyDelegate(ROOT, root))
+
+	// Test an RSA-PSS key next to them, verified with its own scheme
+	rsaKey, err := KeyFromPublicKey(rsaPrivate.Public())
+	assert.NoError(t, err)
+	assert.Equal(t, KeySchemeRSASSA_PSS_SHA256, rsaKey.Scheme)
+	assert.NoError(t, root.Signed.AddKey(rsaKey, ROOT))
+	root.Signed.Roles[ROOT].Threshold = 3
+	root.ClearSignatures()
+	for _, signer := range []signature.Signer{ed25519Signer, ecdsaSigner, rsaPSSSigner} {
+		_, err = root.Sign(signer)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, root.VerifyDelegate(ROOT, root))
+	payload, err := root.SignedBytes()
+	assert.NoError(t, err)
+	for _, sig := range root.Signatures {
+		assert.NoError(t, root.Signed.Keys[sig.KeyID].VerifySignature(sig, payload))
+	}
+}
+
+func TestKeyEqualAndDedupeKeys(t *testing.T) {
+	ed25519Public, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	ecdsaPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherECDSAPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	// the same ECDSA public key encoded as PEM and as hex encoded DER
+	pemKey, err := KeyFromPublicKey(ecdsaPrivate.Public())
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(ecdsaPrivate.Public())
+	assert.NoError(t, err)
+	derKey := &Key{Type: KeyTypeECDSA_SHA2_P256, Scheme: KeySchemeECDSA_SHA2_P256, Value: KeyVal{PublicKey: hex.EncodeToString(der)}}
+	assert.NotEqual(t, pemKey.ID(), derKey.ID())
+	assert.True(t, pemKey.Equal(derKey))
+	assert.True(t, derKey.Equal(pemKey))
+
+	// the same Ed25519 public key encoded as raw hex and as PEM
+	hexKey, err := KeyFromPublicKey(ed25519Public)
+	assert.NoError(t, err)
+	ed25519PEM, err := cryptoutils.MarshalPublicKeyToPEM(ed25519Public)
+	assert.NoError(t, err)
+	ed25519PEMKey := &Key{Type: KeyTypeEd25519, Scheme: KeySchemeEd25519, Value: KeyVal{PublicKey: string(ed25519PEM)}}
+	assert.True(t, hexKey.Equal(ed25519PEMKey))
+
+	// Test keys with the same key ID
+	sameKey := &Key{Type: pemKey.Type, Scheme: pemKey.Scheme, Value: pemKey.Value}
+	assert.True(t, pemKey.Equal(sameKey))
+
+	// Test different keys
+	otherKey, err := KeyFromPublicKey(otherECDSAPrivate.Public())
+	assert.NoError(t, err)
+	assert.False(t, pemKey.Equal(otherKey))
+	assert.False(t, pemKey.Equal(hexKey))
+	assert.False(t, pemKey.Equal(nil))
+	// ... including the same public key with a different scheme
+	ed25519phKey := &Key{Type: KeyTypeEd25519, Scheme: KeySchemeEd25519ph, Value: hexKey.Value}
+	assert.False(t, hexKey.Equal(ed25519phKey))
+	// ... and keys that cannot be loaded
+	invalidKey := &Key{Type: KeyTypeEd25519, Scheme: KeySchemeEd25519, Value: KeyVal{PublicKey: "not-a-key"}}
+	assert.False(t, hexKey.Equal(invalidKey))
+
+	// Test deduplication keeps the first key of each
+	deduped := DedupeKeys([]*Key{pemKey, hexKey, derKey, otherKey, ed25519PEMKey, sameKey, ed25519phKey})
+	assert.Equal(t, []*Key{pemKey, hexKey, otherKey, ed25519phKey}, deduped)
+	assert.Empty(t, DedupeKeys(nil))
+}
+
+func TestKeyIDFromPEM(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		pem   string
+		keyID string
+	}{
+		{
+			name: "ed25519",
+			pem: `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAfvgS5LyUaPp+djScH/vWp3Jp+7oFCeY6qmGNnnQEX/o=
+-----END PUBLIC KEY-----
+`,
+			keyID: "cc895d1ef8ee8addb1e87618536fd674066ba1a312a06ccc2b734568c0fecd50",
+		},
+		{
+			name: "ecdsa",
+			pem: `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEkph/IRTXoqTnLLMC3woMw0hO+EWM
+IpWvmLpATAoNJB3QHNSH9ja03j/QcOKgfGcOFZn6qGuDuFZnKXaG3miN2w==
+-----END PUBLIC KEY-----
+`,
+			keyID: "df6ab7cc3bde212c26f616ad021ac049be8cdda46a9fe9f1e4a5b8358c015465",
+		},
+		{
+			name: "rsa",
+			pem: `-----BEGIN PUBLIC KEY-----
+MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA6lRTY2ocQeqWtAKcKZpz
+dDxEYQaNif7CZ1e20QhYUWBjNoFTbu+bhLEK6V7a00EvOztriRh0/w15zgQ5zORi
+2dPn2rQIlPYaDZ/GCIEKTQX5G1qOGC/Q5rZomVny20rFKPk2VVBjJwSGaxtXdQ1C
+8LCH7MEWd7RYHljfX5XihAXjt2/vgy1P9WoPJg1tz+nHslHxZOeGiVEsLmAm55OS
+y3oxRrwosOqkPRzfUFOGLFmFkI2X9lQDSxH2WgsNXiU/+E4urfe4Esq+KR7kxxzB
+yc7QliM35pmtXow7n9FXZzgjpdjJPxa+Z2aVgdTXQZcpJDT0u3Q65d4mIs8Viu0b
+QwIDAQAB
+-----END PUBLIC KEY-----
+`,
+			keyID: "b6b5cb2afda0bb7dacafaeff18a01937ae15025b830de672761627b76a7975e2",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			keyID, err := KeyIDFromPEM([]byte(test.pem))
+			assert.NoError(t, err)
+			assert.Equal(t, test.keyID, keyID)
+
+			name := filepath.Join(t.TempDir(), "key.pub")
+			assert.NoError(t, os.WriteFile(name, []byte(test.pem), 0644))
+			keyID, err = KeyIDFromFile(name)
+			assert.NoError(t, err)
+			assert.Equal(t, test.keyID, keyID)
+		})
+	}
+
+	// Test invalid input
+	_, err := KeyIDFromPEM([]byte("not a key"))
+	assert.Error(t, err)
+	_, err = KeyIDFromFile(filepath.Join(t.TempDir(), "missing.pub"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestEd25519ph(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	signer, err := LoadED25519phSigner(private)
+	assert.NoError(t, err)
+	key, err := KeyFromPublicKey(public)
+	assert.NoError(t, err)
+	key.Scheme = KeySchemeEd25519ph
+
+	// Test signing and verifying metadata with an Ed25519ph key
+	root := Root(fixedExpire)
+	err = root.Signed.AddKey(key, ROOT)
+	assert.NoError(t, err)
+	sig, err := root.Sign(signer)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID(), sig.KeyID)
+	err = root.VerifyDelegate(ROOT, root)
+	assert.NoError(t, err)
+
+	// Test the signature is a standard Ed25519ph signature over the payload
+	payload, err := encodeCanonical(root.Signed)
+	assert.NoError(t, err)
+	digest := sha512.Sum512(payload)
+	err = ed25519.VerifyWithOptions(public, digest[:], sig.Signature, &ed25519.Options{Hash: crypto.SHA512})
+	assert.NoError(t, err)
+
+	// Test pure Ed25519 and Ed25519ph signatures are not interchangeable
+	pureKey, err := KeyFromPublicKey(public)
+	assert.NoError(t, err)
+	err = pureKey.VerifySignature(*sig, payload)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+	pureSig := Signature{KeyID: key.ID(), Signature: ed25519.Sign(private, payload)}
+	err = key.VerifySignature(pureSig, payload)
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+
+	// Test failure on an invalid private key
+	_, err = LoadED25519phSigner(private[:10])
+	assert.ErrorContains(t, err, "invalid ed25519 private key")
+}
+
+func mustToBytes[T Roles](t *testing.T, meta *Metadata[T]) []byte {
+	data, err := meta.ToBytes(false)
+	assert.NoError(t, err)
+	return data
+}
@@ -14,9 +14,13 @@ package metadata
 import (
 	"crypto/ed25519"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -274,6 +278,249 @@ func TestIsDelegatedPath(t *testing.T) {
 	}
 }
 
+func TestNormalizeTargetPath(t *testing.T) {
+	for targetPath, expected := range map[string]string{
+		"file.txt":             "file.txt",
+		"/file.txt":            "file.txt",
+		"./file.txt":           "file.txt",
+		"dir/file.txt":         "dir/file.txt",
+		"/dir//./file.txt":     "dir/file.txt",
+		"dir/file.txt/":        "dir/file.txt",
+		"./././dir/./file.txt": "dir/file.txt",
+		"dir/../file.txt":      "dir/../file.txt",
+		"../file.txt":          "../file.txt",
+		"dir%2Ffile.txt":       "dir%2Ffile.txt",
+		"/dir/file%20name.txt": "dir/file%20name.txt",
+		".hidden/./file.txt":   ".hidden/file.txt",
+		"":                     "",
+		"/":                    "",
+	} {
+		assert.Equal(t, expected, NormalizeTargetPath(targetPath), targetPath)
+	}
+}
+
+func TestFindTarget(t *testing.T) {
+	targets := Targets(fixedExpire)
+	exact, absolute, dotted, canonical := TargetFile(), TargetFile(), TargetFile(), TargetFile()
+	targets.Signed.Targets["/abs.txt"] = absolute
+	targets.Signed.Targets["./dir/file.txt"] = dotted
+	targets.Signed.Targets["/dir/file.txt"] = exact
+	targets.Signed.Targets["other.txt"] = canonical
+
+	// Test exact matches are preferred
+	target, ok := targets.Signed.FindTarget("/dir/file.txt")
+	assert.True(t, ok)
+	assert.Same(t, exact, target)
+	target, ok = targets.Signed.FindTarget("./dir/file.txt")
+	assert.True(t, ok)
+	assert.Same(t, dotted, target)
+
+	// Test paths with the same normalized form match, the first in
+	// lexical order if several do
+	target, ok = targets.Signed.FindTarget("abs.txt")
+	assert.True(t, ok)
+	assert.Same(t, absolute, target)
+	target, ok = targets.Signed.FindTarget("dir//file.txt")
+	assert.True(t, ok)
+	assert.Same(t, dotted, target)
+	target, ok = targets.Signed.FindTarget("/other.txt")
+	assert.True(t, ok)
+	assert.Same(t, canonical, target)
+	_, ok = targets.Signed.FindTarget("dir/other.txt")
+	assert.False(t, ok)
+
+	// Test AddTarget lists targets under their normalized path
+	added := TargetFile()
+	added.Path = "/./new//file.txt"
+	targets.Signed.AddTarget(added)
+	assert.Equal(t, "new/file.txt", added.Path)
+	assert.Same(t, added, targets.Signed.Targets["new/file.txt"])
+	target, ok = targets.Signed.FindTarget("/new/file.txt")
+	assert.True(t, ok)
+	assert.Same(t, added, target)
+}
+
+func TestDelegatedRolePathsAndPathHashPrefixes(t *testing.T) {
+	// Test a role with paths
+	role := DelegatedRole{}
+	err := json.Unmarshal([]byte(`{"name": "role1", "keyids": [], "threshold": 1, "terminating": false, "paths": ["foo/*"]}`), &role)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo/*"}, role.Paths)
+
+	// Test a role with path_hash_prefixes
+	role = DelegatedRole{}
+	err = json.Unmarshal([]byte(`{"name": "role1", "keyids": [], "threshold": 1, "terminating": false, "path_hash_prefixes": ["8f"]}`), &role)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8f"}, role.PathHashPrefixes)
+
+	// Test a role with both paths and path_hash_prefixes
+	role = DelegatedRole{}
+	err = json.Unmarshal([]byte(`{"name": "role1", "keyids": [], "threshold": 1, "terminating": false, "paths": ["foo/*"], "path_hash_prefixes": ["8f"]}`), &role)
+	assert.ErrorIs(t, err, ErrValue{"failed to unmarshal delegated role role1: not allowed to have both \"paths\" and \"path_hash_prefixes\" present"})
+
+	// Test a role with neither paths nor path_hash_prefixes
+	role = DelegatedRole{}
+	err = json.Unmarshal([]byte(`{"name": "role1", "keyids": [], "threshold": 1, "terminating": false}`), &role)
+	assert.ErrorIs(t, err, ErrValue{"failed to unmarshal delegated role role1: one of \"paths\" or \"path_hash_prefixes\" must be present"})
+
+	// Test a role with a malformed glob pattern
+	role = DelegatedRole{}
+	err = json.Unmarshal([]byte(`{"name": "role1", "keyids": [], "threshold": 1, "terminating": false, "paths": ["foo/*", "foo/[a-"]}`), &role)
+	assert.ErrorIs(t, err, ErrInvalidDelegation{"delegated role role1 has malformed path pattern \"foo/[a-\": syntax error in pattern"})
+	assert.ErrorIs(t, err, ErrRepository{})
+
+	// Test that loading targets metadata with such a role fails
+	targets := Targets(fixedExpire)
+	targets.Signed.Delegations = &Delegations{
+		Keys:  map[string]*Key{},
+		Roles: []DelegatedRole{{Name: "role1", KeyIDs: []string{}, Threshold: 1}},
+	}
+	data, err := targets.ToBytes(false)
+	assert.NoError(t, err)
+	_, err = Targets().FromBytes(data)
+	assert.ErrorIs(t, err, ErrValue{"failed to unmarshal delegated role role1: one of \"paths\" or \"path_hash_prefixes\" must be present"})
+}
+
+func TestExpandDelegationCoverage(t *testing.T) {
+	candidatePaths := []string{
+		"README.md",
+		"apps/cli/v1.tar.gz",
+		"apps/cli/v2.tar.gz",
+		"apps/web/index.html",
+		"docs/index.html",
+	}
+	apps := &DelegatedRole{Name: "apps", Paths: []string{"apps/*/*"}}
+	cli := &DelegatedRole{Name: "cli", Paths: []string{"apps/cli/*"}}
+	html := &DelegatedRole{Name: "html", Paths: []string{"*.html", "*/*.html", "*/*/*.html"}}
+
+	assert.Equal(t, []string{"apps/cli/v1.tar.gz", "apps/cli/v2.tar.gz", "apps/web/index.html"}, ExpandDelegationCoverage(apps, candidatePaths))
+	assert.Equal(t, []string{"apps/cli/v1.tar.gz", "apps/cli/v2.tar.gz"}, ExpandDelegationCoverage(cli, candidatePaths))
+	assert.Equal(t, []string{"apps/web/index.html", "docs/index.html"}, ExpandDelegationCoverage(html, candidatePaths))
+
+	// overlapping delegations own the same paths
+	overlap := map[string][]string{}
+	for _, role := range []*DelegatedRole{apps, cli, html} {
+		for _, targetPath := range ExpandDelegationCoverage(role, candidatePaths) {
+			overlap[targetPath] = append(overlap[targetPath], role.Name)
+		}
+	}
+	assert.Equal(t, map[string][]string{
+		"apps/cli/v1.tar.gz":  {"apps", "cli"},
+		"apps/cli/v2.tar.gz":  {"apps", "cli"},
+		"apps/web/index.html": {"apps", "html"},
+		"docs/index.html":     {"html"},
+	}, overlap)
+
+	// hash bin delegations
+	bin := &DelegatedRole{Name: "bin", PathHashPrefixes: []string{"uk0", "000"}}
+	assert.Equal(t, []string{}, ExpandDelegationCoverage(bin, []string{"other"}))
+	assert.Equal(t, []string{"/delegated_role/foo.txt"}, ExpandDelegationCoverage(bin, []string{"/delegated_role/foo.txt", "other"}))
+
+	// no paths
+	assert.Empty(t, ExpandDelegationCoverage(&DelegatedRole{Name: "empty"}, candidatePaths))
+	assert.Nil(t, ExpandDelegationCoverage(nil, candidatePaths))
+}
+
+func TestValidateTargetCoverage(t *testing.T) {
+	targets := Targets(fixedExpire)
+	for _, targetPath := range []string{"apps/cli/v1.tar.gz", "apps/web/index.html", "docs/index.html", "README.md"} {
+		targets.Signed.Targets[targetPath] = TargetFile()
+	}
+	apps := &DelegatedRole{Name: "apps", Paths: []string{"apps/*/*"}}
+	assert.Equal(t, []string{"README.md", "docs/index.html"}, ValidateTargetCoverage(apps, &targets.Signed))
+	all := &DelegatedRole{Name: "all", Paths: []string{"*", "*/*", "*/*/*"}}
+	assert.Empty(t, ValidateTargetCoverage(all, &targets.Signed))
+
+	// hash bin delegations
+	bin := &DelegatedRole{Name: "bin", PathHashPrefixes: []string{"uk0", "000"}}
+	targets.Signed.Targets = map[string]*TargetFiles{"/delegated_role/foo.txt": TargetFile(), "other": TargetFile()}
+	assert.Equal(t, []string{"other"}, ValidateTargetCoverage(bin, &targets.Signed))
+
+	// no paths or no role cover nothing
+	assert.Equal(t, []string{"/delegated_role/foo.txt", "other"}, ValidateTargetCoverage(&DelegatedRole{Name: "empty"}, &targets.Signed))
+	assert.Equal(t, []string{"/delegated_role/foo.txt", "other"}, ValidateTargetCoverage(nil, &targets.Signed))
+	assert.Empty(t, ValidateTargetCoverage(apps, nil))
+}
+
+func TestDelegatedRoleNamesUnique(t *testing.T) {
+	newTargets := func(names ...string) []byte {
+		targets := Targets(fixedExpire)
+		targets.Signed.Delegations = &Delegations{
+			Keys:  map[string]*Key{},
+			Roles: []DelegatedRole{},
+		}
+		for _, name := range names {
+			targets.Signed.Delegations.Roles = append(targets.Signed.Delegations.Roles, DelegatedRole{
+				Name:      name,
+				KeyIDs:    []string{},
+				Threshold: 1,
+				Paths:     []string{"*"},
+			})
+		}
+		data, err := targets.ToBytes(false)
+		assert.NoError(t, err)
+		return data
+	}
+
+	// Test unique delegated role names
+	_, err := Targets().FromBytes(newTargets("role1", "role2"))
+	assert.NoError(t, err)
+
+	// Test duplicate delegated role names
+	_, err = Targets().FromBytes(newTargets("role1", "role2", "role1"))
+	assert.ErrorIs(t, err, ErrValue{"failed to unmarshal delegations: multiple delegated roles named role1"})
+
+	// Test top-level role names used as delegated role names
+	for _, name := range TOP_LEVEL_ROLE_NAMES {
+		_, err = Targets().FromBytes(newTargets("role1", name))
+		assert.ErrorIs(t, err, ErrValue{fmt.Sprintf("failed to unmarshal delegations: delegated role name %s is reserved for a top-level role", name)})
+	}
+}
+
+func TestValidateDelegations(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	key, err := KeyFromPublicKey(publicKey)
+	assert.NoError(t, err)
+	newTargets := func(keys map[string]*Key, keyIDs ...string) *Metadata[TargetsType] {
+		targets := Targets(fixedExpire)
+		targets.Signed.Delegations = &Delegations{
+			Keys: keys,
+			Roles: []DelegatedRole{{
+				Name:      "role1",
+				KeyIDs:    keyIDs,
+				Threshold: 1,
+				Paths:     []string{"*"},
+			}},
+		}
+		return targets
+	}
+
+	// Test consistent delegations
+	targets := newTargets(map[string]*Key{key.ID(): key}, key.ID())
+	assert.NoError(t, targets.Signed.ValidateDelegations())
+	data, err := targets.ToBytes(false)
+	assert.NoError(t, err)
+	_, err = Targets().FromBytes(data)
+	assert.NoError(t, err)
+
+	// Test delegated role referencing a missing key
+	targets = newTargets(map[string]*Key{key.ID(): key}, key.ID(), "missing")
+	assert.ErrorIs(t, targets.Signed.ValidateDelegations(), ErrValue{"delegated key IDs missing from delegations keys: missing"})
+	data, err = targets.ToBytes(false)
+	assert.NoError(t, err)
+	_, err = Targets().FromBytes(data)
+	assert.ErrorIs(t, err, ErrValue{"delegated key IDs missing from delegations keys: missing"})
+
+	// Test orphan key in the delegations key store
+	targets = newTargets(map[string]*Key{key.ID(): key})
+	assert.ErrorIs(t, targets.Signed.ValidateDelegations(), ErrValue{fmt.Sprintf("delegations keys not used by any delegated role: %s", key.ID())})
+	data, err = targets.ToBytes(false)
+	assert.NoError(t, err)
+	_, err = Targets().FromBytes(data)
+	assert.ErrorIs(t, err, ErrValue{fmt.Sprintf("delegations keys not used by any delegated role: %s", key.ID())})
+}
+
 func TestClearSignatures(t *testing.T) {
 	meta := Root()
 	// verify signatures is empty
@@ -353,6 +600,21 @@ func TestIsExpiredTargets(t *testing.T) {
 	assert.False(t, meta.Signed.IsExpired(time.Now().UTC()))
 }
 
+// assertGenericExpiry checks the generic expiry accessors of meta
+func assertGenericExpiry[T Roles](t *testing.T, meta *Metadata[T], expire time.Time) {
+	assert.Equal(t, expire, meta.Expires())
+	assert.False(t, meta.IsExpired(expire.Add(-time.Hour)))
+	assert.True(t, meta.IsExpired(expire.Add(time.Hour)))
+}
+
+func TestGenericExpires(t *testing.T) {
+	expire := time.Now().AddDate(0, 0, 2).UTC()
+	assertGenericExpiry(t, Root(expire), expire)
+	assertGenericExpiry(t, Snapshot(expire), expire)
+	assertGenericExpiry(t, Timestamp(expire), expire)
+	assertGenericExpiry(t, Targets(expire), expire)
+}
+
 func TestUnrecognizedFieldRolesSigned(t *testing.T) {
 	// unrecognized field to test
 	// added to the Signed portion of each role type
@@ -461,6 +723,153 @@ func TestFromBytes(t *testing.T) {
 	assert.Equal(t, HexBytes(h), root.Signatures[0].Signature)
 }
 
+func TestFromBytesBOMAndWhitespace(t *testing.T) {
+	expected, err := Root().FromBytes(testRootBytes)
+	assert.NoError(t, err)
+	expectedBytes, err := expected.ToBytes(false)
+	assert.NoError(t, err)
+
+	// Test BOM and whitespace prefixed metadata
+	for _, prefix := range []string{"\xef\xbb\xbf", " \n\t\r", "\xef\xbb\xbf \n"} {
+		root, err := Root().FromBytes(append([]byte(prefix), testRootBytes...))
+		assert.NoError(t, err)
+		rootBytes, err := root.ToBytes(false)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedBytes, rootBytes)
+		assert.Equal(t, ROOT, root.Signed.Type)
+	}
+
+	// Test the type is still checked
+	_, err = Targets().FromBytes(append([]byte("\xef\xbb\xbf"), testRootBytes...))
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type targets, got - root"})
+
+	// Test a BOM anywhere else is not valid JSON
+	_, err = Root().FromBytes(append([]byte(" \xef\xbb\xbf"), testRootBytes...))
+	assert.Error(t, err)
+
+	// Test a missing or malformed type doesn't panic
+	_, err = Root().FromBytes([]byte(` {"signatures":[]}`))
+	assert.ErrorIs(t, err, ErrMetadataType{"failed to read metadata type: missing signed._type"})
+	_, err = Root().FromBytes([]byte(` {"signed":{"version":1}}`))
+	assert.ErrorIs(t, err, ErrMetadataType{"failed to read metadata type: missing signed._type"})
+	_, err = Root().FromBytes([]byte(` {"signed":{"_type":1}}`))
+	assert.Error(t, err)
+	_, err = Root().FromBytes([]byte(`{"signed":[]}`))
+	assert.Error(t, err)
+}
+
+func TestLoadGeneric(t *testing.T) {
+	// Test a made-up role type, unknown to this package
+	mirrorsBytes := []byte(`{"signatures":[{"keyid":"abc","sig":"0123"}],"signed":{"_type":"mirrors","expires":"2030-08-15T14:30:45Z","mirrors":[{"urlbase":"https://mirror.example.com"}],"spec_version":"1.0.31","version":3}}`)
+	_, err := Root().FromBytes(mirrorsBytes)
+	assert.ErrorIs(t, err, ErrMetadataType{"expected metadata type root, got - mirrors"})
+	meta, err := LoadGeneric(append([]byte("\xef\xbb\xbf"), mirrorsBytes...))
+	assert.NoError(t, err)
+	assert.Equal(t, "mirrors", meta.Type)
+	assert.JSONEq(t, `{"_type":"mirrors","expires":"2030-08-15T14:30:45Z","mirrors":[{"urlbase":"https://mirror.example.com"}],"spec_version":"1.0.31","version":3}`, string(meta.Signed))
+	assert.Len(t, meta.Signatures, 1)
+	assert.Equal(t, "abc", meta.Signatures[0].KeyID)
+	assert.Equal(t, HexBytes{0x01, 0x23}, meta.Signatures[0].Signature)
+
+	// Test known types load as well
+	meta, err = LoadGeneric(testRootBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, ROOT, meta.Type)
+	root, err := Root().FromBytes(testRootBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, root.Signatures, meta.Signatures)
+
+	// Test the envelope is still checked
+	_, err = LoadGeneric([]byte(`{"signatures":[]}`))
+	assert.ErrorIs(t, err, ErrValue{"failed to read metadata type: missing signed._type"})
+	_, err = LoadGeneric([]byte(`{"signed":{"version":1},"signatures":[]}`))
+	assert.ErrorIs(t, err, ErrValue{"failed to read metadata type: missing signed._type"})
+	_, err = LoadGeneric([]byte(`{"signed":{"_type":1},"signatures":[]}`))
+	assert.Error(t, err)
+	_, err = LoadGeneric([]byte(`{"signed":{"_type":"mirrors"},"signatures":[{"keyid":"abc","sig":"01"},{"keyid":"abc","sig":"02"}]}`))
+	assert.ErrorIs(t, err, ErrValue{"multiple signatures found for key ID abc"})
+}
+
+func TestLargeNumbers(t *testing.T) {
+	// 2^53 + 1 can't be represented by a float64
+	const large = "9007199254740993"
+
+	// Test a length near 2^53
+	targetFile := &TargetFiles{}
+	err := targetFile.UnmarshalJSON([]byte(`{"length":` + large + `,"hashes":{},"custom":{"size":` + large + `}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9007199254740993), targetFile.Length)
+	data, err := json.Marshal(targetFile)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"length":`+large+`,"hashes":{},"custom":{"size":`+large+`}}`, string(data))
+	assert.Contains(t, string(data), large)
+
+	// Test large numbers in unrecognized fields survive a round trip, so
+	// signatures over them still verify
+	rootBytes := strings.Replace(string(testRootBytes), `"signed":{`, `"signed":{"max_length":`+large+`,`, 1)
+	root, err := Root().FromBytes([]byte(rootBytes))
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number(large), root.Signed.UnrecognizedFields["max_length"])
+	signedBytes, err := root.SignedBytes()
+	assert.NoError(t, err)
+	assert.Contains(t, string(signedBytes), `"max_length":`+large)
+	data, err = root.UnsafeToBytes(false)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"max_length":`+large)
+}
+
+func TestFromBytesExpiresFormat(t *testing.T) {
+	expires := "\"expires\":\"2030-08-15T14:30:45.0000001Z\""
+	// Test UTC timestamps with the "Z" suffix are accepted
+	for _, valid := range []string{"2030-08-15T14:30:45Z", "2030-08-15T14:30:45.5Z"} {
+		data := strings.Replace(string(testRootBytes), expires, fmt.Sprintf("\"expires\":%q", valid), 1)
+		_, err := Root().FromBytes([]byte(data))
+		assert.NoError(t, err)
+	}
+
+	// Test a timestamp with a timezone offset is rejected
+	data := strings.Replace(string(testRootBytes), expires, "\"expires\":\"2030-08-15T14:30:45+02:00\"", 1)
+	_, err := Root().FromBytes([]byte(data))
+	assert.ErrorIs(t, err, ErrInvalidExpiry{Msg: "expires \"2030-08-15T14:30:45+02:00\" must be in UTC"})
+	assert.ErrorIs(t, err, ErrRepository{})
+
+	// Test a malformed timestamp is rejected
+	data = strings.Replace(string(testRootBytes), expires, "\"expires\":\"15/08/2030 14:30\"", 1)
+	_, err = Root().FromBytes([]byte(data))
+	assert.ErrorIs(t, err, ErrInvalidExpiry{Msg: "expires \"15/08/2030 14:30\" is not a valid RFC3339 timestamp"})
+
+	// Test a non-string timestamp is rejected
+	data = strings.Replace(string(testRootBytes), expires, "\"expires\":1", 1)
+	_, err = Root().FromBytes([]byte(data))
+	assert.ErrorIs(t, err, ErrInvalidExpiry{})
+
+	// Test the check applies to all role types
+	snapshot := Snapshot(fixedExpire)
+	snapshot.Signed.UnrecognizedFields = map[string]any{}
+	data2, err := snapshot.ToBytes(false)
+	assert.NoError(t, err)
+	data = strings.Replace(string(data2), "2030-08-15T14:30:45.0000001Z", "2030-08-15T14:30:45-05:00", 1)
+	_, err = Snapshot().FromBytes([]byte(data))
+	assert.ErrorIs(t, err, ErrInvalidExpiry{})
+
+	// Test a local time expiry is serialized in UTC and loads again
+	localExpire := fixedExpire.In(time.FixedZone("UTC+2", 2*60*60))
+	rootData, err := Root(localExpire).ToBytes(false)
+	assert.NoError(t, err)
+	timestampData, err := Timestamp(localExpire).ToBytes(false)
+	assert.NoError(t, err)
+	snapshotData, err := Snapshot(localExpire).ToBytes(false)
+	assert.NoError(t, err)
+	targetsData, err := Targets(localExpire).ToBytes(false)
+	assert.NoError(t, err)
+	for _, data := range [][]byte{rootData, timestampData, snapshotData, targetsData} {
+		assert.Contains(t, string(data), expires)
+	}
+	loaded, err := Root().FromBytes(rootData)
+	assert.NoError(t, err)
+	assert.True(t, loaded.Signed.Expires.Equal(localExpire))
+}
+
 func TestToByte(t *testing.T) {
 	rootBytesExpireStr := "2030-08-15T14:30:45.0000001Z"
 	rootBytesExpire, err := time.Parse(time.RFC3339, rootBytesExpireStr)
@@ -646,6 +1055,136 @@ func TestVerifyLengthHashesTargetFiles(t *testing.T) {
 	targetFiles.Hashes = map[string]HexBytes{"sha256": data}
 	err = targetFiles.VerifyLengthHashes(data)
 	assert.Error(t, err, "length/hash verification error: hash verification failed - mismatch for algorithm sha256")
+
+	// Test only the selected algorithms are verified
+	h64 := sha512.Sum512(data)
+	targetFiles.Hashes["sha512"] = h64[:]
+	err = targetFiles.VerifyLengthHashes(data, "sha512")
+	assert.NoError(t, err)
+	err = targetFiles.VerifyLengthHashes(data, "sha256")
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+	err = targetFiles.VerifyLengthHashes(data, "sha384")
+	assert.ErrorIs(t, err, ErrValue{Msg: "target file has no sha384 hash to verify"})
+}
+
+func TestVerifyTarget(t *testing.T) {
+	data := []byte("some data")
+	sha256Digest := sha256.Sum256(data)
+	sha512Digest := sha512.Sum512(data)
+	hashes := Hashes{"sha256": sha256Digest[:], "sha512": sha512Digest[:]}
+
+	// Test matching length and hashes
+	assert.NoError(t, VerifyTarget(data, int64(len(data)), hashes))
+	assert.NoError(t, VerifyTarget(data, int64(len(data)), Hashes{"sha256": sha256Digest[:]}))
+
+	// Test mismatching length
+	err := VerifyTarget(data, 4, hashes)
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{"length verification failed - expected 4, got 9"})
+
+	// Test mismatching hashes
+	err = VerifyTarget([]byte("other data"), int64(len(data)), Hashes{"sha256": sha256Digest[:]})
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{"hash verification failed - mismatch for algorithm sha256"})
+	err = VerifyTarget(data, int64(len(data)), Hashes{"md5": sha256Digest[:]})
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{"hash verification failed - unknown hashing algorithm - md5"})
+
+	// Test no hashes
+	err = VerifyTarget(data, int64(len(data)), Hashes{})
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{"hash verification failed - no hashes to verify against"})
+}
+
+func TestVerifyTargetAgainstTargets(t *testing.T) {
+	data := []byte("some data")
+	targets := Targets(fixedExpire)
+	targetFile, err := TargetFile().FromBytes("file1.txt", data)
+	assert.NoError(t, err)
+	targets.Signed.Targets["file1.txt"] = targetFile
+	targets.Signed.Delegations = &Delegations{
+		Keys: map[string]*Key{},
+		Roles: []DelegatedRole{
+			{Name: "docs", Threshold: 1, KeyIDs: []string{}, Paths: []string{"docs/*"}},
+			{Name: "all", Threshold: 1, KeyIDs: []string{}, Paths: []string{"*/*"}},
+		},
+	}
+
+	// Test a present target
+	assert.NoError(t, VerifyTargetAgainstTargets(data, "file1.txt", targets))
+	err = VerifyTargetAgainstTargets([]byte("other data"), "file1.txt", targets)
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{})
+
+	// Test an absent target
+	err = VerifyTargetAgainstTargets(data, "file2.txt", targets)
+	assert.ErrorIs(t, err, ErrValue{"target file2.txt not found"})
+
+	// Test a target only delegated roles can provide
+	err = VerifyTargetAgainstTargets(data, "docs/file1.txt", targets)
+	assert.ErrorIs(t, err, ErrValue{"target docs/file1.txt is delegated, supply the delegated metadata of all, docs to verify it"})
+
+	err = VerifyTargetAgainstTargets(data, "file1.txt", nil)
+	assert.ErrorIs(t, err, ErrValue{"targets metadata is nil"})
+}
+
+func TestTargetFilesOmitEmptyOptionalFields(t *testing.T) {
+	digest := sha256.Sum256([]byte("some data"))
+	hashes := fmt.Sprintf(`"hashes":{"sha256":"%s"}`, hex.EncodeToString(digest[:]))
+	for _, targetJSON := range []string{
+		fmt.Sprintf(`{%s}`, hashes),
+		fmt.Sprintf(`{%s,"length":9}`, hashes),
+		fmt.Sprintf(`{"custom":{"test":true},%s,"length":0}`, hashes),
+		fmt.Sprintf(`{"custom":null,%s,"length":9}`, hashes),
+	} {
+		targetFile := &TargetFiles{}
+		err := json.Unmarshal([]byte(targetJSON), targetFile)
+		assert.NoError(t, err)
+
+		// Test the round trip adds no empty optional keys
+		targetBytes, err := json.Marshal(targetFile)
+		assert.NoError(t, err)
+		assert.JSONEq(t, targetJSON, string(targetBytes))
+		if !strings.Contains(targetJSON, "custom") {
+			assert.NotContains(t, string(targetBytes), "custom")
+		}
+		if !strings.Contains(targetJSON, "length") {
+			assert.NotContains(t, string(targetBytes), "length")
+		}
+
+		// Test the canonical bytes match the original ones
+		canonical, err := encodeCanonical(targetFile)
+		assert.NoError(t, err)
+		assert.Equal(t, targetJSON, string(canonical))
+	}
+}
+
+func TestTargetFilesOptionalLength(t *testing.T) {
+	data := []byte("some data")
+	digest := sha256.Sum256(data)
+	targetJSON := fmt.Sprintf(`{"hashes":{"sha256":"%s"}}`, hex.EncodeToString(digest[:]))
+
+	// Test a target with hashes but no committed length
+	targetFile := &TargetFiles{}
+	err := json.Unmarshal([]byte(targetJSON), targetFile)
+	assert.NoError(t, err)
+	assert.False(t, targetFile.HasLength())
+	assert.NoError(t, targetFile.VerifyLengthHashes(data))
+	err = targetFile.VerifyLengthHashes([]byte("other data"))
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{"hash verification failed - mismatch for algorithm sha256"})
+	targetBytes, err := json.Marshal(targetFile)
+	assert.NoError(t, err)
+	assert.JSONEq(t, targetJSON, string(targetBytes))
+
+	// Test an explicit zero length is still verified
+	targetFile = &TargetFiles{}
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{"length":0,"hashes":{"sha256":"%s"}}`, hex.EncodeToString(digest[:]))), targetFile)
+	assert.NoError(t, err)
+	assert.True(t, targetFile.HasLength())
+	err = targetFile.VerifyLengthHashes(data)
+	assert.ErrorIs(t, err, ErrLengthOrHashMismatch{"length verification failed - expected 0, got 9"})
+
+	// Test clearing and committing to a length
+	targetFile.ClearLength()
+	assert.NoError(t, targetFile.VerifyLengthHashes(data))
+	targetFile.SetLength(int64(len(data)))
+	assert.True(t, targetFile.HasLength())
+	assert.NoError(t, targetFile.VerifyLengthHashes(data))
 }
 
 func TestVerifyLengthHashesMetaFiles(t *testing.T) {
@@ -670,3 +1209,113 @@ func TestVerifyLengthHashesMetaFiles(t *testing.T) {
 	err = metaFile.VerifyLengthHashes(incorrectData)
 	assert.Error(t, err, "length/hash verification error: length verification failed - expected 0, got 9")
 }
+
+func TestHashesLength(t *testing.T) {
+	sha256Digest := sha256.Sum256([]byte("some data"))
+	sha256Hex := hex.EncodeToString(sha256Digest[:])
+	sha512Hex := strings.Repeat("ab", 64)
+	for _, test := range []struct {
+		name    string
+		json    string
+		wantErr error
+	}{
+		{
+			name: "valid sha256",
+			json: fmt.Sprintf(`{"length":9,"hashes":{"sha256":"%s"}}`, sha256Hex),
+		},
+		{
+			name: "valid sha256 and sha512",
+			json: fmt.Sprintf(`{"length":9,"hashes":{"sha256":"%s","sha512":"%s"}}`, sha256Hex, sha512Hex),
+		},
+		{
+			name: "unknown algorithm",
+			json: `{"length":9,"hashes":{"md5":"abcd"}}`,
+		},
+		{
+			name:    "short sha256",
+			json:    fmt.Sprintf(`{"length":9,"hashes":{"sha256":"%s"}}`, sha256Hex[:32]),
+			wantErr: ErrValue{Msg: "failed to unmarshal hashes: sha256 hash must be 32 bytes long, got 16"},
+		},
+		{
+			name:    "sha256 digest in sha512",
+			json:    fmt.Sprintf(`{"length":9,"hashes":{"sha512":"%s"}}`, sha256Hex),
+			wantErr: ErrValue{Msg: "failed to unmarshal hashes: sha512 hash must be 64 bytes long, got 32"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			targetFile := &TargetFiles{}
+			err := json.Unmarshal([]byte(test.json), targetFile)
+			metaFile := &MetaFiles{}
+			metaErr := json.Unmarshal([]byte(test.json), metaFile)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				assert.ErrorIs(t, metaErr, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, metaErr)
+		})
+	}
+
+	// Test the check applies when loading metadata
+	targets := Targets(fixedExpire)
+	targets.Signed.Targets["file.txt"] = &TargetFiles{Length: 9, Hashes: Hashes{"sha256": sha256Digest[:16]}}
+	data, err := targets.ToBytes(false)
+	assert.NoError(t, err)
+	_, err = Targets().FromBytes(data)
+	assert.ErrorIs(t, err, ErrValue{Msg: "failed to unmarshal hashes: sha256 hash must be 32 bytes long, got 16"})
+}
+
+func TestMetaFilesLengthPresence(t *testing.T) {
+	data := []byte("some data")
+	for _, test := range []struct {
+		name      string
+		json      string
+		hasLength bool
+		verifyErr error
+	}{
+		{
+			name: "absent length",
+			json: `{"version":1}`,
+		},
+		{
+			name:      "zero length",
+			json:      `{"length":0,"version":1}`,
+			hasLength: true,
+			verifyErr: ErrLengthOrHashMismatch{Msg: "length verification failed - expected 0, got 9"},
+		},
+		{
+			name:      "positive length",
+			json:      `{"length":9,"version":1}`,
+			hasLength: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			metaFile := &MetaFiles{}
+			err := json.Unmarshal([]byte(test.json), metaFile)
+			assert.NoError(t, err)
+			assert.Equal(t, test.hasLength, metaFile.HasLength())
+			err = metaFile.VerifyLengthHashes(data)
+			if test.verifyErr != nil {
+				assert.ErrorIs(t, err, test.verifyErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			// an explicit length survives a round trip
+			encoded, err := json.Marshal(metaFile)
+			assert.NoError(t, err)
+			assert.JSONEq(t, test.json, string(encoded))
+		})
+	}
+
+	// Test committing to and clearing a zero length
+	metaFile := MetaFile(1)
+	assert.False(t, metaFile.HasLength())
+	metaFile.SetLength(0)
+	assert.True(t, metaFile.HasLength())
+	assert.NoError(t, metaFile.VerifyLengthHashes([]byte{}))
+	assert.ErrorIs(t, metaFile.VerifyLengthHashes(data), ErrLengthOrHashMismatch{Msg: "length verification failed - expected 0, got 9"})
+	metaFile.ClearLength()
+	assert.False(t, metaFile.HasLength())
+	assert.NoError(t, metaFile.VerifyLengthHashes(data))
+}
@@ -12,6 +12,13 @@
 package repository
 
 import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/signature"
+	"golang.org/x/exp/slices"
+
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
 )
 
@@ -69,3 +76,200 @@ func (r *repositoryType) Targets(name string) *metadata.Metadata[metadata.Target
 func (r *repositoryType) SetTargets(name string, meta *metadata.Metadata[metadata.TargetsType]) {
 	r.targets[name] = meta
 }
+
+// ValidateDelegations checks that the delegation graph of the targets
+// metadata in the repository has no cycles and is at most maxDepth deep
+func (r *repositoryType) ValidateDelegations(maxDepth int) error {
+	return metadata.ValidateDelegationGraph(r.targets, maxDepth)
+}
+
+// ExpiryInversion describes a pair of top-level roles whose expiry dates are
+// in the wrong relative order: Role should not expire after LaterRole
+type ExpiryInversion struct {
+	Role             string
+	Expires          time.Time
+	LaterRole        string
+	LaterRoleExpires time.Time
+}
+
+// CheckExpiryOrder checks that the top-level roles in the repository expire
+// in the usual order, timestamp first, then snapshot, then targets and root
+// last, and returns every inverted pair. Inversions are not invalid, but they
+// force re-signing roles earlier than needed, so each one is logged as a
+// warning. Roles missing from the repository are not checked
+func (r *repositoryType) CheckExpiryOrder() []ExpiryInversion {
+	log := metadata.GetLogger()
+	type roleExpiry struct {
+		role    string
+		expires time.Time
+	}
+	// expected order, soonest first
+	ordered := []roleExpiry{}
+	if r.timestamp != nil {
+		ordered = append(ordered, roleExpiry{metadata.TIMESTAMP, r.timestamp.Signed.Expires})
+	}
+	if r.snapshot != nil {
+		ordered = append(ordered, roleExpiry{metadata.SNAPSHOT, r.snapshot.Signed.Expires})
+	}
+	if targets := r.targets[metadata.TARGETS]; targets != nil {
+		ordered = append(ordered, roleExpiry{metadata.TARGETS, targets.Signed.Expires})
+	}
+	if r.root != nil {
+		ordered = append(ordered, roleExpiry{metadata.ROOT, r.root.Signed.Expires})
+	}
+	inversions := []ExpiryInversion{}
+	for i, earlier := range ordered {
+		for _, later := range ordered[i+1:] {
+			if !earlier.expires.After(later.expires) {
+				continue
+			}
+			log.Info("Role expires after a role expected to outlive it",
+				"role", earlier.role, "expires", earlier.expires,
+				"later-role", later.role, "later-role-expires", later.expires)
+			inversions = append(inversions, ExpiryInversion{
+				Role:             earlier.role,
+				Expires:          earlier.expires,
+				LaterRole:        later.role,
+				LaterRoleExpires: later.expires,
+			})
+		}
+	}
+	return inversions
+}
+
+// BumpAll bumps the version of each top-level role in durations, sets its
+// expiry to now plus the role's duration and re-signs it with all of the
+// role's signers. Snapshot is also bumped, keeping its expiry, when targets
+// is, and so is timestamp when snapshot is, so that their meta reflect the
+// new versions, lengths and hashes, as serialized by ToBytes(false). Either
+// every role is updated or, on error, the repository is left untouched
+func (r *repositoryType) BumpAll(durations map[string]time.Duration, signers map[string][]signature.Signer) error {
+	for role := range durations {
+		if !slices.Contains(metadata.TOP_LEVEL_ROLE_NAMES[:], role) {
+			return metadata.ErrValue{Msg: fmt.Sprintf("cannot bump non top-level role %s", role)}
+		}
+	}
+	if r.root == nil || r.snapshot == nil || r.timestamp == nil || r.targets[metadata.TARGETS] == nil {
+		return metadata.ErrValue{Msg: "cannot bump a repository missing top-level metadata"}
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	// work on copies so the repository is only updated on success
+	root, err := copyMetadata(r.root)
+	if err != nil {
+		return err
+	}
+	targets, err := copyMetadata(r.targets[metadata.TARGETS])
+	if err != nil {
+		return err
+	}
+	snapshot, err := copyMetadata(r.snapshot)
+	if err != nil {
+		return err
+	}
+	timestamp, err := copyMetadata(r.timestamp)
+	if err != nil {
+		return err
+	}
+
+	if duration, ok := durations[metadata.ROOT]; ok {
+		root.Signed.Version++
+		root.Signed.Expires = now.Add(duration)
+		if _, err := resignMetadata(root, metadata.ROOT, signers); err != nil {
+			return err
+		}
+	}
+	bumpSnapshot := false
+	if duration, ok := durations[metadata.TARGETS]; ok {
+		targets.Signed.Version++
+		targets.Signed.Expires = now.Add(duration)
+		data, err := resignMetadata(targets, metadata.TARGETS, signers)
+		if err != nil {
+			return err
+		}
+		if err := updateMetaFile(snapshot.Signed.Meta, fmt.Sprintf("%s.json", metadata.TARGETS), targets.Signed.Version, data); err != nil {
+			return err
+		}
+		bumpSnapshot = true
+	}
+	bumpTimestamp := false
+	if duration, ok := durations[metadata.SNAPSHOT]; ok || bumpSnapshot {
+		snapshot.Signed.Version++
+		if ok {
+			snapshot.Signed.Expires = now.Add(duration)
+		}
+		data, err := resignMetadata(snapshot, metadata.SNAPSHOT, signers)
+		if err != nil {
+			return err
+		}
+		if err := updateMetaFile(timestamp.Signed.Meta, fmt.Sprintf("%s.json", metadata.SNAPSHOT), snapshot.Signed.Version, data); err != nil {
+			return err
+		}
+		bumpTimestamp = true
+	}
+	if duration, ok := durations[metadata.TIMESTAMP]; ok || bumpTimestamp {
+		timestamp.Signed.Version++
+		if ok {
+			timestamp.Signed.Expires = now.Add(duration)
+		}
+		if _, err := resignMetadata(timestamp, metadata.TIMESTAMP, signers); err != nil {
+			return err
+		}
+	}
+
+	r.root = root
+	r.targets[metadata.TARGETS] = targets
+	r.snapshot = snapshot
+	r.timestamp = timestamp
+	return nil
+}
+
+// copyMetadata returns a deep copy of meta, signatures included
+func copyMetadata[T metadata.Roles](meta *metadata.Metadata[T]) (*metadata.Metadata[T], error) {
+	data, err := meta.UnsafeToBytes(false)
+	if err != nil {
+		return nil, err
+	}
+	return (&metadata.Metadata[T]{}).FromBytes(data)
+}
+
+// resignMetadata replaces the signatures of meta with new ones by each of
+// the signers of role and returns its serialized form
+func resignMetadata[T metadata.Roles](meta *metadata.Metadata[T], role string, signers map[string][]signature.Signer) ([]byte, error) {
+	if len(signers[role]) == 0 {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("no signers for %s", role)}
+	}
+	meta.ClearSignatures()
+	for _, signer := range signers[role] {
+		if _, err := meta.Sign(signer); err != nil {
+			return nil, err
+		}
+	}
+	return meta.ToBytes(false)
+}
+
+// updateMetaFile points meta[name] to version and to the length and hashes
+// of data, keeping only the fields which were already set
+func updateMetaFile(meta map[string]*metadata.MetaFiles, name string, version int64, data []byte) error {
+	metaFile, ok := meta[name]
+	if !ok {
+		meta[name] = metadata.MetaFile(version)
+		return nil
+	}
+	metaFile.Version = version
+	if metaFile.HasLength() {
+		metaFile.SetLength(int64(len(data)))
+	}
+	if len(metaFile.Hashes) > 0 {
+		algorithms := make([]string, 0, len(metaFile.Hashes))
+		for algorithm := range metaFile.Hashes {
+			algorithms = append(algorithms, algorithm)
+		}
+		sort.Strings(algorithms)
+		targetFile, err := metadata.TargetFile().FromBytes("", data, algorithms...)
+		if err != nil {
+			return err
+		}
+		metaFile.Hashes = targetFile.Hashes
+	}
+	return nil
+}
@@ -16,6 +16,9 @@ import (
 	"time"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/updater"
+	"github.com/sigstore/sigstore/pkg/signature"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -50,3 +53,154 @@ func TestNewRepository(t *testing.T) {
 	assert.Equal(t, int64(1), repo.Snapshot().Signed.Version)
 	assert.Equal(t, metadata.SPECIFICATION_VERSION, repo.Snapshot().Signed.SpecVersion)
 }
+
+func TestNewTestRepository(t *testing.T) {
+	repo, err := NewTestRepository()
+	assert.NoError(t, err)
+
+	cfg, err := config.New(TestMetadataURL, repo.Root)
+	assert.NoError(t, err)
+	cfg.RemoteTargetsURL = TestTargetsURL
+	cfg.Fetcher = repo.Fetcher
+	cfg.LocalMetadataDir = t.TempDir()
+	cfg.LocalTargetsDir = t.TempDir()
+	// every meta commits to hashes, so require them
+	cfg.RequireMetaHashes = true
+	up, err := updater.New(cfg)
+	assert.NoError(t, err)
+	err = up.Refresh()
+	assert.NoError(t, err)
+	trusted := up.GetTrustedMetadataSet()
+	assert.Equal(t, int64(1), trusted.Root.Signed.Version)
+	assert.Equal(t, int64(1), trusted.Timestamp.Signed.Version)
+	assert.Equal(t, int64(1), trusted.Snapshot.Signed.Version)
+	assert.Len(t, trusted.Targets[metadata.TARGETS].Signed.Targets, len(repo.Targets))
+
+	for targetPath, content := range repo.Targets {
+		info, err := up.GetTargetInfo(targetPath)
+		assert.NoError(t, err)
+		_, data, err := up.DownloadTarget(info, "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, content, data)
+	}
+
+	// targets are served without hash prefixes too
+	cfg.PrefixTargetsWithHash = false
+	info, err := up.GetTargetInfo("dir/file2.txt")
+	assert.NoError(t, err)
+	_, data, err := up.DownloadTarget(info, t.TempDir()+"/file2.txt", "")
+	assert.NoError(t, err)
+	assert.Equal(t, repo.Targets["dir/file2.txt"], data)
+
+	// each call generates a new repository
+	other, err := NewTestRepository()
+	assert.NoError(t, err)
+	assert.NotEqual(t, repo.Root, other.Root)
+}
+
+func TestCheckExpiryOrder(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	repo := New()
+	// nothing to check in an empty repository
+	assert.Empty(t, repo.CheckExpiryOrder())
+
+	// correctly ordered, equal expiries are fine
+	repo.SetTimestamp(metadata.Timestamp(now.AddDate(0, 0, 1)))
+	repo.SetSnapshot(metadata.Snapshot(now.AddDate(0, 0, 7)))
+	repo.SetTargets(metadata.TARGETS, metadata.Targets(now.AddDate(0, 3, 0)))
+	repo.SetRoot(metadata.Root(now.AddDate(0, 3, 0)))
+	assert.Empty(t, repo.CheckExpiryOrder())
+
+	// targets expiring before snapshot and timestamp
+	repo.SetTargets(metadata.TARGETS, metadata.Targets(now.AddDate(0, 0, 2)))
+	inversions := repo.CheckExpiryOrder()
+	assert.Equal(t, []ExpiryInversion{
+		{Role: metadata.SNAPSHOT, Expires: now.AddDate(0, 0, 7), LaterRole: metadata.TARGETS, LaterRoleExpires: now.AddDate(0, 0, 2)},
+	}, inversions)
+
+	// root expiring first, missing snapshot
+	repo = New()
+	repo.SetTimestamp(metadata.Timestamp(now.AddDate(0, 0, 1)))
+	repo.SetTargets(metadata.TARGETS, metadata.Targets(now.AddDate(0, 3, 0)))
+	repo.SetRoot(metadata.Root(now))
+	inversions = repo.CheckExpiryOrder()
+	assert.Equal(t, []ExpiryInversion{
+		{Role: metadata.TIMESTAMP, Expires: now.AddDate(0, 0, 1), LaterRole: metadata.ROOT, LaterRoleExpires: now},
+		{Role: metadata.TARGETS, Expires: now.AddDate(0, 3, 0), LaterRole: metadata.ROOT, LaterRoleExpires: now},
+	}, inversions)
+}
+
+func TestBumpAll(t *testing.T) {
+	testRepo, err := NewTestRepository()
+	assert.NoError(t, err)
+	repo := New()
+	root, err := metadata.Root().FromBytes(testRepo.Metadata["root.json"])
+	assert.NoError(t, err)
+	repo.SetRoot(root)
+	targets, err := metadata.Targets().FromBytes(testRepo.Metadata["targets.json"])
+	assert.NoError(t, err)
+	repo.SetTargets(metadata.TARGETS, targets)
+	snapshot, err := metadata.Snapshot().FromBytes(testRepo.Metadata["snapshot.json"])
+	assert.NoError(t, err)
+	repo.SetSnapshot(snapshot)
+	timestamp, err := metadata.Timestamp().FromBytes(testRepo.Metadata["timestamp.json"])
+	assert.NoError(t, err)
+	repo.SetTimestamp(timestamp)
+	signers := map[string][]signature.Signer{}
+	for role, signer := range testRepo.Signers {
+		signers[role] = []signature.Signer{signer}
+	}
+
+	// errors leave the repository untouched
+	err = repo.BumpAll(map[string]time.Duration{"role1": time.Hour}, signers)
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "cannot bump non top-level role role1"})
+	err = repo.BumpAll(map[string]time.Duration{metadata.TARGETS: time.Hour}, map[string][]signature.Signer{metadata.TARGETS: signers[metadata.TARGETS]})
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "no signers for snapshot"})
+	assert.Equal(t, int64(1), repo.Targets(metadata.TARGETS).Signed.Version)
+	assert.Equal(t, int64(1), repo.Snapshot().Signed.Meta["targets.json"].Version)
+
+	// bump targets and root, snapshot and timestamp follow
+	err = repo.BumpAll(map[string]time.Duration{
+		metadata.ROOT:    365 * 24 * time.Hour,
+		metadata.TARGETS: 90 * 24 * time.Hour,
+	}, signers)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), repo.Root().Signed.Version)
+	assert.Equal(t, int64(2), repo.Targets(metadata.TARGETS).Signed.Version)
+	assert.Equal(t, int64(2), repo.Snapshot().Signed.Version)
+	assert.Equal(t, int64(2), repo.Timestamp().Signed.Version)
+	assert.Equal(t, snapshot.Signed.Expires, repo.Snapshot().Signed.Expires)
+	assert.WithinDuration(t, time.Now().Add(90*24*time.Hour), repo.Targets(metadata.TARGETS).Signed.Expires, time.Minute)
+
+	// publish the bumped repository and verify it with an Updater
+	publish := map[string][]byte{}
+	publish["2.root.json"], err = repo.Root().ToBytes(false)
+	assert.NoError(t, err)
+	publish["2.targets.json"], err = repo.Targets(metadata.TARGETS).ToBytes(false)
+	assert.NoError(t, err)
+	publish["2.snapshot.json"], err = repo.Snapshot().ToBytes(false)
+	assert.NoError(t, err)
+	publish["timestamp.json"], err = repo.Timestamp().ToBytes(false)
+	assert.NoError(t, err)
+	for name, data := range publish {
+		testRepo.Fetcher.SetFile(TestMetadataURL+"/"+name, data)
+	}
+
+	cfg, err := config.New(TestMetadataURL, testRepo.Root)
+	assert.NoError(t, err)
+	cfg.Fetcher = testRepo.Fetcher
+	cfg.LocalMetadataDir = t.TempDir()
+	cfg.LocalTargetsDir = t.TempDir()
+	cfg.RequireMetaHashes = true
+	up, err := updater.New(cfg)
+	assert.NoError(t, err)
+	err = up.Refresh()
+	assert.NoError(t, err)
+	trusted := up.GetTrustedMetadataSet()
+	assert.Equal(t, int64(2), trusted.Root.Signed.Version)
+	assert.Equal(t, int64(2), trusted.Timestamp.Signed.Version)
+	assert.Equal(t, int64(2), trusted.Snapshot.Signed.Version)
+	assert.Equal(t, int64(2), trusted.Targets[metadata.TARGETS].Signed.Version)
+	_, err = up.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+}
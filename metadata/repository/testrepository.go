@@ -0,0 +1,166 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/signature"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
+)
+
+// Base URLs the TestRepository metadata and target files are served from
+const (
+	TestMetadataURL = "https://tuf.test/metadata"
+	TestTargetsURL  = "https://tuf.test/targets"
+)
+
+// TestRepository is a minimal, fully signed and self-consistent repository
+// held in memory, see NewTestRepository
+type TestRepository struct {
+	// Root is the trusted root.json to initialize clients with
+	Root []byte
+	// Metadata maps file names, e.g. "1.snapshot.json" or "timestamp.json",
+	// to the signed metadata
+	Metadata map[string][]byte
+	// Targets maps target paths to the target file content
+	Targets map[string][]byte
+	// Signers maps each top-level role name to the signer of its metadata
+	Signers map[string]signature.Signer
+	// Fetcher serves Metadata under TestMetadataURL and Targets under
+	// TestTargetsURL, both with and without hash-prefixed file names
+	Fetcher *fetcher.MemoryFetcher
+}
+
+// NewTestRepository generates a key per top-level role and builds a signed
+// repository with consistent snapshots and a couple of targets, all of them
+// at version 1 and expiring in a year. It is meant for tests of TUF clients
+func NewTestRepository() (*TestRepository, error) {
+	expires := time.Now().UTC().Truncate(time.Second).AddDate(1, 0, 0)
+	repo := &TestRepository{
+		Metadata: map[string][]byte{},
+		Targets: map[string][]byte{
+			"file1.txt":     []byte("test repository target 1"),
+			"dir/file2.txt": []byte("test repository target 2"),
+		},
+		Signers: map[string]signature.Signer{},
+	}
+	files := map[string][]byte{}
+
+	// keys, one per top-level role
+	root := metadata.Root(expires)
+	for _, role := range metadata.TOP_LEVEL_ROLE_NAMES {
+		_, private, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := signature.LoadSigner(private, crypto.Hash(0))
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err := signer.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		key, err := metadata.KeyFromPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := root.Signed.AddKey(key, role); err != nil {
+			return nil, err
+		}
+		repo.Signers[role] = signer
+	}
+
+	// targets
+	targets := metadata.Targets(expires)
+	for targetPath, data := range repo.Targets {
+		targetFile, err := metadata.TargetFile().FromBytes(targetPath, data)
+		if err != nil {
+			return nil, err
+		}
+		targets.Signed.Targets[targetPath] = targetFile
+		dirName, baseName := path.Split(targetPath)
+		files[fmt.Sprintf("%s/%s", TestTargetsURL, targetPath)] = data
+		files[fmt.Sprintf("%s/%s%s.%s", TestTargetsURL, dirName, hex.EncodeToString(targetFile.Hashes["sha256"]), baseName)] = data
+	}
+
+	// metadata, each role committing to the hashes and length of the next
+	rootBytes, err := signTestMetadata(root, repo.Signers[metadata.ROOT])
+	if err != nil {
+		return nil, err
+	}
+	targetsBytes, err := signTestMetadata(targets, repo.Signers[metadata.TARGETS])
+	if err != nil {
+		return nil, err
+	}
+	snapshot := metadata.Snapshot(expires)
+	snapshot.Signed.Meta[fmt.Sprintf("%s.json", metadata.TARGETS)], err = testMetaFile(targetsBytes)
+	if err != nil {
+		return nil, err
+	}
+	snapshotBytes, err := signTestMetadata(snapshot, repo.Signers[metadata.SNAPSHOT])
+	if err != nil {
+		return nil, err
+	}
+	timestamp := metadata.Timestamp(expires)
+	timestamp.Signed.Meta[fmt.Sprintf("%s.json", metadata.SNAPSHOT)], err = testMetaFile(snapshotBytes)
+	if err != nil {
+		return nil, err
+	}
+	timestampBytes, err := signTestMetadata(timestamp, repo.Signers[metadata.TIMESTAMP])
+	if err != nil {
+		return nil, err
+	}
+	repo.Root = rootBytes
+	for role, data := range map[string][]byte{
+		metadata.ROOT:     rootBytes,
+		metadata.SNAPSHOT: snapshotBytes,
+		metadata.TARGETS:  targetsBytes,
+	} {
+		repo.Metadata[fmt.Sprintf("%s.json", role)] = data
+		repo.Metadata[fmt.Sprintf("1.%s.json", role)] = data
+	}
+	repo.Metadata[fmt.Sprintf("%s.json", metadata.TIMESTAMP)] = timestampBytes
+	for name, data := range repo.Metadata {
+		files[fmt.Sprintf("%s/%s", TestMetadataURL, name)] = data
+	}
+	repo.Fetcher = fetcher.NewMemoryFetcher(files)
+	return repo, nil
+}
+
+// signTestMetadata signs meta with signer and returns its serialized form
+func signTestMetadata[T metadata.Roles](meta *metadata.Metadata[T], signer signature.Signer) ([]byte, error) {
+	if _, err := meta.Sign(signer); err != nil {
+		return nil, err
+	}
+	return meta.ToBytes(false)
+}
+
+// testMetaFile returns the MetaFiles describing the version 1 metadata data
+func testMetaFile(data []byte) (*metadata.MetaFiles, error) {
+	targetFile, err := metadata.TargetFile().FromBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	metaFile := metadata.MetaFile(1)
+	metaFile.SetLength(targetFile.Length)
+	metaFile.Hashes = targetFile.Hashes
+	return metaFile, nil
+}
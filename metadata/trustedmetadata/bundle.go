@@ -0,0 +1,208 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package trustedmetadata
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+)
+
+// NewFromBundle creates a new TrustedMetadata instance from the trusted
+// rootData and loads a whole metadata set from bundle, e.g. a zip.Reader.
+// The bundle contains the top-level metadata as <role>.json files, delegated
+// targets metadata as <url-escaped role>.json files and any newer root
+// versions as <version>.root.json files. The root chain is verified starting
+// from rootData, followed by timestamp, snapshot, targets and the delegated
+// targets metadata present in the bundle, in delegation order
+func NewFromBundle(rootData []byte, bundle fs.FS) (*TrustedMetadata, error) {
+	return newFromBundle(rootData, func(name string) ([]byte, error) {
+		return fs.ReadFile(bundle, name)
+	})
+}
+
+// BundleMaxLengths caps the size of the files read from a tar.gz bundle, per
+// role and in total
+type BundleMaxLengths struct {
+	Root      int64
+	Timestamp int64
+	Snapshot  int64
+	// Targets applies to the top-level and delegated targets metadata
+	Targets int64
+	// Total applies to the whole decompressed archive
+	Total int64
+}
+
+// DefaultBundleMaxLengths returns the max lengths of the updater's default
+// configuration and a total of 50 MB
+func DefaultBundleMaxLengths() BundleMaxLengths {
+	return BundleMaxLengths{
+		Root:      512000,   // bytes
+		Timestamp: 16384,    // bytes
+		Snapshot:  2000000,  // bytes
+		Targets:   5000000,  // bytes
+		Total:     50000000, // bytes
+	}
+}
+
+// maxLength returns the max length of the bundle file called name
+func (l BundleMaxLengths) maxLength(name string) int64 {
+	switch {
+	case name == fmt.Sprintf("%s.json", metadata.TIMESTAMP):
+		return l.Timestamp
+	case name == fmt.Sprintf("%s.json", metadata.SNAPSHOT):
+		return l.Snapshot
+	case strings.HasSuffix(name, fmt.Sprintf(".%s.json", metadata.ROOT)):
+		return l.Root
+	default:
+		return l.Targets
+	}
+}
+
+// NewFromTarGzBundle is like NewFromBundle but reads the metadata set from
+// a gzipped tar archive. Each file in the archive is capped by the max
+// length of its role and the whole archive by maxLengths.Total
+func NewFromTarGzBundle(rootData []byte, r io.Reader, maxLengths BundleMaxLengths) (*TrustedMetadata, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	limited := &io.LimitedReader{R: gz, N: maxLengths.Total + 1}
+	errTooLarge := metadata.ErrValue{Msg: fmt.Sprintf("bundle is larger than %d bytes", maxLengths.Total)}
+	files := map[string][]byte{}
+	archive := tar.NewReader(limited)
+	for {
+		header, err := archive.Next()
+		if limited.N <= 0 {
+			return nil, errTooLarge
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		maxLength := maxLengths.maxLength(name)
+		data, err := io.ReadAll(io.LimitReader(archive, maxLength+1))
+		if limited.N <= 0 {
+			return nil, errTooLarge
+		}
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > maxLength {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("bundle file %s is larger than %d bytes", name, maxLength)}
+		}
+		files[name] = data
+	}
+	return newFromBundle(rootData, func(name string) ([]byte, error) {
+		data, ok := files[name]
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return data, nil
+	})
+}
+
+// newFromBundle loads and verifies the metadata set returned by readFile
+func newFromBundle(rootData []byte, readFile func(name string) ([]byte, error)) (*TrustedMetadata, error) {
+	trusted, err := New(rootData)
+	if err != nil {
+		return nil, err
+	}
+	// newer root versions, if any
+	for {
+		data, err := readFile(fmt.Sprintf("%d.%s.json", trusted.Root.Signed.Version+1, metadata.ROOT))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := trusted.UpdateRoot(data); err != nil {
+			return nil, err
+		}
+	}
+	// top-level metadata
+	data, err := readFile(fmt.Sprintf("%s.json", metadata.TIMESTAMP))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := trusted.UpdateTimestamp(data); err != nil {
+		return nil, err
+	}
+	data, err = readFile(fmt.Sprintf("%s.json", metadata.SNAPSHOT))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := trusted.UpdateSnapshot(data, false); err != nil {
+		return nil, err
+	}
+	data, err = readFile(fmt.Sprintf("%s.json", metadata.TARGETS))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := trusted.UpdateTargets(data); err != nil {
+		return nil, err
+	}
+	// delegated targets metadata, depth-first so that each delegator is
+	// loaded before the roles it delegates to
+	type roleParent struct {
+		role   string
+		parent string
+	}
+	toVisit := []roleParent{}
+	addChildren := func(parent string) {
+		delegations := trusted.Targets[parent].Signed.Delegations
+		if delegations == nil {
+			return
+		}
+		roles := delegations.GetRoles()
+		for i := len(roles) - 1; i >= 0; i-- {
+			toVisit = append(toVisit, roleParent{role: roles[i], parent: parent})
+		}
+	}
+	addChildren(metadata.TARGETS)
+	for len(toVisit) > 0 {
+		next := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		if trusted.Targets[next.role] != nil {
+			continue
+		}
+		data, err := readFile(fmt.Sprintf("%s.json", url.QueryEscape(next.role)))
+		if errors.Is(err, fs.ErrNotExist) {
+			// the bundle doesn't have to include every delegated role
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := trusted.UpdateDelegatedTargets(data, next.role, next.parent); err != nil {
+			return nil, err
+		}
+		addChildren(next.role)
+	}
+	return trusted, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package trustedmetadata
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+)
+
+// TargetsCache is a thread-safe cache of verified targets metadata, which
+// can be shared by the TrustedMetadata of several Updaters, e.g. when a
+// server creates an Updater per request. UpdateDelegatedTargets skips the
+// signature verification of targets metadata whose bytes were already
+// verified with the same delegation keys and threshold. The bytes are still
+// parsed, so each TrustedMetadata gets its own copy of the metadata, and
+// version and expiry are still checked against the snapshot and reference
+// time of each TrustedMetadata. Only the latest verified version of each
+// role is kept, and it is evicted when the role is verified with different
+// delegation keys or threshold
+type TargetsCache struct {
+	mu      sync.RWMutex
+	entries map[string]targetsCacheEntry
+}
+
+// targetsCacheKey identifies a role as delegated by a set of keys
+type targetsCacheKey struct {
+	role       string
+	delegation [sha256.Size]byte
+}
+
+// targetsCacheEntry is the version of a role, the digest of the verified
+// bytes it was loaded from and the digest of the delegation they were
+// verified with
+type targetsCacheEntry struct {
+	delegation [sha256.Size]byte
+	version    int64
+	digest     [sha256.Size]byte
+}
+
+// NewTargetsCache creates an empty TargetsCache
+func NewTargetsCache() *TargetsCache {
+	return &TargetsCache{entries: map[string]targetsCacheEntry{}}
+}
+
+// Len returns the number of cached roles
+func (c *TargetsCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// verified reports whether the bytes with digest were already verified at
+// version for key
+func (c *TargetsCache) verified(key targetsCacheKey, version int64, digest [sha256.Size]byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[key.role]
+	return ok && entry.delegation == key.delegation && entry.version == version && entry.digest == digest
+}
+
+// add records the bytes with digest as verified at version for key,
+// replacing any other version or delegation of the role
+func (c *TargetsCache) add(key targetsCacheKey, version int64, digest [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key.role] = targetsCacheEntry{delegation: key.delegation, version: version, digest: digest}
+}
+
+// targetsCacheKey returns the cache key of roleName as delegated by
+// delegatorName, i.e. a digest of the keys and threshold the delegator
+// trusts roleName with. It returns false if the delegation isn't found,
+// the verification then reports why
+func (trusted *TrustedMetadata) targetsCacheKey(roleName, delegatorName string) (targetsCacheKey, bool) {
+	delegation := struct {
+		Keys      map[string]*metadata.Key `json:"keys"`
+		Threshold int                      `json:"threshold"`
+	}{Keys: map[string]*metadata.Key{}}
+	var keys map[string]*metadata.Key
+	var keyIDs []string
+	if delegatorName == metadata.ROOT {
+		role, ok := trusted.Root.Signed.Roles[roleName]
+		if !ok {
+			return targetsCacheKey{}, false
+		}
+		keys, keyIDs, delegation.Threshold = trusted.Root.Signed.Keys, role.KeyIDs, role.Threshold
+	} else {
+		delegations := trusted.Targets[delegatorName].Signed.Delegations
+		if delegations == nil {
+			return targetsCacheKey{}, false
+		}
+		keys = delegations.Keys
+		if delegations.Roles != nil {
+			for _, role := range delegations.Roles {
+				if role.Name == roleName {
+					keyIDs, delegation.Threshold = role.KeyIDs, role.Threshold
+					break
+				}
+			}
+		} else if delegations.SuccinctRoles != nil {
+			keyIDs, delegation.Threshold = delegations.SuccinctRoles.KeyIDs, delegations.SuccinctRoles.Threshold
+		}
+	}
+	for _, keyID := range keyIDs {
+		if key, ok := keys[keyID]; ok {
+			delegation.Keys[keyID] = key
+		}
+	}
+	if len(delegation.Keys) == 0 {
+		return targetsCacheKey{}, false
+	}
+	data, err := json.Marshal(delegation)
+	if err != nil {
+		return targetsCacheKey{}, false
+	}
+	return targetsCacheKey{role: roleName, delegation: sha256.Sum256(data)}, true
+}
@@ -12,6 +12,7 @@
 package trustedmetadata
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"time"
 
@@ -25,6 +26,13 @@ type TrustedMetadata struct {
 	Timestamp *metadata.Metadata[metadata.TimestampType]
 	Targets   map[string]*metadata.Metadata[metadata.TargetsType]
 	RefTime   time.Time
+	// TargetsCache, if set, is used to skip verifying targets metadata
+	// that was already verified, see TargetsCache
+	TargetsCache *TargetsCache
+	// SignatureVerificationWorkers, if greater than 1, is the number of
+	// signatures of a metadata file verified concurrently, see
+	// metadata.VerifyDelegateConcurrent
+	SignatureVerificationWorkers int
 }
 
 // New creates a new TrustedMetadata instance which ensures that the
@@ -32,6 +40,13 @@ type TrustedMetadata struct {
 // client update workflow. It provides easy ways to update the metadata
 // with the caller making decisions on what is updated
 func New(rootData []byte) (*TrustedMetadata, error) {
+	return NewWithMinRootVersion(rootData, 0)
+}
+
+// NewWithMinRootVersion works like New, but also rejects a trusted root with
+// a version lower than minRootVersion, e.g. to avoid bootstrapping from a
+// stale embedded root. A zero value disables the check
+func NewWithMinRootVersion(rootData []byte, minRootVersion int64) (*TrustedMetadata, error) {
 	res := &TrustedMetadata{
 		Targets: map[string]*metadata.Metadata[metadata.TargetsType]{},
 		RefTime: time.Now().UTC(),
@@ -42,9 +57,73 @@ func New(rootData []byte) (*TrustedMetadata, error) {
 	if err != nil {
 		return nil, err
 	}
+	// make sure the trusted root is not older than expected
+	if res.Root.Signed.Version < minRootVersion {
+		return nil, metadata.ErrBadVersionNumber{Msg: fmt.Sprintf("trusted root version %d is below the minimum version %d", res.Root.Signed.Version, minRootVersion)}
+	}
 	return res, nil
 }
 
+// NewFromRoot works like New, but starts from root metadata built in code,
+// e.g. from an application's configuration, rather than from bytes. The root
+// is serialized and loaded again, so it must be signed by a threshold of its
+// own root keys and not modified after signing. Later changes to root don't
+// affect the returned instance
+func NewFromRoot(root *metadata.Metadata[metadata.RootType]) (*TrustedMetadata, error) {
+	return NewFromRootWithMinRootVersion(root, 0)
+}
+
+// NewFromRootWithMinRootVersion works like NewFromRoot, but also rejects a
+// root with a version lower than minRootVersion, see NewWithMinRootVersion
+func NewFromRootWithMinRootVersion(root *metadata.Metadata[metadata.RootType], minRootVersion int64) (*TrustedMetadata, error) {
+	if root == nil {
+		return nil, metadata.ErrValue{Msg: "trusted root metadata is nil"}
+	}
+	rootData, err := root.ToBytes(false)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithMinRootVersion(rootData, minRootVersion)
+}
+
+// Checkpoint is a saved state of a TrustedMetadata instance which can be
+// restored with Rollback()
+type Checkpoint struct {
+	root      *metadata.Metadata[metadata.RootType]
+	snapshot  *metadata.Metadata[metadata.SnapshotType]
+	timestamp *metadata.Metadata[metadata.TimestampType]
+	targets   map[string]*metadata.Metadata[metadata.TargetsType]
+}
+
+// Checkpoint saves the current state of the trusted metadata set, so that
+// a series of updates can be undone as a whole with Rollback(). Loaded
+// metadata is replaced and never modified in place by the update functions,
+// so the checkpoint only needs to keep references to it
+func (trusted *TrustedMetadata) Checkpoint() *Checkpoint {
+	targets := make(map[string]*metadata.Metadata[metadata.TargetsType], len(trusted.Targets))
+	for roleName, role := range trusted.Targets {
+		targets[roleName] = role
+	}
+	return &Checkpoint{
+		root:      trusted.Root,
+		snapshot:  trusted.Snapshot,
+		timestamp: trusted.Timestamp,
+		targets:   targets,
+	}
+}
+
+// Rollback restores the trusted metadata set to the state saved in
+// checkpoint, discarding any metadata loaded since then
+func (trusted *TrustedMetadata) Rollback(checkpoint *Checkpoint) {
+	trusted.Root = checkpoint.root
+	trusted.Snapshot = checkpoint.snapshot
+	trusted.Timestamp = checkpoint.timestamp
+	trusted.Targets = make(map[string]*metadata.Metadata[metadata.TargetsType], len(checkpoint.targets))
+	for roleName, role := range checkpoint.targets {
+		trusted.Targets[roleName] = role
+	}
+}
+
 // UpdateRoot verifies and loads “rootData“ as new root metadata.
 // Note that an expired intermediate root is considered valid: expiry is
 // only checked for the final root in UpdateTimestamp()
@@ -55,26 +134,8 @@ func (trusted *TrustedMetadata) UpdateRoot(rootData []byte) (*metadata.Metadata[
 		return nil, metadata.ErrRuntime{Msg: "cannot update root after timestamp"}
 	}
 	log.Info("Updating root")
-	// generate root metadata
-	newRoot, err := metadata.Root().FromBytes(rootData)
-	if err != nil {
-		return nil, err
-	}
-	// check metadata type matches root
-	if newRoot.Signed.Type != metadata.ROOT {
-		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.ROOT, newRoot.Signed.Type)}
-	}
-	// verify that new root is signed by trusted root
-	err = trusted.Root.VerifyDelegate(metadata.ROOT, newRoot)
-	if err != nil {
-		return nil, err
-	}
-	// verify version
-	if newRoot.Signed.Version != trusted.Root.Signed.Version+1 {
-		return nil, metadata.ErrBadVersionNumber{Msg: fmt.Sprintf("bad version number, expected %d, got %d", trusted.Root.Signed.Version+1, newRoot.Signed.Version)}
-	}
-	// verify that new root is signed by itself
-	err = newRoot.VerifyDelegate(metadata.ROOT, newRoot)
+	// verify the new root against the trusted root and itself
+	newRoot, err := metadata.VerifyRootChainConcurrent(trusted.Root, trusted.SignatureVerificationWorkers, rootData)
 	if err != nil {
 		return nil, err
 	}
@@ -112,10 +173,18 @@ func (trusted *TrustedMetadata) UpdateTimestamp(timestampData []byte) (*metadata
 		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.TIMESTAMP, newTimestamp.Signed.Type)}
 	}
 	// verify that new timestamp is signed by trusted root
-	err = trusted.Root.VerifyDelegate(metadata.TIMESTAMP, newTimestamp)
+	err = trusted.Root.VerifyDelegateConcurrent(metadata.TIMESTAMP, newTimestamp, trusted.SignatureVerificationWorkers)
 	if err != nil {
 		return nil, err
 	}
+	// timestamp must only reference snapshot.json
+	snapshotName := fmt.Sprintf("%s.json", metadata.SNAPSHOT)
+	if newTimestamp.Signed.Meta[snapshotName] == nil {
+		return nil, metadata.ErrInvalidTimestampMeta{Msg: fmt.Sprintf("timestamp has no meta for %s", snapshotName)}
+	}
+	if len(newTimestamp.Signed.Meta) != 1 {
+		return nil, metadata.ErrInvalidTimestampMeta{Msg: fmt.Sprintf("timestamp must only have meta for %s, got %d entries", snapshotName, len(newTimestamp.Signed.Meta))}
+	}
 	// if an existing trusted timestamp is updated,
 	// check for a rollback attack
 	if trusted.Timestamp != nil {
@@ -199,8 +268,16 @@ func (trusted *TrustedMetadata) UpdateSnapshot(snapshotData []byte, isTrusted bo
 	if newSnapshot.Signed.Type != metadata.SNAPSHOT {
 		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.SNAPSHOT, newSnapshot.Signed.Type)}
 	}
+	// trusted snapshot data must still match the hashes in timestamp if it
+	// claims to be the snapshot version that timestamp commits to
+	if isTrusted && newSnapshot.Signed.Version == snapshotMeta.Version {
+		err = snapshotMeta.VerifyLengthHashes(snapshotData)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// verify that new snapshot is signed by trusted root
-	err = trusted.Root.VerifyDelegate(metadata.SNAPSHOT, newSnapshot)
+	err = trusted.Root.VerifyDelegateConcurrent(metadata.SNAPSHOT, newSnapshot, trusted.SignatureVerificationWorkers)
 	if err != nil {
 		return nil, err
 	}
@@ -291,22 +368,23 @@ func (trusted *TrustedMetadata) UpdateDelegatedTargets(targetsData []byte, roleN
 	if err != nil {
 		return nil, err
 	}
-	newDelegate, err := metadata.Targets().FromBytes(targetsData)
-	if err != nil {
-		return nil, err
-	}
-	// check metadata type matches targets
-	if newDelegate.Signed.Type != metadata.TARGETS {
-		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.TARGETS, newDelegate.Signed.Type)}
-	}
-	// get delegator metadata and verify the new delegatee
-	if delegatorName == metadata.ROOT {
-		err = trusted.Root.VerifyDelegate(roleName, newDelegate)
+	// reuse the same bytes if already verified with the same delegation
+	var cacheKey targetsCacheKey
+	var digest [sha256.Size]byte
+	cacheable := false
+	if trusted.TargetsCache != nil {
+		cacheKey, cacheable = trusted.targetsCacheKey(roleName, delegatorName)
+		digest = sha256.Sum256(targetsData)
+	}
+	var newDelegate *metadata.Metadata[metadata.TargetsType]
+	if cacheable && trusted.TargetsCache.verified(cacheKey, meta.Version, digest) {
+		log.Info("Using cached verified role", "role", roleName, "version", meta.Version)
+		newDelegate, err = metadata.Targets().FromBytes(targetsData)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		err = trusted.Targets[delegatorName].VerifyDelegate(roleName, newDelegate)
+		newDelegate, err = trusted.verifyDelegatedTargets(targetsData, roleName, delegatorName)
 		if err != nil {
 			return nil, err
 		}
@@ -319,11 +397,37 @@ func (trusted *TrustedMetadata) UpdateDelegatedTargets(targetsData []byte, roleN
 	if newDelegate.Signed.IsExpired(trusted.RefTime) {
 		return nil, metadata.ErrExpiredMetadata{Msg: fmt.Sprintf("new %s is expired", roleName)}
 	}
+	if cacheable {
+		trusted.TargetsCache.add(cacheKey, newDelegate.Signed.Version, digest)
+	}
 	trusted.Targets[roleName] = newDelegate
 	log.Info("Updated role", "role", roleName, "version", trusted.Targets[roleName].Signed.Version)
 	return trusted.Targets[roleName], nil
 }
 
+// verifyDelegatedTargets loads targetsData and verifies it is targets
+// metadata signed by the keys delegatorName delegates roleName to
+func (trusted *TrustedMetadata) verifyDelegatedTargets(targetsData []byte, roleName, delegatorName string) (*metadata.Metadata[metadata.TargetsType], error) {
+	newDelegate, err := metadata.Targets().FromBytes(targetsData)
+	if err != nil {
+		return nil, err
+	}
+	// check metadata type matches targets
+	if newDelegate.Signed.Type != metadata.TARGETS {
+		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.TARGETS, newDelegate.Signed.Type)}
+	}
+	// get delegator metadata and verify the new delegatee
+	if delegatorName == metadata.ROOT {
+		err = trusted.Root.VerifyDelegateConcurrent(roleName, newDelegate, trusted.SignatureVerificationWorkers)
+	} else {
+		err = trusted.Targets[delegatorName].VerifyDelegateConcurrent(roleName, newDelegate, trusted.SignatureVerificationWorkers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newDelegate, nil
+}
+
 // loadTrustedRoot verifies and loads "data" as trusted root metadata.
 // Note that an expired initial root is considered valid: expiry is
 // only checked for the final root in “UpdateTimestamp()“.
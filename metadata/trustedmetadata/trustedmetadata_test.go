@@ -12,16 +12,20 @@
 package trustedmetadata
 
 import (
-	"crypto"
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
 	"github.com/rdimitrov/go-tuf-metadata/testutils/testutils"
-	"github.com/sigstore/sigstore/pkg/cryptoutils"
-	"github.com/sigstore/sigstore/pkg/signature"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/exp/maps"
 )
@@ -109,7 +113,7 @@ func modifyRootMetadata(fn modifyRoot) ([]byte, error) {
 	}
 	fn(root)
 
-	signer, err := signature.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "root_key"), crypto.SHA256, cryptoutils.SkipPassword)
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "root_key"))
 	if err != nil {
 		log.Error(err, "failed to load signer from pem file")
 	}
@@ -132,7 +136,7 @@ func modifyTimestamptMetadata(fn modifyTimestamp) ([]byte, error) {
 	}
 	fn(timestamp)
 
-	signer, err := signature.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "timestamp_key"), crypto.SHA256, cryptoutils.SkipPassword)
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "timestamp_key"))
 	if err != nil {
 		log.Error(err, "failed to load signer from pem file")
 	}
@@ -155,7 +159,7 @@ func modifySnapshotMetadata(fn modifySnapshot) ([]byte, error) {
 	}
 	fn(snapshot)
 
-	signer, err := signature.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "snapshot_key"), crypto.SHA256, cryptoutils.SkipPassword)
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "snapshot_key"))
 	if err != nil {
 		log.Error(err, "failed to load signer from pem file")
 	}
@@ -178,7 +182,7 @@ func modifyTargetsMetadata(fn modifyTargets) ([]byte, error) {
 	}
 	fn(targets)
 
-	signer, err := signature.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "targets_key"), crypto.SHA256, cryptoutils.SkipPassword)
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "targets_key"))
 	if err != nil {
 		log.Error(err, "failed to load signer from pem file")
 	}
@@ -253,6 +257,194 @@ func TestOutOfOrderOps(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCheckpointRollback(t *testing.T) {
+	trustedSet, err := New(allRoles[metadata.ROOT])
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateTimestamp(allRoles[metadata.TIMESTAMP])
+	assert.NoError(t, err)
+	checkpoint := trustedSet.Checkpoint()
+
+	_, err = trustedSet.UpdateSnapshot(allRoles[metadata.SNAPSHOT], false)
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateTargets(allRoles[metadata.TARGETS])
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateDelegatedTargets(allRoles["role1"], "role1", metadata.TARGETS)
+	assert.NoError(t, err)
+
+	// Metadata loaded after the checkpoint is discarded
+	timestamp := trustedSet.Timestamp
+	trustedSet.Rollback(checkpoint)
+	assert.NotNil(t, trustedSet.Root)
+	assert.Same(t, timestamp, trustedSet.Timestamp)
+	assert.Nil(t, trustedSet.Snapshot)
+	assert.Empty(t, trustedSet.Targets)
+
+	// The updates can be done again after a rollback
+	_, err = trustedSet.UpdateSnapshot(allRoles[metadata.SNAPSHOT], false)
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateTargets(allRoles[metadata.TARGETS])
+	assert.NoError(t, err)
+	assert.Len(t, trustedSet.Targets, 1)
+
+	// Rolling back does not modify the checkpoint
+	trustedSet.Rollback(checkpoint)
+	assert.Empty(t, trustedSet.Targets)
+}
+
+func TestNewFromBundle(t *testing.T) {
+	newRoot, err := modifyRootMetadata(func(root *metadata.Metadata[metadata.RootType]) {
+		root.Signed.Version += 1
+	})
+	assert.NoError(t, err)
+	files := map[string][]byte{
+		"2.root.json":    newRoot,
+		"timestamp.json": allRoles[metadata.TIMESTAMP],
+		"snapshot.json":  allRoles[metadata.SNAPSHOT],
+		"targets.json":   allRoles[metadata.TARGETS],
+		"role1.json":     allRoles["role1"],
+		"role2.json":     allRoles["role2"],
+	}
+	assertBundleLoaded := func(trustedSet *TrustedMetadata) {
+		assert.Equal(t, int64(2), trustedSet.Root.Signed.Version)
+		assert.NotNil(t, trustedSet.Timestamp)
+		assert.NotNil(t, trustedSet.Snapshot)
+		assert.ElementsMatch(t, []string{metadata.TARGETS, "role1", "role2"}, maps.Keys(trustedSet.Targets))
+	}
+
+	// Test loading a zip archive
+	zipData := bytes.Buffer{}
+	zipWriter := zip.NewWriter(&zipData)
+	for name, data := range files {
+		w, err := zipWriter.Create(name)
+		assert.NoError(t, err)
+		_, err = w.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zipWriter.Close())
+	zipReader, err := zip.NewReader(bytes.NewReader(zipData.Bytes()), int64(zipData.Len()))
+	assert.NoError(t, err)
+	trustedSet, err := NewFromBundle(allRoles[metadata.ROOT], zipReader)
+	assert.NoError(t, err)
+	assertBundleLoaded(trustedSet)
+
+	// Test loading a tar.gz archive
+	tarData := bytes.Buffer{}
+	gzWriter := gzip.NewWriter(&tarData)
+	tarWriter := tar.NewWriter(gzWriter)
+	for name, data := range files {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		assert.NoError(t, err)
+		_, err = tarWriter.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tarWriter.Close())
+	assert.NoError(t, gzWriter.Close())
+	trustedSet, err = NewFromTarGzBundle(allRoles[metadata.ROOT], bytes.NewReader(tarData.Bytes()), DefaultBundleMaxLengths())
+	assert.NoError(t, err)
+	assertBundleLoaded(trustedSet)
+
+	// Test that the files of a tar.gz archive are capped by their role's max
+	// length and the whole archive by the total max length
+	maxLengths := DefaultBundleMaxLengths()
+	maxLengths.Timestamp = 10
+	_, err = NewFromTarGzBundle(allRoles[metadata.ROOT], bytes.NewReader(tarData.Bytes()), maxLengths)
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "bundle file timestamp.json is larger than 10 bytes"})
+	maxLengths = DefaultBundleMaxLengths()
+	maxLengths.Total = 1000
+	_, err = NewFromTarGzBundle(allRoles[metadata.ROOT], bytes.NewReader(tarData.Bytes()), maxLengths)
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "bundle is larger than 1000 bytes"})
+
+	// Test that delegated roles missing from the bundle are skipped
+	bundle := fstest.MapFS{}
+	for name, data := range files {
+		if name != "role2.json" {
+			bundle[name] = &fstest.MapFile{Data: data}
+		}
+	}
+	trustedSet, err = NewFromBundle(allRoles[metadata.ROOT], bundle)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{metadata.TARGETS, "role1"}, maps.Keys(trustedSet.Targets))
+
+	// Test that the chain is verified
+	snapshot, err := metadata.Snapshot().FromBytes(allRoles[metadata.SNAPSHOT])
+	assert.NoError(t, err)
+	snapshot.ClearSignatures()
+	unsignedSnapshot, err := snapshot.ToBytes(false)
+	assert.NoError(t, err)
+	bundle["snapshot.json"] = &fstest.MapFile{Data: unsignedSnapshot}
+	_, err = NewFromBundle(allRoles[metadata.ROOT], bundle)
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying snapshot failed, not enough signatures, got 0, want 1"})
+
+	// Test that the top-level metadata is required
+	delete(bundle, "snapshot.json")
+	_, err = NewFromBundle(allRoles[metadata.ROOT], bundle)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestNewFromRoot(t *testing.T) {
+	// build the trusted root in code, using the keys of the test repository
+	repoRoot, err := metadata.Root().FromBytes(allRoles[metadata.ROOT])
+	assert.NoError(t, err)
+	root := metadata.Root(repoRoot.Signed.Expires)
+	for roleName, role := range repoRoot.Signed.Roles {
+		for _, keyID := range role.KeyIDs {
+			assert.NoError(t, root.Signed.AddKey(repoRoot.Signed.Keys[keyID], roleName))
+		}
+	}
+	signer, err := testutils.LoadSignerFromPEMFile(filepath.Join(testutils.KeystoreDir, "root_key"))
+	assert.NoError(t, err)
+
+	// Test an unsigned root is not trusted
+	_, err = NewFromRoot(root)
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying root failed, not enough signatures, got 0, want 1"})
+
+	_, err = root.Sign(signer)
+	assert.NoError(t, err)
+	trustedSet, err := NewFromRoot(root)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), trustedSet.Root.Signed.Version)
+
+	// Test the rest of the top-level metadata verifies against it
+	_, err = trustedSet.UpdateTimestamp(allRoles[metadata.TIMESTAMP])
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateSnapshot(allRoles[metadata.SNAPSHOT], false)
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateTargets(allRoles[metadata.TARGETS])
+	assert.NoError(t, err)
+
+	// Test later changes to the struct don't affect the trusted set
+	root.Signed.Version = 5
+	assert.Equal(t, int64(1), trustedSet.Root.Signed.Version)
+
+	// Test a root modified after signing is rejected
+	_, err = NewFromRoot(root)
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "signed metadata was modified after signing, re-sign or clear the stale signatures"})
+
+	_, err = NewFromRoot(nil)
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "trusted root metadata is nil"})
+}
+
+func TestMinRootVersion(t *testing.T) {
+	root, err := metadata.Root().FromBytes(allRoles[metadata.ROOT])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), root.Signed.Version)
+
+	// Test a root below the minimum version is rejected from bytes and from
+	// a root object
+	_, err = NewWithMinRootVersion(allRoles[metadata.ROOT], 2)
+	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "trusted root version 1 is below the minimum version 2"})
+	_, err = NewFromRootWithMinRootVersion(root, 2)
+	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "trusted root version 1 is below the minimum version 2"})
+
+	// Test a root at the minimum version is trusted
+	trustedSet, err := NewWithMinRootVersion(allRoles[metadata.ROOT], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), trustedSet.Root.Signed.Version)
+	trustedSet, err = NewFromRootWithMinRootVersion(root, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), trustedSet.Root.Signed.Version)
+}
+
 func TestRootWithInvalidJson(t *testing.T) {
 	trustedSet, err := New(allRoles[metadata.ROOT])
 	assert.NoError(t, err)
@@ -267,14 +459,14 @@ func TestRootWithInvalidJson(t *testing.T) {
 	root, err := metadata.Root().FromBytes(allRoles[metadata.ROOT])
 	assert.NoError(t, err)
 	root.Signed.Version += 1
-	rootBytes, err := root.ToBytes(true)
+	rootBytes, err := root.UnsafeToBytes(true)
 	assert.NoError(t, err)
 	_, err = trustedSet.UpdateRoot(rootBytes)
 	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying root failed, not enough signatures, got 0, want 1"})
 
 	// metadata is of wrong type
 	_, err = trustedSet.UpdateRoot(allRoles[metadata.SNAPSHOT])
-	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "expected metadata type root, got - snapshot"})
+	assert.ErrorIs(t, err, metadata.ErrMetadataType{Msg: "expected metadata type root, got - snapshot"})
 }
 
 func TestTopLevelMetadataWithInvalidJSON(t *testing.T) {
@@ -292,14 +484,14 @@ func TestTopLevelMetadataWithInvalidJSON(t *testing.T) {
 	properTimestampBytes, err := timestamp.ToBytes(true)
 	assert.NoError(t, err)
 	timestamp.Signed.Version += 1
-	timestampBytes, err := timestamp.ToBytes(true)
+	timestampBytes, err := timestamp.UnsafeToBytes(true)
 	assert.NoError(t, err)
 	_, err = trustedSet.UpdateTimestamp(timestampBytes)
 	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying timestamp failed, not enough signatures, got 0, want 1"})
 
 	// timestamp is of wrong type
 	_, err = trustedSet.UpdateTimestamp(allRoles[metadata.ROOT])
-	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "expected metadata type timestamp, got - root"})
+	assert.ErrorIs(t, err, metadata.ErrMetadataType{Msg: "expected metadata type timestamp, got - root"})
 
 	// SNAPSHOT
 	_, err = trustedSet.UpdateTimestamp(properTimestampBytes)
@@ -314,14 +506,14 @@ func TestTopLevelMetadataWithInvalidJSON(t *testing.T) {
 	properSnapshotBytes, err := snapshot.ToBytes(true)
 	assert.NoError(t, err)
 	snapshot.Signed.Version += 1
-	snapshotBytes, err := snapshot.ToBytes(true)
+	snapshotBytes, err := snapshot.UnsafeToBytes(true)
 	assert.NoError(t, err)
 	_, err = trustedSet.UpdateSnapshot(snapshotBytes, false)
 	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying snapshot failed, not enough signatures, got 0, want 1"})
 
 	// snapshot is of wrong type
 	_, err = trustedSet.UpdateSnapshot(allRoles[metadata.ROOT], false)
-	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "expected metadata type snapshot, got - root"})
+	assert.ErrorIs(t, err, metadata.ErrMetadataType{Msg: "expected metadata type snapshot, got - root"})
 
 	// TARGETS
 	_, err = trustedSet.UpdateSnapshot(properSnapshotBytes, false)
@@ -334,14 +526,14 @@ func TestTopLevelMetadataWithInvalidJSON(t *testing.T) {
 	targets, err := metadata.Targets().FromBytes(allRoles[metadata.TARGETS])
 	assert.NoError(t, err)
 	targets.Signed.Version += 1
-	targetsBytes, err := targets.ToBytes(true)
+	targetsBytes, err := targets.UnsafeToBytes(true)
 	assert.NoError(t, err)
 	_, err = trustedSet.UpdateTargets(targetsBytes)
 	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying targets failed, not enough signatures, got 0, want 1"})
 
 	// targets is of wrong type
 	_, err = trustedSet.UpdateTargets(allRoles[metadata.ROOT])
-	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "expected metadata type targets, got - root"})
+	assert.ErrorIs(t, err, metadata.ErrMetadataType{Msg: "expected metadata type targets, got - root"})
 }
 
 func TestUpdateRootNewRoot(t *testing.T) {
@@ -452,6 +644,32 @@ func TestUpdateTimestampSnapshotCerBellowCurrent(t *testing.T) {
 	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "new timestamp version 1 must be >= 2"})
 }
 
+func TestUpdateTimestampInvalidMeta(t *testing.T) {
+	// Test a timestamp with an extra meta entry besides snapshot.json
+	addTargetsMeta := func(timestamp *metadata.Metadata[metadata.TimestampType]) {
+		timestamp.Signed.Meta["targets.json"] = metadata.MetaFile(1)
+	}
+	timestamp, err := modifyTimestamptMetadata(addTargetsMeta)
+	assert.NoError(t, err)
+	trustedSet, err := New(allRoles[metadata.ROOT])
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateTimestamp(timestamp)
+	assert.ErrorIs(t, err, metadata.ErrInvalidTimestampMeta{Msg: "timestamp must only have meta for snapshot.json, got 2 entries"})
+	assert.ErrorIs(t, err, metadata.ErrRepository{})
+	assert.Nil(t, trustedSet.Timestamp)
+
+	// Test a timestamp without the snapshot.json entry
+	replaceSnapshotMeta := func(timestamp *metadata.Metadata[metadata.TimestampType]) {
+		timestamp.Signed.Meta["snapshots.json"] = timestamp.Signed.Meta["snapshot.json"]
+		delete(timestamp.Signed.Meta, "snapshot.json")
+	}
+	timestamp, err = modifyTimestamptMetadata(replaceSnapshotMeta)
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateTimestamp(timestamp)
+	assert.ErrorIs(t, err, metadata.ErrInvalidTimestampMeta{Msg: "timestamp has no meta for snapshot.json"})
+	assert.Nil(t, trustedSet.Timestamp)
+}
+
 func TestUpdateTimestampExpired(t *testing.T) {
 	// New timestamp has expired
 	modifyTimestampExpiry := func(timestamp *metadata.Metadata[metadata.TimestampType]) {
@@ -483,6 +701,38 @@ func TestUpdateSnapshotLengthOrHashMismatch(t *testing.T) {
 	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "length verification failed - expected 1, got 652"})
 }
 
+func TestUpdateTrustedSnapshotLengthOrHashMismatch(t *testing.T) {
+	modifySnapshotLength := func(timestamp *metadata.Metadata[metadata.TimestampType]) {
+		timestamp.Signed.Meta["snapshot.json"].Length = 1
+	}
+	// Set known snapshot.json length to 1
+	timestamp, err := modifyTimestamptMetadata(modifySnapshotLength)
+	assert.NoError(t, err)
+	trustedSet, err := New(allRoles[metadata.ROOT])
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateTimestamp(timestamp)
+	assert.NoError(t, err)
+	// Trusted data of the version committed to by timestamp is verified too
+	_, err = trustedSet.UpdateSnapshot(allRoles[metadata.SNAPSHOT], true)
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "length verification failed - expected 1, got 652"})
+	assert.Nil(t, trustedSet.Snapshot)
+
+	// ... but not if it is an older version kept for rollback protection
+	modifySnapshotVersion := func(timestamp *metadata.Metadata[metadata.TimestampType]) {
+		timestamp.Signed.Meta["snapshot.json"].Length = 1
+		timestamp.Signed.Meta["snapshot.json"].Version += 1
+	}
+	timestamp, err = modifyTimestamptMetadata(modifySnapshotVersion)
+	assert.NoError(t, err)
+	trustedSet, err = New(allRoles[metadata.ROOT])
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateTimestamp(timestamp)
+	assert.NoError(t, err)
+	_, err = trustedSet.UpdateSnapshot(allRoles[metadata.SNAPSHOT], true)
+	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "expected 2, got 1"})
+	assert.NotNil(t, trustedSet.Snapshot)
+}
+
 func TestUpdateSnapshotFailThreshholdVerification(t *testing.T) {
 	trustedSet, err := New(allRoles[metadata.ROOT])
 	assert.NoError(t, err)
@@ -699,3 +949,125 @@ func TestUpdateTargetsExpiredMewTarget(t *testing.T) {
 	_, err = trustedSet.UpdateTargets(targets)
 	assert.ErrorIs(t, err, metadata.ErrExpiredMetadata{Msg: "new targets is expired"})
 }
+
+func TestTargetsCache(t *testing.T) {
+	cache := NewTargetsCache()
+	newTrustedSet := func(snapshot []byte) *TrustedMetadata {
+		trustedSet, err := New(allRoles[metadata.ROOT])
+		assert.NoError(t, err)
+		trustedSet.TargetsCache = cache
+		_, err = trustedSet.UpdateTimestamp(allRoles[metadata.TIMESTAMP])
+		assert.NoError(t, err)
+		_, err = trustedSet.UpdateSnapshot(snapshot, false)
+		assert.NoError(t, err)
+		return trustedSet
+	}
+	loadAll := func(trustedSet *TrustedMetadata) {
+		_, err := trustedSet.UpdateTargets(allRoles[metadata.TARGETS])
+		assert.NoError(t, err)
+		_, err = trustedSet.UpdateDelegatedTargets(allRoles["role1"], "role1", metadata.TARGETS)
+		assert.NoError(t, err)
+		_, err = trustedSet.UpdateDelegatedTargets(allRoles["role2"], "role2", "role1")
+		assert.NoError(t, err)
+	}
+
+	// Test trusted sets with the cache get their own copy of the metadata
+	first := newTrustedSet(allRoles[metadata.SNAPSHOT])
+	loadAll(first)
+	assert.Equal(t, 3, cache.Len())
+	second := newTrustedSet(allRoles[metadata.SNAPSHOT])
+	loadAll(second)
+	assert.Equal(t, 3, cache.Len())
+	for _, role := range []string{metadata.TARGETS, "role1", "role2"} {
+		assert.NotSame(t, first.Targets[role], second.Targets[role])
+		assert.Equal(t, first.Targets[role], second.Targets[role])
+	}
+	second.Targets["role1"].Signed.Targets["modified.txt"] = metadata.TargetFile()
+	assert.NotContains(t, first.Targets["role1"].Signed.Targets, "modified.txt")
+
+	// Test a role verified with another delegation evicts the cached one
+	key, ok := first.targetsCacheKey("role1", metadata.TARGETS)
+	assert.True(t, ok)
+	digest := sha256.Sum256(allRoles["role1"])
+	version := first.Targets["role1"].Signed.Version
+	assert.True(t, cache.verified(key, version, digest))
+	otherKey := targetsCacheKey{role: "role1", delegation: sha256.Sum256([]byte("other delegation"))}
+	cache.add(otherKey, version, digest)
+	assert.Equal(t, 3, cache.Len())
+	assert.False(t, cache.verified(key, version, digest))
+	assert.True(t, cache.verified(otherKey, version, digest))
+	loadAll(newTrustedSet(allRoles[metadata.SNAPSHOT]))
+	assert.Equal(t, 3, cache.Len())
+	assert.True(t, cache.verified(key, version, digest))
+	assert.False(t, cache.verified(otherKey, version, digest))
+
+	// Test changed bytes are verified again
+	third := newTrustedSet(allRoles[metadata.SNAPSHOT])
+	_, err := third.UpdateTargets(append(bytes.Clone(allRoles[metadata.TARGETS]), '\n'))
+	assert.NoError(t, err)
+	assert.NotSame(t, first.Targets[metadata.TARGETS], third.Targets[metadata.TARGETS])
+	tampered, err := metadata.Targets().FromBytes(allRoles[metadata.TARGETS])
+	assert.NoError(t, err)
+	tampered.Signed.Targets["tampered.txt"] = metadata.TargetFile()
+	tamperedBytes, err := tampered.UnsafeToBytes(false)
+	assert.NoError(t, err)
+	_, err = newTrustedSet(allRoles[metadata.SNAPSHOT]).UpdateTargets(tamperedBytes)
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying targets failed, not enough signatures, got 0, want 1"})
+
+	// Test a new version replaces the cached one
+	targetsV2, err := modifyTargetsMetadata(func(targets *metadata.Metadata[metadata.TargetsType]) {
+		targets.Signed.Version = 2
+	})
+	assert.NoError(t, err)
+	snapshotV2, err := modifySnapshotMetadata(func(snapshot *metadata.Metadata[metadata.SnapshotType]) {
+		snapshot.Signed.Meta["targets.json"].Version = 2
+	})
+	assert.NoError(t, err)
+	fourth := newTrustedSet(snapshotV2)
+	_, err = fourth.UpdateTargets(targetsV2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), fourth.Targets[metadata.TARGETS].Signed.Version)
+	assert.Equal(t, 3, cache.Len())
+	fifth := newTrustedSet(allRoles[metadata.SNAPSHOT])
+	_, err = fifth.UpdateTargets(allRoles[metadata.TARGETS])
+	assert.NoError(t, err)
+	assert.NotSame(t, first.Targets[metadata.TARGETS], fifth.Targets[metadata.TARGETS])
+
+	// Test the cache doesn't skip the version checks against snapshot
+	_, err = newTrustedSet(snapshotV2).UpdateTargets(allRoles[metadata.TARGETS])
+	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "expected targets version 2, got 1"})
+}
+
+func BenchmarkUpdateDelegatedTargets(b *testing.B) {
+	for _, bench := range []struct {
+		name  string
+		cache *TargetsCache
+	}{
+		{name: "NoCache"},
+		{name: "Cache", cache: NewTargetsCache()},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			trustedSet, err := New(allRoles[metadata.ROOT])
+			if err != nil {
+				b.Fatal(err)
+			}
+			trustedSet.TargetsCache = bench.cache
+			if _, err := trustedSet.UpdateTimestamp(allRoles[metadata.TIMESTAMP]); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := trustedSet.UpdateSnapshot(allRoles[metadata.SNAPSHOT], false); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := trustedSet.UpdateTargets(allRoles[metadata.TARGETS]); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				delete(trustedSet.Targets, "role1")
+				if _, err := trustedSet.UpdateDelegatedTargets(allRoles["role1"], "role1", metadata.TARGETS); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
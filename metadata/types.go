@@ -43,6 +43,17 @@ type Metadata[T Roles] struct {
 	Signed             T              `json:"signed"`
 	Signatures         []Signature    `json:"signatures"`
 	UnrecognizedFields map[string]any `json:"-"`
+	// signedDigest is the digest of the canonical Signed payload that
+	// Signatures were created over or loaded with; nil if unknown
+	signedDigest []byte
+}
+
+// GenericMetadata represents the envelope of a TUF metadata of any type, see
+// LoadGeneric
+type GenericMetadata struct {
+	Type       string
+	Signed     json.RawMessage
+	Signatures []Signature
 }
 
 // Signature represents the Signature part of a TUF metadata
@@ -116,6 +127,45 @@ type Role struct {
 	UnrecognizedFields map[string]any `json:"-"`
 }
 
+// RoleDiff represents the key and threshold changes of a role between two
+// root metadata versions
+type RoleDiff struct {
+	AddedKeyIDs   []string
+	RemovedKeyIDs []string
+	OldThreshold  int
+	NewThreshold  int
+}
+
+// RootDiff represents the changes between two root metadata versions.
+// Roles only lists the roles which changed
+type RootDiff struct {
+	Roles map[string]*RoleDiff
+}
+
+// TargetsDiff lists the target paths added, removed and modified between two
+// targets metadata versions, each sorted
+type TargetsDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+// DelegationEdge is a delegation from the Parent targets role to the Child
+// role. For succinct roles Child is "<name_prefix>-*" and stands for all bins
+type DelegationEdge struct {
+	Parent           string
+	Child            string
+	Paths            []string
+	PathHashPrefixes []string
+	Terminating      bool
+}
+
+// DelegationGraph lists the delegations of the loaded targets metadata,
+// see NewDelegationGraph
+type DelegationGraph struct {
+	Edges []DelegationEdge
+}
+
 type HexBytes []byte
 
 type Hashes map[string]HexBytes
@@ -126,15 +176,24 @@ type MetaFiles struct {
 	Hashes             Hashes         `json:"hashes,omitempty"`
 	Version            int64          `json:"version"`
 	UnrecognizedFields map[string]any `json:"-"`
+	optionalLength
 }
 
 // TargetFiles represents the value portion of TARGETS in TUF (used Targets metadata). Used to store information about a particular target file.
 type TargetFiles struct {
-	Length             int64            `json:"length"`
+	Length             int64            `json:"length,omitempty"`
 	Hashes             Hashes           `json:"hashes"`
 	Custom             *json.RawMessage `json:"custom,omitempty"`
 	Path               string           `json:"-"`
 	UnrecognizedFields map[string]any   `json:"-"`
+	optionalLength
+}
+
+// optionalLength tells a length of zero which was explicitly committed to
+// apart from no length at all, the Length of MetaFiles and TargetFiles is
+// zero in both cases
+type optionalLength struct {
+	zeroLength bool
 }
 
 // Delegations is an optional object which represents delegation roles and their corresponding keys
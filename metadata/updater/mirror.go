@@ -0,0 +1,252 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package updater
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
+)
+
+// Mirror downloads and verifies every root version up to the trusted one,
+// the top-level metadata, all reachable delegated targets metadata and all
+// target files, and writes them under destDir using the repository layout:
+// metadata files in destDir/metadata and target files in destDir/targets.
+// With consistent snapshots, metadata is written as <version>.<role>.json
+// and target files are hash-prefixed (one copy per hash algorithm).
+// The downloaded metadata is verified starting from the first root version
+// and the final root must be the one trusted by the Updater. Any download or
+// verification failure aborts the mirror.
+// If Refresh() has not been called before, the refresh will be done implicitly.
+func (update *Updater) Mirror(destDir string) error {
+	log := metadata.GetLogger()
+
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	update.resetDownloadBudget()
+	update.resetOperationLimits()
+	if update.trusted.Targets[metadata.TARGETS] == nil {
+		err := update.refresh()
+		if err != nil {
+			return err
+		}
+	}
+	if update.cfg.RemoteTargetsURL == "" {
+		return metadata.ErrValue{Msg: "RemoteTargetsURL must be set to mirror target files"}
+	}
+	metadataDir := filepath.Join(destDir, "metadata")
+	targetsDir := filepath.Join(destDir, "targets")
+
+	// rebuild the root chain and verify it ends at the trusted root
+	mirrored, err := update.mirrorRoots(metadataDir)
+	if err != nil {
+		return err
+	}
+	consistentSnapshot := mirrored.Root.Signed.ConsistentSnapshot
+
+	// timestamp is never versioned
+	data, err := update.downloadMetadata(metadata.TIMESTAMP, update.cfg.TimestampMaxLength, "")
+	if err != nil {
+		return err
+	}
+	if _, err := mirrored.UpdateTimestamp(data); err != nil {
+		return err
+	}
+	if err := writeMirrorFile(filepath.Join(metadataDir, fmt.Sprintf("%s.json", metadata.TIMESTAMP)), data); err != nil {
+		return err
+	}
+
+	// snapshot, verified against the meta in timestamp
+	snapshotMeta := mirrored.Timestamp.Signed.Meta[fmt.Sprintf("%s.json", metadata.SNAPSHOT)]
+	data, err = update.mirrorMetadata(metadataDir, metadata.SNAPSHOT, snapshotMeta, update.cfg.SnapshotMaxLength, consistentSnapshot)
+	if err != nil {
+		return err
+	}
+	if _, err := mirrored.UpdateSnapshot(data, false); err != nil {
+		return err
+	}
+
+	// pre-order depth-first traversal of the whole delegation graph
+	delegationsToVisit := []roleParentTuple{{
+		Role:   metadata.TARGETS,
+		Parent: metadata.ROOT,
+	}}
+	visitedRoleNames := map[string]bool{}
+	mirroredTargets := map[string]bool{}
+	for len(visitedRoleNames) <= update.cfg.MaxDelegations && len(delegationsToVisit) > 0 {
+		delegation := delegationsToVisit[len(delegationsToVisit)-1]
+		delegationsToVisit = delegationsToVisit[:len(delegationsToVisit)-1]
+		// skip any visited current role to prevent cycles
+		if visitedRoleNames[delegation.Role] {
+			continue
+		}
+		visitedRoleNames[delegation.Role] = true
+		// targets metadata, verified against the meta in snapshot
+		metaInfo, ok := mirrored.Snapshot.Signed.Meta[fmt.Sprintf("%s.json", delegation.Role)]
+		if !ok {
+			return metadata.ErrRepository{Msg: fmt.Sprintf("snapshot does not contain information for %s", delegation.Role)}
+		}
+		data, err := update.mirrorMetadata(metadataDir, delegation.Role, metaInfo, update.cfg.TargetsMaxLength, consistentSnapshot)
+		if err != nil {
+			return err
+		}
+		targets, err := mirrored.UpdateDelegatedTargets(data, delegation.Role, delegation.Parent)
+		if err != nil {
+			return err
+		}
+		// target files listed by that role
+		err = update.mirrorTargets(targetsDir, targets, consistentSnapshot && update.cfg.PrefixTargetsWithHash, mirroredTargets)
+		if err != nil {
+			return err
+		}
+		if targets.Signed.Delegations != nil {
+			childRolesToVisit := []roleParentTuple{}
+			for _, child := range targets.Signed.Delegations.GetRoles() {
+				childRolesToVisit = append(childRolesToVisit, roleParentTuple{Role: child, Parent: delegation.Role})
+			}
+			reverseSlice(childRolesToVisit)
+			delegationsToVisit = append(delegationsToVisit, childRolesToVisit...)
+		}
+	}
+	if len(delegationsToVisit) > 0 {
+		return metadata.ErrValue{Msg: fmt.Sprintf("too many roles to mirror, %d left to visit for max allowed delegations %d", len(delegationsToVisit), update.cfg.MaxDelegations)}
+	}
+	log.Info("Mirrored repository", "path", destDir, "roles", len(visitedRoleNames), "targets", len(mirroredTargets))
+	return nil
+}
+
+// mirrorRoots downloads and writes every root version up to the trusted one
+// and returns a trusted metadata set built from that root chain
+func (update *Updater) mirrorRoots(metadataDir string) (*trustedmetadata.TrustedMetadata, error) {
+	var mirrored *trustedmetadata.TrustedMetadata
+	for version := int64(1); version <= update.trusted.Root.Signed.Version; version++ {
+		versionStr := strconv.FormatInt(version, 10)
+		data, err := update.downloadMetadata(metadata.ROOT, update.cfg.RootMaxLength, versionStr)
+		if err != nil {
+			return nil, err
+		}
+		if mirrored == nil {
+			mirrored, err = trustedmetadata.New(data)
+		} else {
+			_, err = mirrored.UpdateRoot(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = writeMirrorFile(filepath.Join(metadataDir, fmt.Sprintf("%s.%s.json", versionStr, metadata.ROOT)), data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the mirrored root chain must end with the root trusted by the updater
+	if err := update.trusted.Root.VerifyDelegate(metadata.ROOT, mirrored.Root); err != nil {
+		return nil, err
+	}
+	mirrored.RefTime = update.trusted.RefTime
+	return mirrored, nil
+}
+
+// mirrorMetadata downloads the metadata for roleName, checks it against the
+// length and hashes in metaInfo and writes it to metadataDir
+func (update *Updater) mirrorMetadata(metadataDir, roleName string, metaInfo *metadata.MetaFiles, maxLength int64, consistentSnapshot bool) ([]byte, error) {
+	length := metaInfo.Length
+	if !metaInfo.HasLength() {
+		length = maxLength
+	}
+	fileName := fmt.Sprintf("%s.json", url.QueryEscape(roleName))
+	version := ""
+	if consistentSnapshot {
+		version = strconv.FormatInt(metaInfo.Version, 10)
+		fileName = fmt.Sprintf("%s.%s", version, fileName)
+	}
+	if err := update.checkMetaHashes(fmt.Sprintf("%s.json", roleName), metaInfo); err != nil {
+		return nil, err
+	}
+	data, err := update.downloadMetadata(roleName, length, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := metaInfo.VerifyLengthHashes(data); err != nil {
+		return nil, err
+	}
+	return data, writeMirrorFile(filepath.Join(metadataDir, fileName), data)
+}
+
+// mirrorTargets downloads, verifies and writes all target files listed in
+// targets. Target files already in mirrored are skipped
+func (update *Updater) mirrorTargets(targetsDir string, targets *metadata.Metadata[metadata.TargetsType], prefixWithHash bool, mirrored map[string]bool) error {
+	targetBaseURL := ensureTrailingSlash(update.cfg.RemoteTargetsURL)
+	targetPaths := make([]string, 0, len(targets.Signed.Targets))
+	for targetPath := range targets.Signed.Targets {
+		targetPaths = append(targetPaths, targetPath)
+	}
+	sort.Strings(targetPaths)
+	for _, targetPath := range targetPaths {
+		targetFile := targets.Signed.Targets[targetPath]
+		if err := update.checkRequiredHashAlgorithms(targetPath, targetFile.Hashes); err != nil {
+			return err
+		}
+		if !filepath.IsLocal(filepath.FromSlash(targetPath)) {
+			return metadata.ErrValue{Msg: fmt.Sprintf("refusing to mirror target with non-local path %s", targetPath)}
+		}
+		fileNames := []string{targetPath}
+		if prefixWithHash {
+			// one hash-prefixed copy per hash algorithm
+			algorithms := make([]string, 0, len(targetFile.Hashes))
+			for algorithm := range targetFile.Hashes {
+				algorithms = append(algorithms, algorithm)
+			}
+			sort.Strings(algorithms)
+			fileNames = []string{}
+			for _, algorithm := range algorithms {
+				dirName, baseName := path.Split(targetPath)
+				fileNames = append(fileNames, fmt.Sprintf("%s%s.%s", dirName, hex.EncodeToString(targetFile.Hashes[algorithm]), baseName))
+			}
+		}
+		if len(fileNames) == 0 {
+			return metadata.ErrValue{Msg: fmt.Sprintf("target %s has no hashes", targetPath)}
+		}
+		if mirrored[fileNames[0]] {
+			continue
+		}
+		data, err := update.downloadFile(fmt.Sprintf("%s%s", targetBaseURL, fileNames[0]), update.targetFileLength(targetFile), update.cfg.TargetFileTimeout())
+		if err != nil {
+			return err
+		}
+		if err := update.verifyTargetFile(targetFile, data); err != nil {
+			return err
+		}
+		for _, fileName := range fileNames {
+			if err := writeMirrorFile(filepath.Join(targetsDir, filepath.FromSlash(fileName)), data); err != nil {
+				return err
+			}
+			mirrored[fileName] = true
+		}
+	}
+	return nil
+}
+
+// writeMirrorFile writes data to name, creating any missing parent directories
+func writeMirrorFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
@@ -12,23 +12,35 @@
 package updater
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 // Client update workflow implementation
@@ -53,9 +65,42 @@ import (
 //     target file is already locally cached.
 //   - DownloadTarget() downloads a target file and ensures it is
 //     verified correct by the metadata.
+//
+// An "Updater" is safe for concurrent use by multiple goroutines.
 type Updater struct {
 	trusted *trustedmetadata.TrustedMetadata
 	cfg     *config.UpdaterConfig
+	// mu guards the trusted metadata set and the local metadata cache
+	mu sync.RWMutex
+	// pending holds the metadata waiting to be persisted at the end of a
+	// refresh, it is nil outside of a refresh
+	pending []pendingMetadata
+	// report collects the steps of a refresh, it is nil outside of
+	// RefreshWithReport
+	report *RefreshReport
+	// delegatedDownloads counts the delegated targets metadata downloaded
+	// by the current operation, see MaxDelegatedDownloads
+	delegatedDownloads int
+}
+
+// Bounds of the backoff between retries of versioned metadata downloads,
+// see config.UpdaterConfig.VersionedMetadataRetryTimeout
+const (
+	versionedMetadataMinBackoff = 100 * time.Millisecond
+	versionedMetadataMaxBackoff = 2 * time.Second
+)
+
+// retryNow and retrySleep drive the retries of versioned metadata downloads,
+// they can be replaced in tests
+var (
+	retryNow   = time.Now
+	retrySleep = time.Sleep
+)
+
+// pendingMetadata is verified metadata for roleName not yet written to disk
+type pendingMetadata struct {
+	roleName string
+	data     []byte
 }
 
 type roleParentTuple struct {
@@ -63,29 +108,109 @@ type roleParentTuple struct {
 	Parent string
 }
 
+// DelegationVerification is the outcome of loading and verifying a
+// delegated targets role against its delegator
+type DelegationVerification struct {
+	Role   string
+	Parent string
+	// Err is nil if the role was successfully verified
+	Err error
+}
+
+// Refresh steps reported by RefreshWithReport
+const (
+	// RefreshStepLoadLocal is loading and verifying cached metadata
+	RefreshStepLoadLocal = "load-local"
+	// RefreshStepDownload is downloading metadata from the remote
+	RefreshStepDownload = "download"
+	// RefreshStepVerify is verifying downloaded metadata and loading it
+	// into the trusted metadata set
+	RefreshStepVerify = "verify"
+	// RefreshStepPersist is writing verified metadata to the local cache
+	RefreshStepPersist = "persist"
+)
+
+// RefreshStep is a step done for a role during a refresh, see
+// RefreshWithReport
+type RefreshStep struct {
+	Role string
+	// Step is one of the RefreshStep* constants
+	Step string
+	// Version is the version of the metadata involved, 0 if not known yet,
+	// e.g. when downloading the timestamp
+	Version int64
+	// Err is nil if the step succeeded
+	Err error
+}
+
+// RefreshReport lists the steps done during a refresh in order, including
+// the ones which failed without failing the refresh, e.g. cached metadata
+// which is no longer valid
+type RefreshReport struct {
+	Steps []RefreshStep
+	// Err is the error returned by the refresh, if any
+	Err error
+}
+
+// Failures returns the steps which failed
+func (report *RefreshReport) Failures() []RefreshStep {
+	failures := []RefreshStep{}
+	for _, step := range report.Steps {
+		if step.Err != nil {
+			failures = append(failures, step)
+		}
+	}
+	return failures
+}
+
+// TargetResolutionStep is a role consulted when resolving a target path,
+// see ExplainTargetResolution
+type TargetResolutionStep struct {
+	Role   string
+	Parent string
+	// Terminating is true if the delegation from Parent is terminating, so
+	// that no other roles are consulted if Role doesn't have the target
+	Terminating bool
+	// Loaded is false if the metadata for Role is not loaded yet, so Role
+	// and its delegations can't be consulted without downloading it
+	Loaded bool
+	// Found is true if Role has the target
+	Found bool
+}
+
 // New creates a new Updater instance and loads trusted root metadata
 func New(config *config.UpdaterConfig) (*Updater, error) {
 	// make sure the trusted root metadata and remote URL were provided
-	if len(config.LocalTrustedRoot) == 0 || len(config.RemoteMetadataURL) == 0 {
+	if (len(config.LocalTrustedRoot) == 0 && len(config.InitialRootURL) == 0) || len(config.RemoteMetadataURL) == 0 {
 		return nil, fmt.Errorf("no initial trusted root metadata or remote URL provided")
 	}
-	// create a new trusted metadata instance using the trusted root.json
-	trustedMetadataSet, err := trustedmetadata.New(config.LocalTrustedRoot)
-	if err != nil {
-		return nil, err
-	}
 	// create an updater instance
 	updater := &Updater{
-		cfg:     config,
-		trusted: trustedMetadataSet, // save trusted metadata set
+		cfg: config,
 	}
 	// ensure paths exist, doesn't do anything if caching is disabled
-	err = updater.cfg.EnsurePathsExist()
+	err := updater.cfg.EnsurePathsExist()
+	if err != nil {
+		return nil, err
+	}
+	// make the fetcher apply the allowed URL prefixes to redirects too
+	if f, ok := config.Fetcher.(interface{ SetAllowedURLPrefixes([]string) }); ok && len(config.AllowedURLPrefixes) > 0 {
+		f.SetAllowedURLPrefixes(config.AllowedURLPrefixes)
+	}
+	rootData, err := updater.loadInitialRoot()
+	if err != nil {
+		return nil, err
+	}
+	// create a new trusted metadata instance using the trusted root.json,
+	// which must not be older than expected
+	updater.trusted, err = trustedmetadata.NewWithMinRootVersion(rootData, config.MinTrustedRootVersion)
 	if err != nil {
 		return nil, err
 	}
+	updater.trusted.TargetsCache = config.TargetsCache
+	updater.trusted.SignatureVerificationWorkers = config.SignatureVerificationWorkers
 	// persist the initial root metadata to the local metadata folder
-	err = updater.persistMetadata(metadata.ROOT, updater.cfg.LocalTrustedRoot)
+	err = updater.persistMetadata(metadata.ROOT, rootData)
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +218,37 @@ func New(config *config.UpdaterConfig) (*Updater, error) {
 	return updater, nil
 }
 
+// loadInitialRoot returns the configured LocalTrustedRoot or, if there is
+// none, bootstraps it from the local cache or the pinned InitialRootURL
+func (update *Updater) loadInitialRoot() ([]byte, error) {
+	log := metadata.GetLogger()
+	if len(update.cfg.LocalTrustedRoot) > 0 {
+		return update.cfg.LocalTrustedRoot, nil
+	}
+	if !update.cfg.DisableLocalCache {
+		data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, metadata.ROOT), update.cfg.RootMaxLength)
+		if err == nil {
+			log.Info("Using cached root as initial trusted root")
+			return data, nil
+		}
+	}
+	// trust on first use, the downloaded root must match the pinned hash
+	digest, err := hex.DecodeString(update.cfg.InitialRootSHA256)
+	if err != nil || len(digest) != sha256.Size {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("invalid initial root sha256 %q", update.cfg.InitialRootSHA256)}
+	}
+	data, err := update.downloadFile(update.cfg.InitialRootURL, update.cfg.RootMaxLength, update.cfg.RoleTimeout(metadata.ROOT))
+	if err != nil {
+		return nil, err
+	}
+	rootMeta := &metadata.MetaFiles{Hashes: metadata.Hashes{"sha256": digest}}
+	if err := rootMeta.VerifyLengthHashes(data); err != nil {
+		return nil, err
+	}
+	log.Info("Downloaded initial trusted root", "url", update.cfg.InitialRootURL)
+	return data, nil
+}
+
 // Refresh loads and possibly refreshes top-level metadata.
 // Downloads, verifies, and loads metadata for the top-level roles in the
 // specified order (root -> timestamp -> snapshot -> targets) implementing
@@ -104,25 +260,80 @@ func New(config *config.UpdaterConfig) (*Updater, error) {
 // that happens on demand during GetTargetInfo(). However, if the
 // repository uses consistent snapshots (ref. https://theupdateframework.github.io/specification/latest/#consistent-snapshots),
 // then all metadata downloaded by the Updater will use the same consistent repository state.
+// If the refresh fails after the root update, the timestamp, snapshot and
+// targets metadata are neither trusted nor persisted and Refresh() can be retried.
+// The timestamp is always downloaded, but cached snapshot and targets
+// metadata that still match it are reused, so a refresh resumed after an
+// interrupted one only downloads the metadata that is missing or changed.
 //
 // If UnsafeLocalMode is set, no network interaction is performed, only
 // the cached files on disk are used. If the cached data is not complete,
 // this call will fail.
 func (update *Updater) Refresh() error {
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	update.resetDownloadBudget()
+	return update.refresh()
+}
+
+// RefreshWithReport works like Refresh, but also returns a report of the
+// steps done for each role, in order, so that a failed refresh can be
+// troubleshot. The returned error is the same as the report's Err
+func (update *Updater) RefreshWithReport() (*RefreshReport, error) {
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	update.report = &RefreshReport{Steps: []RefreshStep{}}
+	update.resetDownloadBudget()
+	err := update.refresh()
+	report := update.report
+	update.report = nil
+	report.Err = err
+	return report, err
+}
+
+// refresh is the lock-free implementation of Refresh. The caller must
+// hold update.mu for writing.
+func (update *Updater) refresh() error {
 	if update.cfg.UnsafeLocalMode {
-		return update.unsafeLocalRefresh()
+		checkpoint := update.trusted.Checkpoint()
+		err := update.unsafeLocalRefresh()
+		if err != nil {
+			update.trusted.Rollback(checkpoint)
+		}
+		return err
 	}
 	return update.onlineRefresh()
 }
 
 // onlineRefresh implements the TUF client workflow as described for
 // the Refresh function.
+// Every new root is persisted as soon as it is verified. The rest of the
+// refresh is done as a single transaction: if any of the timestamp,
+// snapshot or targets updates fail, the trusted metadata set is rolled back
+// to its state after the root update and none of the new metadata is persisted.
 func (update *Updater) onlineRefresh() error {
 	err := update.loadRoot()
 	if err != nil {
 		return err
 	}
-	err = update.loadTimestamp()
+	checkpoint := update.trusted.Checkpoint()
+	update.pending = []pendingMetadata{}
+	err = update.loadTopLevelMetadata()
+	if err == nil {
+		err = update.persistPendingMetadata()
+	}
+	update.pending = nil
+	if err != nil {
+		update.trusted.Rollback(checkpoint)
+		return err
+	}
+	return nil
+}
+
+// loadTopLevelMetadata loads the timestamp, snapshot and targets metadata
+// in that order
+func (update *Updater) loadTopLevelMetadata() error {
+	err := update.loadTimestamp()
 	if err != nil {
 		return err
 	}
@@ -146,37 +357,49 @@ func (update *Updater) unsafeLocalRefresh() error {
 	// Root is already loaded
 	// load timestamp
 	var p = filepath.Join(update.cfg.LocalMetadataDir, metadata.TIMESTAMP)
-	data, err := update.loadLocalMetadata(p)
+	data, err := update.loadLocalMetadata(p, update.cfg.TimestampMaxLength)
 	if err != nil {
+		update.reportStep(metadata.TIMESTAMP, RefreshStepLoadLocal, 0, err)
 		return err
 	}
-	_, err = update.trusted.UpdateTimestamp(data)
+	timestamp, err := update.trusted.UpdateTimestamp(data)
 	if err != nil {
+		update.reportStep(metadata.TIMESTAMP, RefreshStepLoadLocal, 0, err)
 		return err
 	}
+	update.reportStep(metadata.TIMESTAMP, RefreshStepLoadLocal, timestamp.Signed.Version, nil)
+	update.metadataLoaded(metadata.TIMESTAMP, timestamp.Signed.Version, data)
 
 	// load snapshot
 	p = filepath.Join(update.cfg.LocalMetadataDir, metadata.SNAPSHOT)
-	data, err = update.loadLocalMetadata(p)
+	data, err = update.loadLocalMetadata(p, update.cfg.SnapshotMaxLength)
 	if err != nil {
+		update.reportStep(metadata.SNAPSHOT, RefreshStepLoadLocal, 0, err)
 		return err
 	}
-	_, err = update.trusted.UpdateSnapshot(data, false)
+	snapshot, err := update.trusted.UpdateSnapshot(data, false)
 	if err != nil {
+		update.reportStep(metadata.SNAPSHOT, RefreshStepLoadLocal, 0, err)
 		return err
 	}
+	update.reportStep(metadata.SNAPSHOT, RefreshStepLoadLocal, snapshot.Signed.Version, nil)
+	update.metadataLoaded(metadata.SNAPSHOT, snapshot.Signed.Version, data)
 
 	// targets
 	p = filepath.Join(update.cfg.LocalMetadataDir, metadata.TARGETS)
-	data, err = update.loadLocalMetadata(p)
+	data, err = update.loadLocalMetadata(p, update.cfg.TargetsMaxLength)
 	if err != nil {
+		update.reportStep(metadata.TARGETS, RefreshStepLoadLocal, 0, err)
 		return err
 	}
 	// verify and load the new target metadata
-	_, err = update.trusted.UpdateDelegatedTargets(data, metadata.TARGETS, metadata.ROOT)
+	targets, err := update.trusted.UpdateDelegatedTargets(data, metadata.TARGETS, metadata.ROOT)
 	if err != nil {
+		update.reportStep(metadata.TARGETS, RefreshStepLoadLocal, 0, err)
 		return err
 	}
+	update.reportStep(metadata.TARGETS, RefreshStepLoadLocal, targets.Signed.Version, nil)
+	update.metadataLoaded(metadata.TARGETS, targets.Signed.Version, data)
 
 	return nil
 }
@@ -189,66 +412,341 @@ func (update *Updater) unsafeLocalRefresh() error {
 // As a side-effect this method downloads all the additional (delegated
 // targets) metadata it needs to return the target information.
 func (update *Updater) GetTargetInfo(targetPath string) (*metadata.TargetFiles, error) {
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	update.resetDownloadBudget()
+	update.resetOperationLimits()
 	// do a Refresh() in case there's no trusted targets.json yet
 	if update.trusted.Targets[metadata.TARGETS] == nil {
-		err := update.Refresh()
+		err := update.refresh()
 		if err != nil {
 			return nil, err
 		}
 	}
-	return update.preOrderDepthFirstWalk(targetPath)
+	return update.preOrderDepthFirstWalk(targetPath, nil)
+}
+
+// GetTargetInfoBestEffort works like GetTargetInfo, except that delegated
+// roles that fail to load or verify (e.g. because their metadata can't be
+// downloaded) are skipped instead of aborting the lookup, so that the target
+// can still be found in the remaining delegations. The skipped roles are
+// returned along with their errors, whether the target was found or not.
+// Note that this trusts a less trusted delegation for the target if a more
+// trusted one is unavailable, so GetTargetInfo should be preferred.
+func (update *Updater) GetTargetInfoBestEffort(targetPath string) (*metadata.TargetFiles, []DelegationVerification, error) {
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	update.resetDownloadBudget()
+	update.resetOperationLimits()
+	// do a Refresh() in case there's no trusted targets.json yet
+	if update.trusted.Targets[metadata.TARGETS] == nil {
+		err := update.refresh()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	skipped := []DelegationVerification{}
+	target, err := update.preOrderDepthFirstWalk(targetPath, &skipped)
+	return target, skipped, err
 }
 
-// DownloadTarget downloads the target file specified by targetFile
-func (update *Updater) DownloadTarget(targetFile *metadata.TargetFiles, filePath, targetBaseURL string) (string, []byte, error) {
+// SearchTargets returns the target paths matching the glob pattern, along
+// with their target information. The pattern uses the same syntax as the
+// delegation paths, so "*" does not match "/", e.g. "plugins/*" matches
+// "plugins/a.tar.gz" but not "plugins/a/b.tar.gz". Each target is resolved
+// like GetTargetInfo does, i.e. it is only returned from the most trusted role
+// that is delegated to provide it.
+// If Refresh() has not been called before calling SearchTargets(), the
+// refresh will be done implicitly.
+// As a side-effect this method downloads the delegated targets metadata of
+// all roles which could provide targets matching the pattern, up to
+// MaxDelegations roles.
+func (update *Updater) SearchTargets(pattern string) (map[string]*metadata.TargetFiles, error) {
 	log := metadata.GetLogger()
 
-	var err error
-	if filePath == "" {
-		filePath, err = update.generateTargetFilePath(targetFile)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("invalid target path pattern %s: %s", pattern, err)}
+	}
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	update.resetDownloadBudget()
+	update.resetOperationLimits()
+	// do a Refresh() in case there's no trusted targets.json yet
+	if update.trusted.Targets[metadata.TARGETS] == nil {
+		err := update.refresh()
 		if err != nil {
-			return "", nil, err
+			return nil, err
 		}
 	}
-	if targetBaseURL == "" {
-		if update.cfg.RemoteTargetsURL == "" {
-			return "", nil, metadata.ErrValue{Msg: "targetBaseURL must be set in either DownloadTarget() or the Updater struct"}
+	candidates := map[string]bool{}
+	delegationsToVisit := []roleParentTuple{{
+		Role:   metadata.TARGETS,
+		Parent: metadata.ROOT,
+	}}
+	visitedRoleNames := map[string]bool{}
+	// pre-order depth-first traversal of the delegations which could
+	// provide matching targets
+	for len(visitedRoleNames) <= update.cfg.MaxDelegations && len(delegationsToVisit) > 0 {
+		delegation := delegationsToVisit[len(delegationsToVisit)-1]
+		delegationsToVisit = delegationsToVisit[:len(delegationsToVisit)-1]
+		// skip any visited current role to prevent cycles
+		if visitedRoleNames[delegation.Role] {
+			continue
+		}
+		visitedRoleNames[delegation.Role] = true
+		targets, err := update.loadTargets(delegation.Role, delegation.Parent)
+		if err != nil {
+			return nil, err
+		}
+		for targetPath := range targets.Signed.Targets {
+			if ok, _ := path.Match(pattern, targetPath); ok {
+				candidates[targetPath] = true
+			}
+		}
+		if targets.Signed.Delegations != nil {
+			childRolesToVisit := []roleParentTuple{}
+			for _, child := range targets.Signed.Delegations.GetRoles() {
+				if !mayDelegateMatchingPaths(targets.Signed.Delegations, child, pattern) {
+					continue
+				}
+				childRolesToVisit = append(childRolesToVisit, roleParentTuple{Role: child, Parent: delegation.Role})
+			}
+			reverseSlice(childRolesToVisit)
+			delegationsToVisit = append(delegationsToVisit, childRolesToVisit...)
 		}
-		targetBaseURL = ensureTrailingSlash(update.cfg.RemoteTargetsURL)
-	} else {
-		targetBaseURL = ensureTrailingSlash(targetBaseURL)
 	}
-	targetFilePath := targetFile.Path
-	consistentSnapshot := update.trusted.Root.Signed.ConsistentSnapshot
-	if consistentSnapshot && update.cfg.PrefixTargetsWithHash {
-		hashes := ""
-		// get first hex value of hashes
-		for _, v := range targetFile.Hashes {
-			hashes = hex.EncodeToString(v)
-			break
+	if len(delegationsToVisit) > 0 {
+		log.Info("Too many roles left to visit for max allowed delegations",
+			"roles-left", len(delegationsToVisit),
+			"allowed-delegations", update.cfg.MaxDelegations)
+	}
+	// a role may list targets it is not delegated to provide or that are
+	// shadowed by a more trusted role, so resolve each candidate from the
+	// top. This follows the delegated paths of each candidate and may load
+	// roles the search didn't
+	res := map[string]*metadata.TargetFiles{}
+	for targetPath := range candidates {
+		target, err := update.resolveTarget(targetPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			log.Info("Skipping target not provided by a delegated role", "path", targetPath)
+			continue
+		}
+		res[targetPath] = target
+	}
+	return res, nil
+}
+
+// mayDelegateMatchingPaths reports whether roleName, delegated by
+// delegations, could be trusted to provide target paths matching pattern
+func mayDelegateMatchingPaths(delegations *metadata.Delegations, roleName, pattern string) bool {
+	for _, role := range delegations.Roles {
+		if role.Name != roleName {
+			continue
+		}
+		if len(role.Paths) == 0 {
+			// hash bin delegations may provide any target path
+			return len(role.PathHashPrefixes) > 0
 		}
-		dirName, baseName, ok := strings.Cut(targetFilePath, "/")
+		for _, rolePattern := range role.Paths {
+			if pathPatternsMayOverlap(rolePattern, pattern) {
+				return true
+			}
+		}
+		return false
+	}
+	// succinct roles delegate each target path to a single bin
+	return delegations.SuccinctRoles != nil
+}
+
+// pathPatternsMayOverlap reports whether some path could match both the
+// glob patterns a and b. Segments containing wildcards on both sides are
+// assumed to overlap
+func pathPatternsMayOverlap(a, b string) bool {
+	aParts := strings.Split(a, "/")
+	bParts := strings.Split(b, "/")
+	if len(aParts) != len(bParts) {
+		return false
+	}
+	for i := range aParts {
+		aLiteral := !strings.ContainsAny(aParts[i], "*?[\\")
+		bLiteral := !strings.ContainsAny(bParts[i], "*?[\\")
+		switch {
+		case aLiteral && bLiteral:
+			if aParts[i] != bParts[i] {
+				return false
+			}
+		case aLiteral:
+			if ok, _ := path.Match(bParts[i], aParts[i]); !ok {
+				return false
+			}
+		case bLiteral:
+			if ok, _ := path.Match(aParts[i], bParts[i]); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ExplainTargetResolution returns the roles that would be consulted, in
+// order, to resolve targetPath using only the already loaded targets
+// metadata and whether each has the target. It is meant for debugging
+// delegations and never downloads anything: the resolution stops going down
+// a delegation at a role that is not loaded yet. The trace ends with the
+// role having the target, if any. If NormalizeTargetPaths is set and the
+// target isn't found, the trace of the walk matching the normalized target
+// path follows, see GetTargetInfo.
+func (update *Updater) ExplainTargetResolution(targetPath string) []TargetResolutionStep {
+	update.mu.RLock()
+	defer update.mu.RUnlock()
+
+	trace, found := update.explainWalk(targetPath, false)
+	if !found && update.cfg.NormalizeTargetPaths {
+		normalizedTrace, _ := update.explainWalk(targetPath, true)
+		trace = append(trace, normalizedTrace...)
+	}
+	return trace
+}
+
+// explainWalk does a single walk for ExplainTargetResolution, see
+// walkDelegations, and returns its trace and whether the target was found
+func (update *Updater) explainWalk(targetPath string, normalized bool) ([]TargetResolutionStep, bool) {
+	trace := []TargetResolutionStep{}
+	delegationsToVisit := []TargetResolutionStep{{
+		Role:   metadata.TARGETS,
+		Parent: metadata.ROOT,
+	}}
+	visitedRoleNames := map[string]bool{}
+	// same pre-order depth-first traversal as walkDelegations
+	for len(visitedRoleNames) <= update.cfg.MaxDelegations && len(delegationsToVisit) > 0 {
+		step := delegationsToVisit[len(delegationsToVisit)-1]
+		delegationsToVisit = delegationsToVisit[:len(delegationsToVisit)-1]
+		if visitedRoleNames[step.Role] {
+			continue
+		}
+		visitedRoleNames[step.Role] = true
+		targets, ok := update.trusted.Targets[step.Role]
 		if !ok {
-			// <hash>.<target-name>
-			targetFilePath = fmt.Sprintf("%s.%s", hashes, dirName)
-		} else {
-			// <dir-prefix>/<hash>.<target-name>
-			targetFilePath = filepath.Join(dirName, fmt.Sprintf("%s.%s", hashes, baseName))
+			trace = append(trace, step)
+			continue
+		}
+		step.Loaded = true
+		_, step.Found = update.findTarget(targets, targetPath)
+		trace = append(trace, step)
+		if step.Found {
+			return trace, true
+		}
+		if targets.Signed.Delegations != nil {
+			childRolesToVisit := []TargetResolutionStep{}
+			roles := rolesForTarget(targets.Signed.Delegations, targetPath, normalized)
+			for _, child := range orderedRoleNames(targets.Signed.Delegations, roles) {
+				childRolesToVisit = append(childRolesToVisit, TargetResolutionStep{Role: child, Parent: step.Role, Terminating: roles[child]})
+				if roles[child] {
+					delegationsToVisit = []TargetResolutionStep{}
+					break
+				}
+			}
+			reverseSlice(childRolesToVisit)
+			delegationsToVisit = append(delegationsToVisit, childRolesToVisit...)
 		}
 	}
-	fullURL := fmt.Sprintf("%s%s", targetBaseURL, targetFilePath)
-	data, err := update.cfg.Fetcher.DownloadFile(fullURL, targetFile.Length, time.Second*15)
-	if err != nil {
-		return "", nil, err
+	return trace, false
+}
+
+// VerifyAllDelegations walks the whole delegation tree starting from the
+// top-level targets role, loading and verifying each delegated targets role
+// against its delegator. The walk is bounded by MaxDelegations. Roles that
+// fail verification are reported and their own delegations are not walked.
+// If Refresh() has not been called before, the refresh will be done implicitly.
+func (update *Updater) VerifyAllDelegations() ([]DelegationVerification, error) {
+	log := metadata.GetLogger()
+
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	update.resetDownloadBudget()
+	update.resetOperationLimits()
+	if update.trusted.Targets[metadata.TARGETS] == nil {
+		err := update.refresh()
+		if err != nil {
+			return nil, err
+		}
+	}
+	report := []DelegationVerification{}
+	delegationsToVisit := []roleParentTuple{{
+		Role:   metadata.TARGETS,
+		Parent: metadata.ROOT,
+	}}
+	visitedRoleNames := map[string]bool{}
+	// pre-order depth-first traversal of the whole delegation graph
+	for len(visitedRoleNames) <= update.cfg.MaxDelegations && len(delegationsToVisit) > 0 {
+		delegation := delegationsToVisit[len(delegationsToVisit)-1]
+		delegationsToVisit = delegationsToVisit[:len(delegationsToVisit)-1]
+		// skip any visited current role to prevent cycles
+		if visitedRoleNames[delegation.Role] {
+			continue
+		}
+		visitedRoleNames[delegation.Role] = true
+		targets, err := update.loadTargets(delegation.Role, delegation.Parent)
+		if delegation.Role != metadata.TARGETS {
+			report = append(report, DelegationVerification{Role: delegation.Role, Parent: delegation.Parent, Err: err})
+		}
+		if err != nil {
+			log.Info("Failed to verify delegated role", "role", delegation.Role, "error", err)
+			continue
+		}
+		if targets.Signed.Delegations != nil {
+			childRolesToVisit := []roleParentTuple{}
+			for _, child := range targets.Signed.Delegations.GetRoles() {
+				childRolesToVisit = append(childRolesToVisit, roleParentTuple{Role: child, Parent: delegation.Role})
+			}
+			reverseSlice(childRolesToVisit)
+			delegationsToVisit = append(delegationsToVisit, childRolesToVisit...)
+		}
 	}
-	err = targetFile.VerifyLengthHashes(data)
+	if len(delegationsToVisit) > 0 {
+		log.Info("Too many roles left to visit for max allowed delegations",
+			"roles-left", len(delegationsToVisit),
+			"allowed-delegations", update.cfg.MaxDelegations)
+	}
+	return report, nil
+}
+
+// DownloadTarget downloads the target file specified by targetFile.
+// If algorithms are given, only the hashes of those algorithms and of the
+// RequireHashAlgorithms are verified, e.g. to only check the strongest one of
+// a huge file
+func (update *Updater) DownloadTarget(targetFile *metadata.TargetFiles, filePath, targetBaseURL string, algorithms ...string) (string, []byte, error) {
+	log := metadata.GetLogger()
+
+	var err error
+	createDirs := false
+	if filePath == "" {
+		filePath, err = update.generateTargetFilePath(targetFile)
+		if err != nil {
+			return "", nil, err
+		}
+		createDirs = update.cfg.PreserveTargetDirs
+	}
+	data, err := update.DownloadTargetBytes(targetFile, targetBaseURL, algorithms...)
 	if err != nil {
 		return "", nil, err
 	}
 
 	// do not persist the target file if cache is disabled
 	if !update.cfg.DisableLocalCache {
-		err = os.WriteFile(filePath, data, 0644)
+		// serialize writes so concurrent downloads of the same target
+		// don't interleave
+		update.mu.Lock()
+		if createDirs {
+			err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+		}
+		if err == nil {
+			err = update.writeFileAtomic(filePath, data)
+		}
+		update.mu.Unlock()
 		if err != nil {
 			return "", nil, err
 		}
@@ -257,6 +755,77 @@ func (update *Updater) DownloadTarget(targetFile *metadata.TargetFiles, filePath
 	return filePath, data, nil
 }
 
+// DownloadTargetBytes downloads the target file specified by targetFile and
+// returns its bytes once its length and hashes are verified, without writing
+// anything to the local cache. algorithms restricts the verified hashes like
+// in DownloadTarget
+func (update *Updater) DownloadTargetBytes(targetFile *metadata.TargetFiles, targetBaseURL string, algorithms ...string) ([]byte, error) {
+	// an explicit targetBaseURL takes precedence over the configured mirrors
+	targetBaseURLs := []string{targetBaseURL}
+	if targetBaseURL == "" {
+		targetBaseURLs = []string{}
+		if update.cfg.RemoteTargetsURL != "" {
+			targetBaseURLs = append(targetBaseURLs, update.cfg.RemoteTargetsURL)
+		}
+		targetBaseURLs = append(targetBaseURLs, update.cfg.RemoteTargetsMirrorURLs...)
+		if len(targetBaseURLs) == 0 {
+			return nil, metadata.ErrValue{Msg: "targetBaseURL must be set in either DownloadTarget() or the Updater struct"}
+		}
+	}
+	err := update.checkRequiredHashAlgorithms(targetFile.Path, targetFile.Hashes)
+	if err != nil {
+		return nil, err
+	}
+	// fail early if a selected hash is not listed
+	_, err = targetFile.SelectHashes(algorithms...)
+	if err != nil {
+		return nil, err
+	}
+	targetFilePath := targetFile.Path
+	update.mu.RLock()
+	consistentSnapshot := update.trusted.Root.Signed.ConsistentSnapshot
+	update.mu.RUnlock()
+	if consistentSnapshot && update.cfg.PrefixTargetsWithHash {
+		algorithm := update.cfg.TargetHashPrefixAlgorithm
+		if algorithm == "" {
+			algorithm = "sha256"
+		}
+		hash, ok := targetFile.Hashes[algorithm]
+		if !ok {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("target %s has no %s hash to prefix its filename with", targetFile.Path, algorithm)}
+		}
+		// <hash>.<target-name> or <dir-prefix>/<hash>.<target-name>
+		dirName, baseName := path.Split(targetFilePath)
+		targetFilePath = fmt.Sprintf("%s%s.%s", dirName, hex.EncodeToString(hash), baseName)
+	}
+	return update.downloadTargetFromMirrors(targetFile, targetBaseURLs, targetFilePath, algorithms)
+}
+
+// downloadTargetFromMirrors tries each of targetBaseURLs in order and returns
+// the first downloaded target file that passes verification. If all of them
+// fail, the returned error joins the error of each attempt
+func (update *Updater) downloadTargetFromMirrors(targetFile *metadata.TargetFiles, targetBaseURLs []string, targetFilePath string, algorithms []string) ([]byte, error) {
+	log := metadata.GetLogger()
+
+	var errs []error
+	for _, targetBaseURL := range targetBaseURLs {
+		fullURL := fmt.Sprintf("%s%s", ensureTrailingSlash(targetBaseURL), targetFilePath)
+		data, err := update.downloadFile(fullURL, update.targetFileLength(targetFile), update.cfg.TargetFileTimeout())
+		if err == nil {
+			err = update.verifyTargetFile(targetFile, data, algorithms...)
+		}
+		if err == nil {
+			return data, nil
+		}
+		log.Info("Failed to download target from mirror", "url", fullURL, "err", err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, errors.Join(errs...)
+}
+
 // FindCachedTarget checks whether a local file is an up to date target
 func (update *Updater) FindCachedTarget(targetFile *metadata.TargetFiles, filePath string) (string, []byte, error) {
 	var err error
@@ -281,7 +850,10 @@ func (update *Updater) FindCachedTarget(targetFile *metadata.TargetFiles, filePa
 		return "", nil, nil
 	}
 	// verify if the length and hashes of this target file match the expected values
-	err = targetFile.VerifyLengthHashes(data)
+	err = update.checkRequiredHashAlgorithms(targetFile.Path, targetFile.Hashes)
+	if err == nil {
+		err = update.verifyTargetFile(targetFile, data)
+	}
 	if err != nil {
 		// do not want to return err, instead we say that there's no cached target available
 		return "", nil, nil
@@ -290,46 +862,86 @@ func (update *Updater) FindCachedTarget(targetFile *metadata.TargetFiles, filePa
 	return targetFilePath, data, nil
 }
 
+// VerifyCachedFile checks the local file at filePath against hashes and a
+// length known from another trusted source than TUF metadata, e.g. a
+// checksum file, the same way FindCachedTarget checks target files. Unlike
+// FindCachedTarget, a missing or mismatching file is an error. The file
+// content is returned if it matches
+func (update *Updater) VerifyCachedFile(filePath string, expected metadata.Hashes, length int64) ([]byte, error) {
+	if len(expected) == 0 {
+		return nil, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - no hashes to verify against"}
+	}
+	data, err := readFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	targetFile := metadata.TargetFile()
+	targetFile.Path = filePath
+	targetFile.Hashes = expected
+	targetFile.SetLength(length)
+	err = update.checkRequiredHashAlgorithms(filePath, expected)
+	if err == nil {
+		err = update.verifyTargetFile(targetFile, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // loadTimestamp load local and remote timestamp metadata
 func (update *Updater) loadTimestamp() error {
 	log := metadata.GetLogger()
 	// try to read local timestamp
-	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, metadata.TIMESTAMP))
+	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, metadata.TIMESTAMP), update.cfg.TimestampMaxLength)
 	if err != nil {
 		// this means there's no existing local timestamp so we should proceed downloading it without the need to UpdateTimestamp
 		log.Info("Local timestamp does not exist")
 	} else {
 		// local timestamp exists, let's try to verify it and load it to the trusted metadata set
-		_, err := update.trusted.UpdateTimestamp(data)
-		if err != nil {
+		timestamp, err := update.trusted.UpdateTimestamp(data)
+		if err == nil {
+			// all okay, local timestamp exists and it is valid, nevertheless proceed with downloading
+			// from remote as the timestamp is what tells whether the rest of the local metadata is current
+			log.Info("Local timestamp is valid")
+			update.reportStep(metadata.TIMESTAMP, RefreshStepLoadLocal, timestamp.Signed.Version, nil)
+			update.metadataLoaded(metadata.TIMESTAMP, timestamp.Signed.Version, data)
+		} else {
+			update.reportStep(metadata.TIMESTAMP, RefreshStepLoadLocal, 0, err)
 			if errors.Is(err, metadata.ErrRepository{}) {
 				// local timestamp is not valid, proceed downloading from remote; note that this error type includes several other subset errors
 				log.Info("Local timestamp is not valid")
+			} else if isDecodeError(err) {
+				// local timestamp is corrupt and could not be decoded, treat it as absent
+				log.Info("Local timestamp is corrupt", "err", err)
 			} else {
 				// another error
 				return err
 			}
 		}
-		log.Info("Local timestamp is valid")
-		// all okay, local timestamp exists and it is valid, nevertheless proceed with downloading from remote
 	}
 	// load from remote (whether local load succeeded or not)
 	data, err = update.downloadMetadata(metadata.TIMESTAMP, update.cfg.TimestampMaxLength, "")
+	update.reportStep(metadata.TIMESTAMP, RefreshStepDownload, 0, err)
 	if err != nil {
 		return err
 	}
 	// try to verify and load the newly downloaded timestamp
-	_, err = update.trusted.UpdateTimestamp(data)
+	timestamp, err := update.trusted.UpdateTimestamp(data)
 	if err != nil {
 		if errors.Is(err, metadata.ErrEqualVersionNumber{}) {
 			// if the new timestamp version is the same as current, discard the
 			// new timestamp; this is normal and it shouldn't raise any error
+			update.reportStep(metadata.TIMESTAMP, RefreshStepVerify, update.trusted.Timestamp.Signed.Version, nil)
 			return nil
 		} else {
 			// another error
+			update.reportStep(metadata.TIMESTAMP, RefreshStepVerify, 0, err)
 			return err
 		}
 	}
+	update.reportStep(metadata.TIMESTAMP, RefreshStepVerify, timestamp.Signed.Version, nil)
+	update.metadataLoaded(metadata.TIMESTAMP, timestamp.Signed.Version, data)
 	// proceed with persisting the new timestamp
 	err = update.persistMetadata(metadata.TIMESTAMP, data)
 	if err != nil {
@@ -341,19 +953,34 @@ func (update *Updater) loadTimestamp() error {
 // loadSnapshot load local (and if needed remote) snapshot metadata
 func (update *Updater) loadSnapshot() error {
 	log := metadata.GetLogger()
+	if update.trusted.Timestamp == nil {
+		return fmt.Errorf("trusted timestamp not set")
+	}
+	// extract the snapshot meta from the trusted timestamp metadata
+	snapshotMeta := update.trusted.Timestamp.Signed.Meta[fmt.Sprintf("%s.json", metadata.SNAPSHOT)]
+	// both local and remote snapshot are verified against the hashes in the
+	// meta, if any, so check first whether they are acceptable
+	err := update.checkMetaHashes(fmt.Sprintf("%s.json", metadata.SNAPSHOT), snapshotMeta)
+	if err != nil {
+		return err
+	}
 	// try to read local snapshot
-	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, metadata.SNAPSHOT))
+	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, metadata.SNAPSHOT), update.cfg.SnapshotMaxLength)
 	if err != nil {
 		// this means there's no existing local snapshot so we should proceed downloading it without the need to UpdateSnapshot
 		log.Info("Local snapshot does not exist")
 	} else {
 		// successfully read a local snapshot metadata, so let's try to verify and load it to the trusted metadata set
-		_, err = update.trusted.UpdateSnapshot(data, true)
+		snapshot, err := update.trusted.UpdateSnapshot(data, true)
 		if err != nil {
+			update.reportStep(metadata.SNAPSHOT, RefreshStepLoadLocal, snapshotMeta.Version, err)
 			// this means snapshot verification/loading failed
 			if errors.Is(err, metadata.ErrRepository{}) {
 				// local snapshot is not valid, proceed downloading from remote; note that this error type includes several other subset errors
 				log.Info("Local snapshot is not valid")
+			} else if isDecodeError(err) {
+				// local snapshot is corrupt and could not be decoded, treat it as absent
+				log.Info("Local snapshot is corrupt", "err", err)
 			} else {
 				// another error
 				return err
@@ -361,19 +988,16 @@ func (update *Updater) loadSnapshot() error {
 		} else {
 			// this means snapshot verification/loading succeeded
 			log.Info("Local snapshot is valid: not downloading new one")
+			update.reportStep(metadata.SNAPSHOT, RefreshStepLoadLocal, snapshot.Signed.Version, nil)
+			update.metadataLoaded(metadata.SNAPSHOT, snapshot.Signed.Version, data)
 			return nil
 		}
 	}
 	// local snapshot does not exist or is invalid, update from remote
 	log.Info("Failed to load local snapshot")
-	if update.trusted.Timestamp == nil {
-		return fmt.Errorf("trusted timestamp not set")
-	}
-	// extract the snapshot meta from the trusted timestamp metadata
-	snapshotMeta := update.trusted.Timestamp.Signed.Meta[fmt.Sprintf("%s.json", metadata.SNAPSHOT)]
 	// extract the length of the snapshot metadata to be downloaded
 	length := snapshotMeta.Length
-	if length == 0 {
+	if !snapshotMeta.HasLength() {
 		length = update.cfg.SnapshotMaxLength
 	}
 	// extract which snapshot version should be downloaded in case of consistent snapshots
@@ -382,15 +1006,19 @@ func (update *Updater) loadSnapshot() error {
 		version = strconv.FormatInt(snapshotMeta.Version, 10)
 	}
 	// download snapshot metadata
-	data, err = update.downloadMetadata(metadata.SNAPSHOT, length, version)
+	data, err = update.downloadVersionedMetadata(metadata.SNAPSHOT, length, version)
+	update.reportStep(metadata.SNAPSHOT, RefreshStepDownload, snapshotMeta.Version, err)
 	if err != nil {
 		return err
 	}
 	// verify and load the new snapshot
-	_, err = update.trusted.UpdateSnapshot(data, false)
+	snapshot, err := update.trusted.UpdateSnapshot(data, false)
 	if err != nil {
+		update.reportStep(metadata.SNAPSHOT, RefreshStepVerify, snapshotMeta.Version, err)
 		return err
 	}
+	update.reportStep(metadata.SNAPSHOT, RefreshStepVerify, snapshot.Signed.Version, nil)
+	update.metadataLoaded(metadata.SNAPSHOT, snapshot.Signed.Version, data)
 	// persist the new snapshot
 	err = update.persistMetadata(metadata.SNAPSHOT, data)
 	if err != nil {
@@ -399,7 +1027,10 @@ func (update *Updater) loadSnapshot() error {
 	return nil
 }
 
-// loadTargets load local (and if needed remote) metadata for roleName
+// loadTargets load local (and if needed remote) metadata for roleName.
+// It is only called by the delegation walks when roleName is needed, and the
+// loaded role is kept in the trusted metadata set, so the local metadata of
+// a role is read at most once per Updater
 func (update *Updater) loadTargets(roleName, parentName string) (*metadata.Metadata[metadata.TargetsType], error) {
 	log := metadata.GetLogger()
 	// avoid loading "roleName" more than once during "GetTargetInfo"
@@ -407,8 +1038,22 @@ func (update *Updater) loadTargets(roleName, parentName string) (*metadata.Metad
 	if ok {
 		return role, nil
 	}
+	if update.trusted.Snapshot == nil {
+		return nil, fmt.Errorf("trusted snapshot not set")
+	}
+	// extract the targets meta from the trusted snapshot metadata
+	metaInfo, ok := update.trusted.Snapshot.Signed.Meta[fmt.Sprintf("%s.json", roleName)]
+	if !ok {
+		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("snapshot does not contain information for %s", roleName)}
+	}
+	// both local and remote targets are verified against the hashes in the
+	// meta, if any, so check first whether they are acceptable
+	err := update.checkMetaHashes(fmt.Sprintf("%s.json", roleName), metaInfo)
+	if err != nil {
+		return nil, err
+	}
 	// try to read local targets
-	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, roleName))
+	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, roleName), update.cfg.TargetsMaxLength)
 	if err != nil {
 		// this means there's no existing local target file so we should proceed downloading it without the need to UpdateDelegatedTargets
 		log.Info("Local role does not exist", "role", roleName)
@@ -416,30 +1061,39 @@ func (update *Updater) loadTargets(roleName, parentName string) (*metadata.Metad
 		// successfully read a local targets metadata, so let's try to verify and load it to the trusted metadata set
 		delegatedTargets, err := update.trusted.UpdateDelegatedTargets(data, roleName, parentName)
 		if err != nil {
+			update.reportStep(roleName, RefreshStepLoadLocal, metaInfo.Version, err)
 			// this means targets verification/loading failed
 			if errors.Is(err, metadata.ErrRepository{}) {
 				// local target file is not valid, proceed downloading from remote; note that this error type includes several other subset errors
 				log.Info("Local role is not valid", "role", roleName)
+			} else if isDecodeError(err) {
+				// local target file is corrupt and could not be decoded, treat it as absent
+				log.Info("Local role is corrupt", "role", roleName, "err", err)
 			} else {
 				// another error
 				return nil, err
 			}
+		} else if err := update.checkPathPatterns(roleName, delegatedTargets); err != nil {
+			delete(update.trusted.Targets, roleName)
+			return nil, err
 		} else {
 			// this means targets verification/loading succeeded
 			log.Info("Local role is valid: not downloading new one", "role", roleName)
+			update.reportStep(roleName, RefreshStepLoadLocal, delegatedTargets.Signed.Version, nil)
+			update.metadataLoaded(roleName, delegatedTargets.Signed.Version, data)
 			return delegatedTargets, nil
 		}
 	}
 	// local "roleName" does not exist or is invalid, update from remote
 	log.Info("Failed to load local role", "role", roleName)
-	if update.trusted.Snapshot == nil {
-		return nil, fmt.Errorf("trusted snapshot not set")
+	if roleName != metadata.TARGETS && update.cfg.MaxDelegatedDownloads > 0 {
+		if update.delegatedDownloads >= update.cfg.MaxDelegatedDownloads {
+			return nil, metadata.ErrDelegatedDownloadsExceeded{Msg: fmt.Sprintf("not downloading %s, already downloaded %d delegated roles", roleName, update.delegatedDownloads)}
+		}
 	}
-	// extract the targets meta from the trusted snapshot metadata
-	metaInfo := update.trusted.Snapshot.Signed.Meta[fmt.Sprintf("%s.json", roleName)]
 	// extract the length of the target metadata to be downloaded
 	length := metaInfo.Length
-	if length == 0 {
+	if !metaInfo.HasLength() {
 		length = update.cfg.TargetsMaxLength
 	}
 	// extract which target metadata version should be downloaded in case of consistent snapshots
@@ -448,15 +1102,27 @@ func (update *Updater) loadTargets(roleName, parentName string) (*metadata.Metad
 		version = strconv.FormatInt(metaInfo.Version, 10)
 	}
 	// download targets metadata
-	data, err = update.downloadMetadata(roleName, length, version)
+	data, err = update.downloadVersionedMetadata(roleName, length, version)
+	update.reportStep(roleName, RefreshStepDownload, metaInfo.Version, err)
 	if err != nil {
 		return nil, err
 	}
+	if roleName != metadata.TARGETS {
+		update.delegatedDownloads++
+	}
 	// verify and load the new target metadata
 	delegatedTargets, err := update.trusted.UpdateDelegatedTargets(data, roleName, parentName)
 	if err != nil {
+		update.reportStep(roleName, RefreshStepVerify, metaInfo.Version, err)
+		return nil, err
+	}
+	if err := update.checkPathPatterns(roleName, delegatedTargets); err != nil {
+		delete(update.trusted.Targets, roleName)
+		update.reportStep(roleName, RefreshStepVerify, delegatedTargets.Signed.Version, err)
 		return nil, err
 	}
+	update.reportStep(roleName, RefreshStepVerify, delegatedTargets.Signed.Version, nil)
+	update.metadataLoaded(roleName, delegatedTargets.Signed.Version, data)
 	// persist the new target metadata
 	err = update.persistMetadata(roleName, data)
 	if err != nil {
@@ -465,6 +1131,32 @@ func (update *Updater) loadTargets(roleName, parentName string) (*metadata.Metad
 	return delegatedTargets, nil
 }
 
+// isDecodeError reports whether err comes from local metadata that could not
+// be decoded, i.e. malformed JSON or metadata of an unexpected type
+func isDecodeError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var unmarshalTypeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) ||
+		errors.As(err, &unmarshalTypeErr) ||
+		errors.Is(err, metadata.ErrMetadataType{})
+}
+
+// reportStep records a refresh step in the report, if any, see
+// RefreshWithReport
+func (update *Updater) reportStep(roleName, step string, version int64, err error) {
+	if update.report != nil {
+		update.report.Steps = append(update.report.Steps, RefreshStep{Role: roleName, Step: step, Version: version, Err: err})
+	}
+}
+
+// metadataLoaded calls the OnMetadataLoaded callback, if any, for
+// metadata that was just verified and loaded into the trusted set
+func (update *Updater) metadataLoaded(roleName string, version int64, data []byte) {
+	if update.cfg.OnMetadataLoaded != nil {
+		update.cfg.OnMetadataLoaded(roleName, version, data)
+	}
+}
+
 // loadRoot load remote root metadata. Sequentially load and
 // persist on local disk every newer root metadata version
 // available on the remote
@@ -480,21 +1172,30 @@ func (update *Updater) loadRoot() error {
 			// downloading the root metadata failed for some reason
 			var tmpErr metadata.ErrDownloadHTTP
 			if errors.As(err, &tmpErr) {
-				if tmpErr.StatusCode != http.StatusNotFound && tmpErr.StatusCode != http.StatusForbidden {
+				notFound := tmpErr.StatusCode == http.StatusNotFound ||
+					(tmpErr.StatusCode == http.StatusForbidden && update.cfg.RootForbiddenAsNotFound)
+				if !notFound {
 					// unexpected HTTP status code
+					update.reportStep(metadata.ROOT, RefreshStepDownload, nextVersion, err)
 					return err
 				}
-				// 404/403 means current root is newest available, so we can stop the loop and move forward
+				// 404 (or 403 if allowed) means current root is newest available, so we can stop the loop and move forward
 				break
 			}
-			// some other error ocurred
+			// some other error ocurred, e.g. metadata.ErrDownloadNetwork if the
+			// remote could not be reached. We can't tell whether a newer root
+			// exists, so don't move forward with the current one
+			update.reportStep(metadata.ROOT, RefreshStepDownload, nextVersion, err)
 			return err
 		} else {
+			update.reportStep(metadata.ROOT, RefreshStepDownload, nextVersion, nil)
 			// downloading root metadata succeeded, so let's try to verify and load it
-			_, err = update.trusted.UpdateRoot(data)
+			root, err := update.trusted.UpdateRoot(data)
+			update.reportStep(metadata.ROOT, RefreshStepVerify, nextVersion, err)
 			if err != nil {
 				return err
 			}
+			update.metadataLoaded(metadata.ROOT, root.Signed.Version, data)
 			// persist root metadata to disk
 			err = update.persistMetadata(metadata.ROOT, data)
 			if err != nil {
@@ -505,10 +1206,57 @@ func (update *Updater) loadRoot() error {
 	return nil
 }
 
+// checkPathPatterns verifies that no role delegated by the targets metadata
+// of roleName has more than MaxDelegatedPathPatterns paths or path hash
+// prefixes
+func (update *Updater) checkPathPatterns(roleName string, targets *metadata.Metadata[metadata.TargetsType]) error {
+	maxPatterns := update.cfg.MaxDelegatedPathPatterns
+	if maxPatterns <= 0 || targets.Signed.Delegations == nil {
+		return nil
+	}
+	for _, role := range targets.Signed.Delegations.Roles {
+		if patterns := len(role.Paths) + len(role.PathHashPrefixes); patterns > maxPatterns {
+			return metadata.ErrInvalidDelegation{Msg: fmt.Sprintf("role %s delegated by %s has %d path patterns, more than the maximum of %d", role.Name, roleName, patterns, maxPatterns)}
+		}
+	}
+	return nil
+}
+
 // preOrderDepthFirstWalk interrogates the tree of target delegations
 // in order of appearance (which implicitly order trustworthiness),
 // and returns the matching target found in the most trusted role.
-func (update *Updater) preOrderDepthFirstWalk(targetFilePath string) (*metadata.TargetFiles, error) {
+// If skipped is not nil, delegated roles that fail to load are appended
+// to it and skipped instead of failing the walk.
+// If NormalizeTargetPaths is set and the target isn't found, the walk is
+// repeated matching the normalized target path against the normalized
+// delegation path patterns, see metadata.Delegations.GetRolesForNormalizedTarget
+func (update *Updater) preOrderDepthFirstWalk(targetFilePath string, skipped *[]DelegationVerification) (*metadata.TargetFiles, error) {
+	target, err := update.resolveTarget(targetFilePath, skipped)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, fmt.Errorf("target %s not found", targetFilePath)
+	}
+	return target, nil
+}
+
+// resolveTarget walks the delegations for targetFilePath, walking them again
+// for the normalized target path if NormalizeTargetPaths is set. A nil
+// target and error are returned if the target is not found
+func (update *Updater) resolveTarget(targetFilePath string, skipped *[]DelegationVerification) (*metadata.TargetFiles, error) {
+	target, err := update.walkDelegations(targetFilePath, false, skipped)
+	if err == nil && target == nil && update.cfg.NormalizeTargetPaths {
+		target, err = update.walkDelegations(targetFilePath, true, skipped)
+	}
+	return target, err
+}
+
+// walkDelegations does a single pre-order depth-first walk for
+// preOrderDepthFirstWalk, matching delegations against the normalized form
+// of targetFilePath if normalized is set. A nil target and error are
+// returned if the target is not found
+func (update *Updater) walkDelegations(targetFilePath string, normalized bool, skipped *[]DelegationVerification) (*metadata.TargetFiles, error) {
 	log := metadata.GetLogger()
 	// list of delegations to be interrogated. A (role, parent role) pair
 	// is needed to load and verify the delegated targets metadata
@@ -532,9 +1280,17 @@ func (update *Updater) preOrderDepthFirstWalk(targetFilePath string) (*metadata.
 		// its targets, delegations, and child roles can be inspected
 		targets, err := update.loadTargets(delegation.Role, delegation.Parent)
 		if err != nil {
-			return nil, err
+			if skipped == nil || delegation.Role == metadata.TARGETS {
+				return nil, err
+			}
+			log.Info("Skipping role that failed to load", "role", delegation.Role, "err", err)
+			if !slices.ContainsFunc(*skipped, func(v DelegationVerification) bool { return v.Role == delegation.Role }) {
+				*skipped = append(*skipped, DelegationVerification{Role: delegation.Role, Parent: delegation.Parent, Err: err})
+			}
+			visitedRoleNames[delegation.Role] = true
+			continue
 		}
-		target, ok := targets.Signed.Targets[targetFilePath]
+		target, ok := update.findTarget(targets, targetFilePath)
 		if ok {
 			log.Info("Found target in current role", "role", delegation.Role)
 			return target, nil
@@ -545,8 +1301,9 @@ func (update *Updater) preOrderDepthFirstWalk(targetFilePath string) (*metadata.
 			childRolesToVisit := []roleParentTuple{}
 			// note that this may be a slow operation if there are many
 			// delegated roles
-			roles := targets.Signed.Delegations.GetRolesForTarget(targetFilePath)
-			for child, terminating := range roles {
+			roles := rolesForTarget(targets.Signed.Delegations, targetFilePath, normalized)
+			for _, child := range orderedRoleNames(targets.Signed.Delegations, roles) {
+				terminating := roles[child]
 				log.Info("Adding child role", "role", child)
 				childRolesToVisit = append(childRolesToVisit, roleParentTuple{Role: child, Parent: delegation.Role})
 				if terminating {
@@ -568,9 +1325,53 @@ func (update *Updater) preOrderDepthFirstWalk(targetFilePath string) (*metadata.
 			"allowed-delegations", update.cfg.MaxDelegations)
 	}
 	// if this point is reached then target is not found, return nil
-	return nil, fmt.Errorf("target %s not found", targetFilePath)
+	return nil, nil
 }
 
+// rolesForTarget returns the delegated roles trusted for targetPath, see
+// metadata.Delegations.GetRolesForTarget, matching its normalized form if
+// normalized is set
+func rolesForTarget(delegations *metadata.Delegations, targetPath string, normalized bool) map[string]bool {
+	if normalized {
+		return delegations.GetRolesForNormalizedTarget(targetPath)
+	}
+	return delegations.GetRolesForTarget(targetPath)
+}
+
+// findTarget returns the target file listed under targetPath in targets,
+// also matching the paths with the same normalized form if
+// NormalizeTargetPaths is set
+func (update *Updater) findTarget(targets *metadata.Metadata[metadata.TargetsType], targetPath string) (*metadata.TargetFiles, bool) {
+	if update.cfg.NormalizeTargetPaths {
+		return targets.Signed.FindTarget(targetPath)
+	}
+	target, ok := targets.Signed.Targets[targetPath]
+	return target, ok
+}
+
+// orderedRoleNames returns the names of roles in their order of appearance
+// in delegations, which is their order of trustworthiness
+func orderedRoleNames(delegations *metadata.Delegations, roles map[string]bool) []string {
+	names := make([]string, 0, len(roles))
+	if delegations.Roles != nil {
+		for _, role := range delegations.Roles {
+			if _, ok := roles[role.Name]; ok {
+				names = append(names, role.Name)
+			}
+		}
+		return names
+	}
+	// succinct roles delegate each target to a single bin
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// moveFile renames temporary files into place, it can be replaced in tests
+var moveFile = MoveFile
+
 // on windows, you can't rename a file across drives, so let's move instead
 func MoveFile(source, destination string) (err error) {
 	if runtime.GOOS == "windows" {
@@ -607,51 +1408,231 @@ func MoveFile(source, destination string) (err error) {
 
 // persistMetadata writes metadata to disk atomically to avoid data loss
 func (update *Updater) persistMetadata(roleName string, data []byte) error {
-	log := metadata.GetLogger()
 	// do not persist the metadata if we have disabled local caching
 	if update.cfg.DisableLocalCache {
 		return nil
 	}
+	// during a refresh, persist the metadata only once the refresh succeeds
+	if update.pending != nil {
+		update.pending = append(update.pending, pendingMetadata{roleName: roleName, data: data})
+		return nil
+	}
+	err := update.writeMetadata(roleName, data)
+	if update.report != nil {
+		version, _ := update.trustedVersion(roleName)
+		update.reportStep(roleName, RefreshStepPersist, version, err)
+	}
+	return err
+}
+
+// writeMetadata writes the metadata for roleName to the local metadata
+// directory atomically
+func (update *Updater) writeMetadata(roleName string, data []byte) error {
 	// caching enabled, proceed with persisting the metadata locally
 	fileName := filepath.Join(update.cfg.LocalMetadataDir, fmt.Sprintf("%s.json", url.QueryEscape(roleName)))
-	cwd, err := os.Getwd()
+	if update.cfg.CompressLocalMetadata {
+		fileName = fmt.Sprintf("%s.gz", fileName)
+		compressed, err := gzipBytes(data)
+		if err != nil {
+			return err
+		}
+		data = compressed
+	}
+	err := update.writeFileAtomic(fileName, data)
 	if err != nil {
 		return err
 	}
-	// create a temporary file
-	file, err := os.CreateTemp(cwd, "tuf_tmp")
+	read, err := os.ReadFile(fileName)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	// write the data content to the temporary file
-	err = os.WriteFile(file.Name(), data, 0644)
+	if string(read) != string(data) {
+		return metadata.ErrRuntime{Msg: fmt.Sprintf("failed to persist metadata for %s, read back content differs", roleName)}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to fileName through a temporary file created
+// in TempDir, or next to fileName if unset, which is then renamed into place
+func (update *Updater) writeFileAtomic(fileName string, data []byte) error {
+	log := metadata.GetLogger()
+	tempDir := update.cfg.TempDir
+	if tempDir == "" {
+		tempDir = filepath.Dir(fileName)
+	}
+	tempName, err := writeTempFile(tempDir, data)
 	if err != nil {
-		// delete the temporary file if there was an error while writing
-		errRemove := os.Remove(file.Name())
-		if errRemove != nil {
-			log.Info("Failed to delete temporary file", "name", file.Name())
-		}
 		return err
 	}
+	err = moveFile(tempName, fileName)
+	if errors.Is(err, syscall.EXDEV) {
+		// TempDir is on another device, so copy the temporary file next to
+		// the destination where it can be renamed
+		log.Info("Temporary file is on another device, copying it", "name", tempName, "destination", fileName)
+		err = copyAndMoveFile(tempName, fileName)
+	}
+	// the temporary file is left over if anything failed
+	if errRemove := os.Remove(tempName); errRemove != nil && !errors.Is(errRemove, fs.ErrNotExist) {
+		log.Info("Failed to delete temporary file", "name", tempName)
+	}
+	return err
+}
 
-	// can't move/rename an open file on windows, so close it first
-	file.Close()
-	// if all okay, rename the temporary file to the desired one
-	err = MoveFile(file.Name(), fileName)
+// copyAndMoveFile copies source to a temporary file next to destination and
+// renames it to destination. source is left in place
+func copyAndMoveFile(source, destination string) error {
+	data, err := os.ReadFile(source)
 	if err != nil {
 		return err
 	}
-	read, err := os.ReadFile(fileName)
+	tempName, err := writeTempFile(filepath.Dir(destination), data)
 	if err != nil {
 		return err
 	}
-	if string(read) != string(data) {
-		return fmt.Errorf("failed to persist metadata: %w", err)
+	err = moveFile(tempName, destination)
+	if err != nil {
+		os.Remove(tempName)
+	}
+	return err
+}
+
+// writeTempFile writes data to a new temporary file in dir and returns its
+// name. The file is removed if writing fails
+func writeTempFile(dir string, data []byte) (string, error) {
+	file, err := os.CreateTemp(dir, "tuf_tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	// can't move/rename an open file on windows, so close it before returning
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// persistPendingMetadata persists the metadata collected during a refresh
+// in the order it was verified
+func (update *Updater) persistPendingMetadata() error {
+	pending := update.pending
+	update.pending = nil
+	for _, md := range pending {
+		err := update.persistMetadata(md.roleName, md.data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the resources held by the Updater: any pending metadata
+// of an interrupted refresh is dropped and idle connections are closed if
+// the fetcher supports it, as the DefaultFetcher does. The Updater can still
+// be used afterwards. Close is safe to call multiple times
+func (update *Updater) Close() error {
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	update.pending = nil
+	update.report = nil
+	if f, ok := update.cfg.Fetcher.(interface{ CloseIdleConnections() }); ok {
+		f.CloseIdleConnections()
 	}
 	return nil
 }
 
+// PruneCache removes superseded versioned metadata files
+// (<version>.<role>.json) from the local metadata directory. A file is
+// removed only if its role is currently trusted and its version is older
+// than the trusted one. The unversioned <role>.json files and the
+// bootstrap root are never removed.
+func (update *Updater) PruneCache() error {
+	log := metadata.GetLogger()
+
+	update.mu.Lock()
+	defer update.mu.Unlock()
+	// nothing to prune if caching is disabled
+	if update.cfg.DisableLocalCache {
+		return nil
+	}
+	bootstrapRoot, err := metadata.Root().FromBytes(update.cfg.LocalTrustedRoot)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(update.cfg.LocalMetadataDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		version, roleName, ok := parseVersionedMetadataName(entry.Name())
+		if !ok {
+			continue
+		}
+		if roleName == metadata.ROOT && version == bootstrapRoot.Signed.Version {
+			continue
+		}
+		trustedVersion, ok := update.trustedVersion(roleName)
+		if !ok || version >= trustedVersion {
+			continue
+		}
+		err = os.Remove(filepath.Join(update.cfg.LocalMetadataDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		log.Info("Pruned stale metadata", "role", roleName, "version", version)
+	}
+	return nil
+}
+
+// trustedVersion returns the version of the trusted metadata for roleName
+func (update *Updater) trustedVersion(roleName string) (int64, bool) {
+	switch roleName {
+	case metadata.ROOT:
+		return update.trusted.Root.Signed.Version, true
+	case metadata.TIMESTAMP:
+		if update.trusted.Timestamp != nil {
+			return update.trusted.Timestamp.Signed.Version, true
+		}
+	case metadata.SNAPSHOT:
+		if update.trusted.Snapshot != nil {
+			return update.trusted.Snapshot.Signed.Version, true
+		}
+	default:
+		if targets, ok := update.trusted.Targets[roleName]; ok {
+			return targets.Signed.Version, true
+		}
+	}
+	return 0, false
+}
+
+// parseVersionedMetadataName splits a <version>.<role>.json file name
+// into its version and (unescaped) role name
+func parseVersionedMetadataName(name string) (int64, string, bool) {
+	base, ok := strings.CutSuffix(name, ".json")
+	if !ok {
+		return 0, "", false
+	}
+	versionStr, escapedRole, ok := strings.Cut(base, ".")
+	if !ok {
+		return 0, "", false
+	}
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	roleName, err := url.QueryUnescape(escapedRole)
+	if err != nil {
+		return 0, "", false
+	}
+	return version, roleName, true
+}
+
 // downloadMetadata download a metadata file and return it as bytes
 func (update *Updater) downloadMetadata(roleName string, length int64, version string) ([]byte, error) {
 	urlPath := ensureTrailingSlash(update.cfg.RemoteMetadataURL)
@@ -661,7 +1642,138 @@ func (update *Updater) downloadMetadata(roleName string, length int64, version s
 	} else {
 		urlPath = fmt.Sprintf("%s%s.%s.json", urlPath, version, url.QueryEscape(roleName))
 	}
-	return update.cfg.Fetcher.DownloadFile(urlPath, length, time.Second*15)
+	return update.downloadFile(urlPath, length, update.cfg.RoleTimeout(roleName))
+}
+
+// downloadVersionedMetadata works like downloadMetadata, but retries
+// downloading a versioned metadata file which is not found until the
+// VersionedMetadataRetryTimeout elapses, as the remote may not have caught
+// up with the trusted timestamp or snapshot yet
+func (update *Updater) downloadVersionedMetadata(roleName string, length int64, version string) ([]byte, error) {
+	log := metadata.GetLogger()
+	deadline := retryNow().Add(update.cfg.VersionedMetadataRetryTimeout)
+	backoff := versionedMetadataMinBackoff
+	for {
+		data, err := update.downloadMetadata(roleName, length, version)
+		var httpErr metadata.ErrDownloadHTTP
+		if err == nil || version == "" || !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+			return data, err
+		}
+		remaining := deadline.Sub(retryNow())
+		if remaining <= 0 {
+			return nil, err
+		}
+		backoff = min(backoff, remaining)
+		log.Info("Versioned metadata not found, retrying", "role", roleName, "version", version, "backoff", backoff)
+		retrySleep(backoff)
+		backoff = min(backoff*2, versionedMetadataMaxBackoff)
+	}
+}
+
+// downloadFile downloads urlPath, as rewritten by RewriteURL if set, with
+// the configured fetcher if it matches one of the AllowedURLPrefixes, if any
+func (update *Updater) downloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	if update.cfg.RewriteURL != nil {
+		rewritten, err := update.cfg.RewriteURL(urlPath)
+		if err != nil {
+			return nil, err
+		}
+		urlPath = rewritten
+	}
+	if !fetcher.URLAllowed(urlPath, update.cfg.AllowedURLPrefixes) {
+		return nil, metadata.ErrDownloadURLNotAllowed{URL: urlPath}
+	}
+	return update.cfg.Fetcher.DownloadFile(urlPath, maxLength, timeout)
+}
+
+// resetDownloadBudget resets the total download budget of the fetcher, if
+// it has one, e.g. a fetcher.BudgetFetcher
+func (update *Updater) resetDownloadBudget() {
+	if f, ok := update.cfg.Fetcher.(interface{ ResetBudget() }); ok {
+		f.ResetBudget()
+	}
+}
+
+// resetOperationLimits resets the limits applying to a single operation,
+// i.e. the count of downloaded delegated roles, see MaxDelegatedDownloads
+func (update *Updater) resetOperationLimits() {
+	update.delegatedDownloads = 0
+}
+
+// checkRequiredHashAlgorithms verifies that hashes for the file called name
+// include every algorithm listed in RequireHashAlgorithms
+func (update *Updater) checkRequiredHashAlgorithms(name string, hashes metadata.Hashes) error {
+	for _, algorithm := range update.cfg.RequireHashAlgorithms {
+		if _, ok := hashes[algorithm]; !ok {
+			return metadata.ErrLengthOrHashMismatch{Msg: fmt.Sprintf("%s is missing required hash algorithm %s", name, algorithm)}
+		}
+	}
+	return nil
+}
+
+// verifyTargetFile verifies data against the length and hashes of
+// targetFile, only using the AllowedHashAlgorithms hashes if any are set.
+// If algorithms are given, only their hashes are verified and they must all
+// be allowed. The RequireHashAlgorithms hashes are always verified
+func (update *Updater) verifyTargetFile(targetFile *metadata.TargetFiles, data []byte, algorithms ...string) error {
+	if len(algorithms) > 0 {
+		for _, algorithm := range algorithms {
+			if len(update.cfg.AllowedHashAlgorithms) > 0 && !slices.Contains(update.cfg.AllowedHashAlgorithms, algorithm) {
+				return metadata.ErrLengthOrHashMismatch{Msg: fmt.Sprintf("hash algorithm %s is not allowed to verify %s", algorithm, targetFile.Path)}
+			}
+		}
+		// the required hash algorithms are verified whatever the selection
+		selected := slices.Clone(algorithms)
+		for _, algorithm := range update.cfg.RequireHashAlgorithms {
+			if !slices.Contains(selected, algorithm) {
+				selected = append(selected, algorithm)
+			}
+		}
+		return targetFile.VerifyLengthHashes(data, selected...)
+	}
+	if len(update.cfg.AllowedHashAlgorithms) == 0 {
+		return targetFile.VerifyLengthHashes(data)
+	}
+	allowed := metadata.Hashes{}
+	for _, algorithm := range update.cfg.AllowedHashAlgorithms {
+		if digest, ok := targetFile.Hashes[algorithm]; ok {
+			allowed[algorithm] = digest
+		}
+	}
+	if len(allowed) == 0 {
+		return metadata.ErrLengthOrHashMismatch{Msg: fmt.Sprintf("%s has no hash of an allowed hash algorithm", targetFile.Path)}
+	}
+	for _, algorithm := range update.cfg.RequireHashAlgorithms {
+		if digest, ok := targetFile.Hashes[algorithm]; ok {
+			allowed[algorithm] = digest
+		}
+	}
+	restricted := *targetFile
+	restricted.Hashes = allowed
+	return restricted.VerifyLengthHashes(data)
+}
+
+// targetFileLength returns the maximum length to download for targetFile
+func (update *Updater) targetFileLength(targetFile *metadata.TargetFiles) int64 {
+	if !targetFile.HasLength() {
+		return update.cfg.TargetFileMaxLength
+	}
+	return targetFile.Length
+}
+
+// checkMetaHashes checks the hashes published for the metadata file called
+// name. Hashes are optional for metadata files: if none are published, the
+// metadata is only verified against the length, if any, and the version in
+// the meta, unless RequireMetaHashes is set. Published hashes must include
+// every algorithm listed in RequireHashAlgorithms and always have to match
+func (update *Updater) checkMetaHashes(name string, metaInfo *metadata.MetaFiles) error {
+	if metaInfo == nil || len(metaInfo.Hashes) == 0 {
+		if update.cfg.RequireMetaHashes {
+			return metadata.ErrLengthOrHashMismatch{Msg: fmt.Sprintf("no hashes published for %s", name)}
+		}
+		return nil
+	}
+	return update.checkRequiredHashAlgorithms(name, metaInfo.Hashes)
 }
 
 // generateTargetFilePath generates path from TargetFiles
@@ -670,23 +1782,151 @@ func (update *Updater) generateTargetFilePath(tf *metadata.TargetFiles) (string,
 	if update.cfg.LocalTargetsDir == "" && !update.cfg.DisableLocalCache {
 		return "", metadata.ErrValue{Msg: "LocalTargetsDir must be set if filepath is not given"}
 	}
+	if update.cfg.PreserveTargetDirs {
+		// Use the target path as a relative path, refusing any path that
+		// would end up outside of LocalTargetsDir
+		localPath := filepath.FromSlash(tf.Path)
+		if !filepath.IsLocal(localPath) {
+			return "", metadata.ErrValue{Msg: fmt.Sprintf("refusing to store target with non-local path %s", tf.Path)}
+		}
+		return filepath.Join(update.cfg.LocalTargetsDir, localPath), nil
+	}
 	// Use URL encoded target path as filename
 	return url.JoinPath(update.cfg.LocalTargetsDir, url.QueryEscape(tf.Path))
 }
 
-// loadLocalMetadata reads a local <roleName>.json file and returns its bytes
-func (update *Updater) loadLocalMetadata(roleName string) ([]byte, error) {
+// loadLocalMetadata reads a local <roleName>.json file and returns its bytes.
+// If CompressLocalMetadata is set, <roleName>.json.gz is decompressed, up to
+// maxLength bytes, and returned instead if it exists
+func (update *Updater) loadLocalMetadata(roleName string, maxLength int64) ([]byte, error) {
+	if update.cfg.CompressLocalMetadata {
+		data, err := readFile(fmt.Sprintf("%s.json.gz", roleName))
+		if err == nil {
+			return gunzipBytes(data, maxLength)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
 	return readFile(fmt.Sprintf("%s.json", roleName))
 }
 
 // GetTopLevelTargets returns the top-level target files
 func (update *Updater) GetTopLevelTargets() map[string]*metadata.TargetFiles {
+	update.mu.RLock()
+	defer update.mu.RUnlock()
 	return update.trusted.Targets[metadata.TARGETS].Signed.Targets
 }
 
-// GetTrustedMetadataSet returns the trusted metadata set
+// GetTrustedMetadataSet returns a copy of the trusted metadata set, which
+// is safe to read while the Updater loads more roles
 func (update *Updater) GetTrustedMetadataSet() trustedmetadata.TrustedMetadata {
-	return *update.trusted
+	update.mu.RLock()
+	defer update.mu.RUnlock()
+	trusted := *update.trusted
+	trusted.Targets = maps.Clone(update.trusted.Targets)
+	return trusted
+}
+
+// DelegatedTargets returns the loaded metadata of the targets role roleName
+// and the name of its delegator, i.e. root for the top-level targets role.
+// For a role delegated by more than one loaded role, the delegator found
+// first in a pre-order depth-first walk from the top-level targets role is
+// returned, which is the one a target lookup loads it through.
+// Errors out if the role hasn't been loaded, e.g. by GetTargetInfo()
+func (update *Updater) DelegatedTargets(roleName string) (*metadata.Metadata[metadata.TargetsType], string, error) {
+	update.mu.RLock()
+	defer update.mu.RUnlock()
+	role, ok := update.trusted.Targets[roleName]
+	if !ok {
+		return nil, "", metadata.ErrValue{Msg: fmt.Sprintf("targets role %s is not loaded", roleName)}
+	}
+	if roleName == metadata.TARGETS {
+		return role, metadata.ROOT, nil
+	}
+	delegator := findDelegator(update.trusted.Targets, metadata.TARGETS, roleName, map[string]bool{})
+	if delegator == "" {
+		return nil, "", metadata.ErrValue{Msg: fmt.Sprintf("no loaded delegator for targets role %s", roleName)}
+	}
+	return role, delegator, nil
+}
+
+// findDelegator returns the first loaded role delegating to roleName in a
+// pre-order depth-first walk starting from current, or "" if there is none
+func findDelegator(targets map[string]*metadata.Metadata[metadata.TargetsType], current, roleName string, visited map[string]bool) string {
+	delegator, ok := targets[current]
+	if !ok || visited[current] || delegator.Signed.Delegations == nil {
+		return ""
+	}
+	visited[current] = true
+	delegations := delegator.Signed.Delegations
+	if delegations.SuccinctRoles != nil {
+		if delegations.SuccinctRoles.IsDelegatedRole(roleName) {
+			return current
+		}
+		return ""
+	}
+	for _, child := range delegations.Roles {
+		if child.Name == roleName {
+			return current
+		}
+		if found := findDelegator(targets, child.Name, roleName, visited); found != "" {
+			return found
+		}
+	}
+	return ""
+}
+
+// ValidityRemaining returns the time left until expiry for each trusted
+// top-level role, relative to the reference time of the trusted metadata set.
+// Already expired roles have negative durations. Roles which are not loaded
+// yet are omitted
+func (update *Updater) ValidityRemaining() map[string]time.Duration {
+	update.mu.RLock()
+	defer update.mu.RUnlock()
+	refTime := update.trusted.RefTime
+	remaining := map[string]time.Duration{}
+	if update.trusted.Root != nil {
+		remaining[metadata.ROOT] = update.trusted.Root.Expires().Sub(refTime)
+	}
+	if update.trusted.Timestamp != nil {
+		remaining[metadata.TIMESTAMP] = update.trusted.Timestamp.Expires().Sub(refTime)
+	}
+	if update.trusted.Snapshot != nil {
+		remaining[metadata.SNAPSHOT] = update.trusted.Snapshot.Expires().Sub(refTime)
+	}
+	if targets, ok := update.trusted.Targets[metadata.TARGETS]; ok {
+		remaining[metadata.TARGETS] = targets.Expires().Sub(refTime)
+	}
+	return remaining
+}
+
+// ChangedTargetsRoles compares the meta of the trusted snapshot with the
+// one of prev, e.g. the snapshot trusted before the last Refresh(), and
+// returns the sorted names of the targets roles whose version increased,
+// including roles which are not in prev. Only the metadata of those roles
+// needs to be fetched again. A nil prev reports every role
+func (update *Updater) ChangedTargetsRoles(prev *metadata.Metadata[metadata.SnapshotType]) ([]string, error) {
+	update.mu.RLock()
+	defer update.mu.RUnlock()
+	if update.trusted.Snapshot == nil {
+		return nil, metadata.ErrValue{Msg: "trusted snapshot not set"}
+	}
+	changed := []string{}
+	for name, metaFile := range update.trusted.Snapshot.Signed.Meta {
+		roleName, ok := strings.CutSuffix(name, ".json")
+		if !ok {
+			continue
+		}
+		if prev != nil {
+			if prevMetaFile, ok := prev.Signed.Meta[name]; ok && prevMetaFile.Version >= metaFile.Version {
+				continue
+			}
+		}
+		changed = append(changed, roleName)
+	}
+	sort.Strings(changed)
+	return changed, nil
 }
 
 func IsWindowsPath(path string) bool {
@@ -716,6 +1956,37 @@ func reverseSlice[S ~[]E, E any](s S) {
 	}
 }
 
+// gzipBytes compresses data with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipBytes decompresses gzipped data, failing if it decompresses to more
+// than maxLength bytes
+func gunzipBytes(data []byte, maxLength int64) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decompressed)) > maxLength {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("decompressed metadata is larger than %d bytes", maxLength)}
+	}
+	return decompressed, nil
+}
+
 // readFile reads the content of a file and return its bytes
 func readFile(name string) ([]byte, error) {
 	in, err := os.Open(name)
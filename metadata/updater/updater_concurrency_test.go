@@ -0,0 +1,150 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package updater
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
+	simulator "github.com/rdimitrov/go-tuf-metadata/testutils/simulator"
+)
+
+func TestConcurrentGetTargetInfoAndDownloadTarget(t *testing.T) {
+	// Test that many goroutines can resolve and download overlapping and
+	// distinct targets through the same Updater (run with -race)
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+
+	target := metadata.Targets(simulator.Sim.SafeExpiry)
+	for _, name := range []string{"role1", "role2"} {
+		delegatedRole := metadata.DelegatedRole{
+			Name:        name,
+			KeyIDs:      []string{},
+			Threshold:   1,
+			Terminating: false,
+			Paths:       []string{fmt.Sprintf("%s/files/*", name)},
+		}
+		simulator.Sim.AddDelegation(metadata.TARGETS, delegatedRole, target.Signed)
+	}
+	targetPaths := []string{}
+	for _, name := range []string{"role1", "role2"} {
+		for i := 0; i < 4; i++ {
+			path := fmt.Sprintf("%s/files/file%d.txt", name, i)
+			simulator.Sim.AddTarget(name, []byte(path), path)
+			targetPaths = append(targetPaths, path)
+		}
+	}
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	// the simulator serves nested targets without hash prefixes
+	simulator.Sim.PrefixTargetsWithHash = false
+	updaterConfig.PrefixTargetsWithHash = false
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+
+	targetsURL := filepath.Join(simulator.Sim.LocalDir, "targets")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			info, err := updater.GetTargetInfo(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			filePath := filepath.Join(updaterConfig.LocalTargetsDir, fmt.Sprintf("%d", i))
+			_, data, err := updater.DownloadTarget(info, filePath, targetsURL)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(path), data)
+		}(i, targetPaths[i%len(targetPaths)])
+	}
+	wg.Wait()
+
+	trusted := updater.GetTrustedMetadataSet()
+	assert.Contains(t, trusted.Targets, "role1")
+	assert.Contains(t, trusted.Targets, "role2")
+
+	// the returned set doesn't share the map of loaded roles
+	delete(trusted.Targets, "role1")
+	assert.Contains(t, updater.GetTrustedMetadataSet().Targets, "role1")
+}
+
+func TestConcurrentUpdatersSharingTargetsCache(t *testing.T) {
+	// Test that Updaters created concurrently, e.g. one per request in a
+	// server, can share a TargetsCache (run with -race)
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	for _, name := range []string{"role1", "role2"} {
+		addDelegatedRole(metadata.TARGETS, name, 1, []string{fmt.Sprintf("%s/*", name)})
+		path := fmt.Sprintf("%s/file.txt", name)
+		simulator.Sim.AddTarget(name, []byte(path), path)
+	}
+	simulator.Sim.UpdateSnapshot()
+
+	cache := trustedmetadata.NewTargetsCache()
+	newUpdater := func(localMetadataDir string) *Updater {
+		updaterConfig, err := loadUpdaterConfig()
+		assert.NoError(t, err)
+		updaterConfig.LocalMetadataDir = localMetadataDir
+		updaterConfig.TargetsCache = cache
+		updater, err := New(updaterConfig)
+		assert.NoError(t, err)
+		return updater
+	}
+	dirs := []string{}
+	for i := 0; i < 16; i++ {
+		dirs = append(dirs, t.TempDir())
+	}
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func(i int, dir string) {
+			defer wg.Done()
+			updater := newUpdater(dir)
+			if updater == nil {
+				return
+			}
+			path := fmt.Sprintf("role%d/file.txt", i%2+1)
+			info, err := updater.GetTargetInfo(path)
+			if assert.NoError(t, err) {
+				assert.Equal(t, path, info.Path)
+			}
+		}(i, dir)
+	}
+	wg.Wait()
+	assert.Equal(t, 3, cache.Len())
+
+	// later updaters reuse the verification but get their own metadata
+	first, second := newUpdater(t.TempDir()), newUpdater(t.TempDir())
+	for _, updater := range []*Updater{first, second} {
+		_, err = updater.GetTargetInfo("role1/file.txt")
+		assert.NoError(t, err)
+	}
+	for _, role := range []string{metadata.TARGETS, "role1"} {
+		assert.NotSame(t, first.GetTrustedMetadataSet().Targets[role], second.GetTrustedMetadataSet().Targets[role])
+		assert.Equal(t, first.GetTrustedMetadataSet().Targets[role], second.GetTrustedMetadataSet().Targets[role])
+	}
+	assert.Equal(t, 3, cache.Len())
+}
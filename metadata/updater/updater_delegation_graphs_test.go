@@ -0,0 +1,782 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/maps"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	simulator "github.com/rdimitrov/go-tuf-metadata/testutils/simulator"
+)
+
+// addDelegatedRole adds a delegation from delegator to a new empty targets
+// role called name. The delegated role can itself delegate further.
+func addDelegatedRole(delegator, name string, threshold int, paths []string) {
+	targets := metadata.Targets(simulator.Sim.SafeExpiry)
+	targets.Signed.Delegations = &metadata.Delegations{
+		Keys:  map[string]*metadata.Key{},
+		Roles: []metadata.DelegatedRole{},
+	}
+	delegatedRole := metadata.DelegatedRole{
+		Name:        name,
+		KeyIDs:      []string{},
+		Threshold:   threshold,
+		Terminating: false,
+		Paths:       paths,
+	}
+	simulator.Sim.AddDelegation(delegator, delegatedRole, targets.Signed)
+}
+
+func TestVerifyAllDelegations(t *testing.T) {
+	// Test that the whole delegation tree is verified:
+	//   targets -> role1 -> role3
+	//   targets -> role2 (requires two signatures but has only one)
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*"})
+	addDelegatedRole(metadata.TARGETS, "role2", 2, []string{"*"})
+	addDelegatedRole("role1", "role3", 1, []string{"*"})
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	report, err := updater.VerifyAllDelegations()
+	assert.NoError(t, err)
+	assert.Len(t, report, 3)
+
+	assert.Equal(t, "role1", report[0].Role)
+	assert.Equal(t, metadata.TARGETS, report[0].Parent)
+	assert.NoError(t, report[0].Err)
+
+	assert.Equal(t, "role3", report[1].Role)
+	assert.Equal(t, "role1", report[1].Parent)
+	assert.NoError(t, report[1].Err)
+
+	assert.Equal(t, "role2", report[2].Role)
+	assert.Equal(t, metadata.TARGETS, report[2].Parent)
+	assert.ErrorIs(t, report[2].Err, metadata.ErrUnsignedMetadata{Msg: "Verifying role2 failed, not enough signatures, got 1, want 2"})
+}
+
+func TestGetTargetInfoBestEffort(t *testing.T) {
+	// Test that a broken delegation is skipped in best-effort mode:
+	//   targets -> broken (requires two signatures but has only one)
+	//   targets -> sibling (has the target)
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "broken", 2, []string{"*"})
+	addDelegatedRole(metadata.TARGETS, "sibling", 1, []string{"*"})
+	simulator.Sim.AddTarget("sibling", []byte("sibling target"), "file.txt")
+	simulator.Sim.UpdateSnapshot()
+	brokenErr := metadata.ErrUnsignedMetadata{Msg: "Verifying broken failed, not enough signatures, got 1, want 2"}
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+
+	// Test the default lookup fails fast on the broken delegation
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	_, err = updater.GetTargetInfo("file.txt")
+	assert.ErrorIs(t, err, brokenErr)
+
+	// Test the best-effort lookup finds the target in the sibling
+	updater = initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	target, skipped, err := updater.GetTargetInfoBestEffort("file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "file.txt", target.Path)
+	if assert.Len(t, skipped, 1) {
+		assert.Equal(t, "broken", skipped[0].Role)
+		assert.Equal(t, metadata.TARGETS, skipped[0].Parent)
+		assert.ErrorIs(t, skipped[0].Err, brokenErr)
+	}
+
+	// Test the skipped roles are reported if the target is not found
+	_, skipped, err = updater.GetTargetInfoBestEffort("missing.txt")
+	assert.ErrorContains(t, err, "target missing.txt not found")
+	assert.Len(t, skipped, 1)
+}
+
+func TestDelegationsOrderOfAppearance(t *testing.T) {
+	// Test that sibling delegations are interrogated in their order of
+	// appearance, not in the order of the map of matching roles:
+	//   targets -> zeta, alpha, mid (all have file.txt)
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	for _, role := range []string{"zeta", "alpha", "mid"} {
+		addDelegatedRole(metadata.TARGETS, role, 1, []string{"*"})
+		simulator.Sim.AddTarget(role, []byte(role+" target"), "file.txt")
+	}
+	simulator.Sim.UpdateSnapshot()
+	expected := simulator.Sim.MDDelegates["zeta"].Signed.Targets["file.txt"].Hashes
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		updater := initUpdater(updaterConfig)
+		if updater == nil {
+			t.Fatal("updater is nil")
+		}
+		target, err := updater.GetTargetInfo("file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, target.Hashes)
+		assert.Nil(t, updater.GetTrustedMetadataSet().Targets["alpha"])
+	}
+}
+
+func TestExplainTargetResolution(t *testing.T) {
+	// Test the resolution trace for the delegation tree:
+	//   targets -> role1 (*/*) -> role2 (docs/*, has docs/file.txt)
+	//   targets -> role3 (*/*)
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*/*"})
+	addDelegatedRole("role1", "role2", 1, []string{"docs/*"})
+	addDelegatedRole(metadata.TARGETS, "role3", 1, []string{"*/*"})
+	simulator.Sim.AddTarget("role2", []byte("nested target"), "docs/file.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	// nothing is loaded before a refresh
+	assert.Equal(t, []TargetResolutionStep{
+		{Role: metadata.TARGETS, Parent: metadata.ROOT},
+	}, updater.ExplainTargetResolution("docs/file.txt"))
+
+	_, err = updater.GetTargetInfo("docs/file.txt")
+	assert.NoError(t, err)
+	fetchCount := len(simulator.Sim.FetchTracker.Metadata)
+
+	// Test a path covered by the nested delegation
+	assert.Equal(t, []TargetResolutionStep{
+		{Role: metadata.TARGETS, Parent: metadata.ROOT, Loaded: true},
+		{Role: "role1", Parent: metadata.TARGETS, Loaded: true},
+		{Role: "role2", Parent: "role1", Loaded: true, Found: true},
+	}, updater.ExplainTargetResolution("docs/file.txt"))
+
+	// Test a path not covered by the nested delegation, role3 is not loaded
+	assert.Equal(t, []TargetResolutionStep{
+		{Role: metadata.TARGETS, Parent: metadata.ROOT, Loaded: true},
+		{Role: "role1", Parent: metadata.TARGETS, Loaded: true},
+		{Role: "role3", Parent: metadata.TARGETS},
+	}, updater.ExplainTargetResolution("other/file.txt"))
+
+	// Test nothing was downloaded
+	assert.Len(t, simulator.Sim.FetchTracker.Metadata, fetchCount)
+}
+
+func TestSearchTargets(t *testing.T) {
+	// Test that matching targets are found across the delegations:
+	//   targets: plugins/a.tar.gz, README.md
+	//   targets -> plugins (plugins/*, plugins/*/*): plugins/a.tar.gz, plugins/b.tar.gz, other.txt
+	//   targets -> docs (docs/*): docs/index.html
+	//   plugins -> extras (plugins/*/*): plugins/extras/c.tar.gz
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "plugins", 1, []string{"plugins/*", "plugins/*/*"})
+	addDelegatedRole(metadata.TARGETS, "docs", 1, []string{"docs/*"})
+	addDelegatedRole("plugins", "extras", 1, []string{"plugins/*/*"})
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("top-level a"), "plugins/a.tar.gz")
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("readme"), "README.md")
+	simulator.Sim.AddTarget("plugins", []byte("delegated a"), "plugins/a.tar.gz")
+	simulator.Sim.AddTarget("plugins", []byte("delegated b"), "plugins/b.tar.gz")
+	simulator.Sim.AddTarget("plugins", []byte("not delegated"), "other.txt")
+	simulator.Sim.AddTarget("docs", []byte("docs"), "docs/index.html")
+	simulator.Sim.AddTarget("extras", []byte("extras c"), "plugins/extras/c.tar.gz")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	targetFile := func(data []byte) *metadata.TargetFiles {
+		targetFile, err := metadata.TargetFile().FromBytes("", data)
+		assert.NoError(t, err)
+		return targetFile
+	}
+	found, err := updater.SearchTargets("plugins/*")
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	// the top-level targets role is more trusted than the delegated role
+	assert.Equal(t, targetFile([]byte("top-level a")).Hashes, found["plugins/a.tar.gz"].Hashes)
+	assert.Equal(t, targetFile([]byte("delegated b")).Hashes, found["plugins/b.tar.gz"].Hashes)
+	// roles which can't provide matching targets are not loaded
+	trusted := updater.GetTrustedMetadataSet()
+	assert.NotNil(t, trusted.Targets["plugins"])
+	assert.Nil(t, trusted.Targets["docs"])
+	assert.Nil(t, trusted.Targets["extras"])
+
+	found, err = updater.SearchTargets("plugins/*/*.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"plugins/extras/c.tar.gz"}, maps.Keys(found))
+	assert.NotNil(t, updater.GetTrustedMetadataSet().Targets["extras"])
+
+	// other.txt is listed by plugins which isn't delegated to provide it
+	found, err = updater.SearchTargets("*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"README.md"}, maps.Keys(found))
+
+	found, err = updater.SearchTargets("unknown/*")
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+
+	_, err = updater.SearchTargets("plugins/[")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "invalid target path pattern plugins/[: syntax error in pattern"})
+}
+
+func TestSearchTargetsResolutionError(t *testing.T) {
+	// Test that an error resolving a candidate fails the search:
+	//   targets -> skipped (a*.txt): file1.txt, which it isn't delegated
+	//   targets -> failing (*.txt), fails to download
+	// The search stops after skipped, while resolving file1.txt skips the
+	// skipped role and loads failing instead
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "skipped", 1, []string{"a*.txt"})
+	addDelegatedRole(metadata.TARGETS, "failing", 1, []string{"*.txt"})
+	simulator.Sim.AddTarget("skipped", []byte("skipped target"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.MaxDelegations = 1
+	updaterConfig.Fetcher = failingRoleFetcher{role: "failing"}
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	found, err := updater.SearchTargets("*.txt")
+	assert.ErrorIs(t, err, metadata.ErrDownload{})
+	assert.Nil(t, found)
+}
+
+func TestSearchTargetsMaxDelegations(t *testing.T) {
+	// Test that the search stops after MaxDelegations roles
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*"})
+	addDelegatedRole("role1", "role2", 1, []string{"*"})
+	simulator.Sim.AddTarget("role1", []byte("role1 target"), "file1.txt")
+	simulator.Sim.AddTarget("role2", []byte("role2 target"), "file2.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.MaxDelegations = 1
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	found, err := updater.SearchTargets("*.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"file1.txt"}, maps.Keys(found))
+	assert.Nil(t, updater.GetTrustedMetadataSet().Targets["role2"])
+}
+
+func TestOnMetadataLoaded(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*"})
+	simulator.Sim.AddTarget("role1", []byte("delegated target"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	type loadedMetadata struct {
+		role    string
+		version int64
+	}
+	loaded := []loadedMetadata{}
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.OnMetadataLoaded = func(role string, version int64, data []byte) {
+		loaded = append(loaded, loadedMetadata{role: role, version: version})
+		// the data is the verified metadata
+		if role == metadata.TARGETS || role == "role1" {
+			md, err := metadata.Targets().FromBytes(data)
+			assert.NoError(t, err)
+			assert.Equal(t, version, md.Signed.Version)
+		}
+	}
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	err = updater.Refresh()
+	assert.NoError(t, err)
+	expected := []loadedMetadata{
+		{role: metadata.ROOT, version: 2},
+		{role: metadata.TIMESTAMP, version: simulator.Sim.MDTimestamp.Signed.Version},
+		{role: metadata.SNAPSHOT, version: simulator.Sim.MDSnapshot.Signed.Version},
+		{role: metadata.TARGETS, version: simulator.Sim.MDTargets.Signed.Version},
+	}
+	assert.Equal(t, expected, loaded)
+
+	// Test the callback fires for the delegated role during the traversal
+	_, err = updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+	expected = append(expected, loadedMetadata{role: "role1", version: simulator.Sim.MDSnapshot.Signed.Meta["role1.json"].Version})
+	assert.Equal(t, expected, loaded)
+
+	// Test roles already in the trusted set are not reported again
+	_, err = updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+	assert.Len(t, loaded, len(expected))
+}
+
+func TestLazyLocalDelegatedMetadata(t *testing.T) {
+	// Test local delegated metadata is only read when a lookup needs it,
+	// and only once across lookups sharing a delegation
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"shared/*"})
+	addDelegatedRole(metadata.TARGETS, "role2", 1, []string{"other/*"})
+	simulator.Sim.AddTarget("role1", []byte("shared target a"), "shared/a.txt")
+	simulator.Sim.AddTarget("role1", []byte("shared target b"), "shared/b.txt")
+	simulator.Sim.AddTarget("role2", []byte("other target"), "other/c.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	// persist all roles locally
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	for _, targetPath := range []string{"shared/a.txt", "other/c.txt"} {
+		_, err = updater.GetTargetInfo(targetPath)
+		assert.NoError(t, err)
+	}
+
+	loaded := map[string]int{}
+	updaterConfig, err = loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.OnMetadataLoaded = func(role string, version int64, data []byte) {
+		loaded[role]++
+	}
+	updater = initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	err = updater.Refresh()
+	assert.NoError(t, err)
+	assert.Zero(t, loaded["role1"])
+	simulator.Sim.FetchTracker.Metadata = []simulator.FTMetadata{}
+	for _, targetPath := range []string{"shared/a.txt", "shared/b.txt", "shared/a.txt"} {
+		_, err = updater.GetTargetInfo(targetPath)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, loaded["role1"])
+	assert.Zero(t, loaded["role2"])
+	// role1 was read from the local metadata, nothing was downloaded
+	assert.Empty(t, simulator.Sim.FetchTracker.Metadata)
+	assert.Nil(t, updater.GetTrustedMetadataSet().Targets["role2"])
+}
+
+func TestNewDelegatedTargetsHashMismatch(t *testing.T) {
+	// Test that delegated targets metadata is checked against the hashes
+	// committed in snapshot
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.ComputeMetafileHashesAndLength = true
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*"})
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	_, err = updater.GetTargetInfo("file.txt")
+	assert.ErrorContains(t, err, "target file.txt not found")
+	assert.Contains(t, updater.GetTrustedMetadataSet().Targets, "role1")
+
+	// Modify role1 contents without updating snapshot's role1 hashes
+	role1 := simulator.Sim.MDDelegates["role1"]
+	role1.Signed.Version += 1
+	simulator.Sim.MDDelegates["role1"] = role1
+	simulator.Sim.MDSnapshot.Signed.Meta["role1.json"].Version = role1.Signed.Version
+	simulator.Sim.MDSnapshot.Signed.Version += 1
+	simulator.Sim.UpdateTimestamp()
+
+	updater = initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	_, err = updater.GetTargetInfo("file.txt")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+	assert.NotContains(t, updater.GetTrustedMetadataSet().Targets, "role1")
+}
+
+func TestNewDelegatedTargetsLengthMismatch(t *testing.T) {
+	// Test that delegated targets metadata shorter than the length committed
+	// in snapshot is rejected
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*"})
+	simulator.Sim.UpdateSnapshot()
+	noVersion := -1
+	data, err := simulator.Sim.FetchMetadata("role1", &noVersion)
+	assert.NoError(t, err)
+	simulator.Sim.MDSnapshot.Signed.Meta["role1.json"].Length = int64(len(data) + 1)
+	simulator.Sim.MDSnapshot.Signed.Version += 1
+	simulator.Sim.UpdateTimestamp()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	_, err = updater.GetTargetInfo("file.txt")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: fmt.Sprintf("length verification failed - expected %d, got %d", len(data)+1, len(data))})
+}
+
+func TestDelegatedTargets(t *testing.T) {
+	// Test the loaded delegated roles and their delegators:
+	//   targets -> plugins (plugins/*, plugins/*/*)
+	//   plugins -> extras (plugins/*/*)
+	//   targets -> docs (docs/*)
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "plugins", 1, []string{"plugins/*", "plugins/*/*"})
+	addDelegatedRole("plugins", "extras", 1, []string{"plugins/*/*"})
+	addDelegatedRole(metadata.TARGETS, "docs", 1, []string{"docs/*"})
+	simulator.Sim.AddTarget("extras", []byte("extras c"), "plugins/extras/c.tar.gz")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	_, err = updater.GetTargetInfo("plugins/extras/c.tar.gz")
+	assert.NoError(t, err)
+
+	role, delegator, err := updater.DelegatedTargets(metadata.TARGETS)
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.ROOT, delegator)
+	assert.Equal(t, simulator.Sim.MDTargets.Signed.Version, role.Signed.Version)
+	role, delegator, err = updater.DelegatedTargets("plugins")
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.TARGETS, delegator)
+	assert.NotNil(t, role.Signed.Delegations)
+	role, delegator, err = updater.DelegatedTargets("extras")
+	assert.NoError(t, err)
+	assert.Equal(t, "plugins", delegator)
+	assert.Contains(t, role.Signed.Targets, "plugins/extras/c.tar.gz")
+
+	// docs was not needed for the lookup so it isn't loaded
+	_, _, err = updater.DelegatedTargets("docs")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "targets role docs is not loaded"})
+	_, _, err = updater.DelegatedTargets("unknown")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "targets role unknown is not loaded"})
+}
+
+func TestMaxDelegatedDownloads(t *testing.T) {
+	// Test a wide delegation graph, depth 1 with 5 roles, where only the
+	// last role has the target
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		addDelegatedRole(metadata.TARGETS, fmt.Sprintf("role%d", i), 1, []string{"*"})
+	}
+	simulator.Sim.AddTarget("role5", []byte("role5 target"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.MaxDelegatedDownloads = 3
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	_, err = updater.GetTargetInfo("file1.txt")
+	assert.ErrorIs(t, err, metadata.ErrDelegatedDownloadsExceeded{})
+	assert.ErrorIs(t, err, metadata.ErrDownload{})
+	assert.ErrorContains(t, err, "not downloading role4, already downloaded 3 delegated roles")
+	assert.NotNil(t, updater.GetTrustedMetadataSet().Targets["role3"])
+	assert.Nil(t, updater.GetTrustedMetadataSet().Targets["role4"])
+
+	// the limit applies per operation and already loaded roles don't count
+	target, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, simulator.Sim.MDDelegates["role5"].Signed.Targets["file1.txt"].Hashes, target.Hashes)
+}
+
+func TestMaxDelegatedDownloadsPerSearch(t *testing.T) {
+	// Test that MaxDelegatedDownloads applies to each search separately:
+	//   targets -> role1, role2 (a/*)
+	//   targets -> role3, role4 (b/*)
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	for i, dir := range []string{"a", "a", "b", "b"} {
+		role := fmt.Sprintf("role%d", i+1)
+		addDelegatedRole(metadata.TARGETS, role, 1, []string{fmt.Sprintf("%s/*", dir)})
+		simulator.Sim.AddTarget(role, []byte(role), fmt.Sprintf("%s/%s.txt", dir, role))
+	}
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.MaxDelegatedDownloads = 2
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	for _, tt := range []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "a/*", want: []string{"a/role1.txt", "a/role2.txt"}},
+		{pattern: "b/*", want: []string{"b/role3.txt", "b/role4.txt"}},
+	} {
+		res, err := updater.SearchTargets(tt.pattern)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, tt.want, maps.Keys(res))
+	}
+}
+
+// failingRoleFetcher fails downloads of the metadata of role and serves
+// everything else from the repository simulator
+type failingRoleFetcher struct {
+	role string
+}
+
+func (f failingRoleFetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	// the role metadata may be versioned, i.e. <version>.<role>.json
+	if strings.HasSuffix(urlPath, fmt.Sprintf("%s.json", f.role)) {
+		return nil, metadata.ErrDownloadHTTP{StatusCode: http.StatusServiceUnavailable, URL: urlPath}
+	}
+	return simulator.Sim.DownloadFile(urlPath, maxLength, timeout)
+}
+
+func TestMaxDelegatedDownloadsFailedDownloads(t *testing.T) {
+	// Test that failed downloads don't count towards MaxDelegatedDownloads:
+	//   targets -> role1 (fails to download)
+	//   targets -> role2 (has the target)
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*"})
+	addDelegatedRole(metadata.TARGETS, "role2", 1, []string{"*"})
+	simulator.Sim.AddTarget("role2", []byte("role2 target"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.MaxDelegatedDownloads = 1
+	updaterConfig.Fetcher = failingRoleFetcher{role: "role1"}
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	target, skipped, err := updater.GetTargetInfoBestEffort("file1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, simulator.Sim.MDDelegates["role2"].Signed.Targets["file1.txt"].Hashes, target.Hashes)
+	if assert.Len(t, skipped, 1) {
+		assert.Equal(t, "role1", skipped[0].Role)
+		assert.ErrorIs(t, skipped[0].Err, metadata.ErrDownload{})
+	}
+}
+
+func TestChangedTargetsRoles(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*"})
+	addDelegatedRole(metadata.TARGETS, "role2", 1, []string{"*"})
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	// Test there is nothing to compare before a refresh
+	_, err = updater.ChangedTargetsRoles(nil)
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "trusted snapshot not set"})
+	err = updater.Refresh()
+	assert.NoError(t, err)
+	prev := updater.GetTrustedMetadataSet().Snapshot
+	changed, err := updater.ChangedTargetsRoles(prev)
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+
+	// publish a new version of role2 only
+	role2 := simulator.Sim.MDDelegates["role2"]
+	role2.Signed.Version += 1
+	simulator.Sim.MDDelegates["role2"] = role2
+	simulator.Sim.UpdateSnapshot()
+
+	updater = initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	err = updater.Refresh()
+	assert.NoError(t, err)
+	changed, err = updater.ChangedTargetsRoles(prev)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"role2"}, changed)
+
+	// Test without a previous snapshot every role is reported
+	changed, err = updater.ChangedTargetsRoles(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"role1", "role2", metadata.TARGETS}, changed)
+}
+
+func TestMaxDelegatedPathPatterns(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"a/*", "b/*", "c/*"})
+	simulator.Sim.AddTarget("role1", []byte("role1 target"), "a/file1.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	// Test a delegated role over the cap is rejected
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.MaxDelegatedPathPatterns = 2
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	err = updater.Refresh()
+	assert.ErrorIs(t, err, metadata.ErrInvalidDelegation{Msg: "role role1 delegated by targets has 3 path patterns, more than the maximum of 2"})
+	assert.ErrorIs(t, err, metadata.ErrRepository{})
+	assert.Nil(t, updater.GetTrustedMetadataSet().Targets[metadata.TARGETS])
+
+	// Test a delegated role at the cap is accepted
+	updaterConfig.MaxDelegatedPathPatterns = 3
+	updater = initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	_, err = updater.GetTargetInfo("a/file1.txt")
+	assert.NoError(t, err)
+}
+
+func TestNormalizeTargetPaths(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"dir/*"})
+	simulator.Sim.AddTarget("role1", []byte("delegated target"), "dir/file.txt")
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("absolute target"), "/abs.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	// Test paths are used as is by default
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	_, err = updater.GetTargetInfo("dir/file.txt")
+	assert.NoError(t, err)
+	for _, targetPath := range []string{"/dir/file.txt", "./dir/file.txt", "abs.txt"} {
+		_, err = updater.GetTargetInfo(targetPath)
+		assert.ErrorContains(t, err, "not found", targetPath)
+	}
+
+	// Test normalized paths resolve the same targets, through delegations too
+	updaterConfig.NormalizeTargetPaths = true
+	for targetPath, expected := range map[string]string{
+		"dir/file.txt":      "dir/file.txt",
+		"/dir/file.txt":     "dir/file.txt",
+		"./dir//file.txt":   "dir/file.txt",
+		"abs.txt":           "/abs.txt",
+		"/abs.txt":          "/abs.txt",
+		"./abs.txt":         "/abs.txt",
+		"/./dir/./file.txt": "dir/file.txt",
+	} {
+		target, err := updater.GetTargetInfo(targetPath)
+		if assert.NoError(t, err, targetPath) {
+			assert.Equal(t, expected, target.Path, targetPath)
+		}
+	}
+	trace := updater.ExplainTargetResolution("/dir/file.txt")
+	assert.True(t, trace[len(trace)-1].Found)
+	assert.Equal(t, "role1", trace[len(trace)-1].Role)
+	_, err = updater.GetTargetInfo("dir/../abs.txt")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestNormalizeTargetPathsDelegatedRoles(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	// a role delegated a leading-slash pattern listing a leading-slash target
+	addDelegatedRole(metadata.TARGETS, "slash", 1, []string{"/abs*"})
+	simulator.Sim.AddTarget("slash", []byte("absolute target"), "/abs.txt")
+	// a hash bin role whose target isn't listed under its normalized path
+	binPath := "/bin//file.txt"
+	binPathHash := sha256.Sum256([]byte(binPath))
+	simulator.Sim.AddDelegation(metadata.TARGETS, metadata.DelegatedRole{
+		Name:             "bin",
+		KeyIDs:           []string{},
+		Threshold:        1,
+		PathHashPrefixes: []string{base64.URLEncoding.EncodeToString(binPathHash[:])[:4]},
+	}, metadata.Targets(simulator.Sim.SafeExpiry).Signed)
+	simulator.Sim.AddTarget("bin", []byte("hash bin target"), binPath)
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.NormalizeTargetPaths = true
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+
+	// Test exact and normalized queries reach the leading-slash delegation
+	for _, targetPath := range []string{"/abs.txt", "abs.txt", "./abs.txt"} {
+		target, err := updater.GetTargetInfo(targetPath)
+		if assert.NoError(t, err, targetPath) {
+			assert.Equal(t, "/abs.txt", target.Path, targetPath)
+		}
+	}
+	trace := updater.ExplainTargetResolution("abs.txt")
+	assert.True(t, trace[len(trace)-1].Found)
+	assert.Equal(t, "slash", trace[len(trace)-1].Role)
+
+	// Test the exact path is routed to its hash bin
+	target, err := updater.GetTargetInfo(binPath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, binPath, target.Path)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package updater
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	simulator "github.com/rdimitrov/go-tuf-metadata/testutils/simulator"
+)
+
+// fileFetcher serves files from the local filesystem, treating the URL as a path
+type fileFetcher struct{}
+
+func (fileFetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	data, err := os.ReadFile(urlPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, metadata.ErrDownloadHTTP{StatusCode: http.StatusNotFound, URL: urlPath}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxLength {
+		return nil, metadata.ErrDownloadLengthMismatch{Msg: fmt.Sprintf("Downloaded %d bytes exceeding the maximum allowed length of %d", len(data), maxLength)}
+	}
+	return data, nil
+}
+
+func TestMirror(t *testing.T) {
+	// Test that a repository with a rotated root, a delegated role and
+	// targets is mirrored with the consistent snapshot layout and that the
+	// mirror can be used as a repository by a new client
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.MDRoot.Signed.ConsistentSnapshot = true
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"role1/*"})
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("top-level target"), "file1.txt")
+	simulator.Sim.AddTarget("role1", []byte("delegated target"), "role1/file2.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	destDir := t.TempDir()
+	err = updater.Mirror(destDir)
+	assert.NoError(t, err)
+
+	hashPrefix := func(data []byte) string {
+		targetFile, err := metadata.TargetFile().FromBytes("", data)
+		assert.NoError(t, err)
+		return hex.EncodeToString(targetFile.Hashes["sha256"])
+	}
+	snapshotVersion := simulator.Sim.MDSnapshot.Signed.Version
+	expectedFiles := []string{
+		"metadata/1.root.json",
+		"metadata/2.root.json",
+		"metadata/timestamp.json",
+		fmt.Sprintf("metadata/%d.snapshot.json", snapshotVersion),
+		fmt.Sprintf("metadata/%d.targets.json", simulator.Sim.MDTargets.Signed.Version),
+		fmt.Sprintf("metadata/%d.role1.json", simulator.Sim.MDSnapshot.Signed.Meta["role1.json"].Version),
+		fmt.Sprintf("targets/%s.file1.txt", hashPrefix([]byte("top-level target"))),
+		fmt.Sprintf("targets/role1/%s.file2.txt", hashPrefix([]byte("delegated target"))),
+	}
+	for _, file := range expectedFiles {
+		assert.FileExists(t, filepath.Join(destDir, filepath.FromSlash(file)))
+	}
+	assert.NoFileExists(t, filepath.Join(destDir, "metadata", "3.root.json"))
+
+	// Re-open the mirror as a repository with a new client
+	rootBytes, err := os.ReadFile(filepath.Join(destDir, "metadata", "1.root.json"))
+	assert.NoError(t, err)
+	mirrorConfig, err := config.New(filepath.Join(destDir, "metadata"), rootBytes)
+	assert.NoError(t, err)
+	mirrorConfig.Fetcher = fileFetcher{}
+	mirrorConfig.RemoteTargetsURL = filepath.Join(destDir, "targets")
+	mirrorConfig.LocalMetadataDir = t.TempDir()
+	mirrorConfig.LocalTargetsDir = t.TempDir()
+	mirrorUpdater, err := New(mirrorConfig)
+	assert.NoError(t, err)
+	err = mirrorUpdater.Refresh()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), mirrorUpdater.GetTrustedMetadataSet().Root.Signed.Version)
+	for targetPath, content := range map[string]string{"file1.txt": "top-level target", "role1/file2.txt": "delegated target"} {
+		info, err := mirrorUpdater.GetTargetInfo(targetPath)
+		assert.NoError(t, err)
+		_, data, err := mirrorUpdater.DownloadTarget(info, "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(content), data)
+	}
+}
+
+func TestMirrorFailsOnTamperedTarget(t *testing.T) {
+	// Test that the mirror fails if a target doesn't match its target file info
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("top-level target"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+	repoTarget := simulator.Sim.TargetFiles["file1.txt"]
+	repoTarget.Data = []byte("tampered target")
+	simulator.Sim.TargetFiles["file1.txt"] = repoTarget
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	err = updater.Mirror(t.TempDir())
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+}
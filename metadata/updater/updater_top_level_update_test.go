@@ -12,9 +12,20 @@
 package updater
 
 import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -24,6 +35,7 @@ import (
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
 	simulator "github.com/rdimitrov/go-tuf-metadata/testutils/simulator"
 	testutils "github.com/rdimitrov/go-tuf-metadata/testutils/testutils"
 )
@@ -77,7 +89,7 @@ func loadUnsafeUpdaterConfig() (*config.UpdaterConfig, error) {
 }
 
 // runRefresh creates new Updater instance and runs Refresh
-func runRefresh(updaterConfig *config.UpdaterConfig, moveInTime time.Time) (Updater, error) {
+func runRefresh(updaterConfig *config.UpdaterConfig, moveInTime time.Time) (*Updater, error) {
 	if len(simulator.Sim.DumpDir) > 0 {
 		simulator.Sim.Write()
 	}
@@ -85,13 +97,13 @@ func runRefresh(updaterConfig *config.UpdaterConfig, moveInTime time.Time) (Upda
 	updater, err := New(updaterConfig)
 	if err != nil {
 		log.Debugf("failed to create new updater config: %v", err)
-		return Updater{}, err
+		return nil, err
 	}
 	if moveInTime != time.Now() {
 		updater.trusted.RefTime = moveInTime
 	}
 	err = updater.Refresh()
-	return *updater, err
+	return updater, err
 }
 
 func initUpdater(updaterConfig *config.UpdaterConfig) *Updater {
@@ -217,6 +229,76 @@ func TestUnsafeLoadTrustedRootMetadata(t *testing.T) {
 	}
 }
 
+func TestMinTrustedRootVersion(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+
+	// Trusted root v1 is below the minimum version
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.MinTrustedRootVersion = 2
+	updater, err := New(updaterConfig)
+	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "trusted root version 1 is below the minimum version 2"})
+	assert.Nil(t, updater)
+
+	// Trusted root v1 is the minimum version
+	updaterConfig.MinTrustedRootVersion = 1
+	_, err = New(updaterConfig)
+	assert.NoError(t, err)
+
+	// Trusted root v2 is above the minimum version
+	updaterConfig.LocalTrustedRoot = simulator.Sim.SignedRoots[1]
+	updater, err = New(updaterConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), updater.trusted.Root.Signed.Version)
+}
+
+func TestInitialRootBootstrap(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	rootDigest := sha256.Sum256(simulator.Sim.SignedRoots[0])
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.LocalTrustedRoot = nil
+	updaterConfig.LocalMetadataDir = t.TempDir()
+	updaterConfig.InitialRootURL = simulator.Sim.LocalDir + "/metadata/1.root.json"
+
+	// Wrong pinned hash, the downloaded root is rejected and not persisted
+	updaterConfig.InitialRootSHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	updater, err := New(updaterConfig)
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+	assert.Nil(t, updater)
+	assert.NoFileExists(t, filepath.Join(updaterConfig.LocalMetadataDir, "root.json"))
+
+	// Correct pinned hash, the downloaded root is trusted and persisted
+	updaterConfig.InitialRootSHA256 = hex.EncodeToString(rootDigest[:])
+	updater, err = New(updaterConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), updater.trusted.Root.Signed.Version)
+	persisted, err := os.ReadFile(filepath.Join(updaterConfig.LocalMetadataDir, "root.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, simulator.Sim.SignedRoots[0], persisted)
+	err = updater.Refresh()
+	assert.NoError(t, err)
+
+	// The local root.json takes precedence over the initial root URL
+	updaterConfig.InitialRootSHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	updater, err = New(updaterConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), updater.trusted.Root.Signed.Version)
+
+	// A provided LocalTrustedRoot takes precedence over both
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+	updaterConfig.LocalTrustedRoot = simulator.Sim.SignedRoots[1]
+	updater, err = New(updaterConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), updater.trusted.Root.Signed.Version)
+}
+
 func TestFirstTimeRefresh(t *testing.T) {
 	err := loadOrResetTrustedRootMetadata()
 	assert.NoError(t, err)
@@ -670,10 +752,45 @@ func TestNewSnapshotHashMismatch(t *testing.T) {
 	// Hash mismatch error
 	_, err = runRefresh(updaterConfig, time.Now())
 	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
-	assertVersionEquals(t, metadata.TIMESTAMP, 3)
+	assertVersionEquals(t, metadata.TIMESTAMP, 2)
 	assertVersionEquals(t, metadata.SNAPSHOT, 1)
 }
 
+func TestLocalSnapshotHashMismatch(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+
+	// Update timestamp with snapshot's hashes
+	simulator.Sim.ComputeMetafileHashesAndLength = true
+	simulator.Sim.UpdateTimestamp() // timestamp v2
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	remoteSnapshot, err := os.ReadFile(filepath.Join(updaterConfig.LocalMetadataDir, "snapshot.json"))
+	assert.NoError(t, err)
+
+	// Replace the local snapshot with a signed snapshot of the same version
+	// whose bytes don't match the hash committed to by timestamp
+	expires := simulator.Sim.MDSnapshot.Signed.Expires
+	simulator.Sim.MDSnapshot.Signed.Expires = expires.Add(time.Hour * 24)
+	version := -1
+	localSnapshot, err := simulator.Sim.FetchMetadata(metadata.SNAPSHOT, &version)
+	assert.NoError(t, err)
+	simulator.Sim.MDSnapshot.Signed.Expires = expires
+	err = os.WriteFile(filepath.Join(updaterConfig.LocalMetadataDir, "snapshot.json"), localSnapshot, 0644)
+	assert.NoError(t, err)
+
+	// The local snapshot is rejected and the committed one is downloaded
+	simulator.Sim.FetchTracker.Metadata = []simulator.FTMetadata{}
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Contains(t, simulator.Sim.FetchTracker.Metadata, simulator.FTMetadata{Name: metadata.SNAPSHOT, Value: 1})
+	data, err := os.ReadFile(filepath.Join(updaterConfig.LocalMetadataDir, "snapshot.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, remoteSnapshot, data)
+}
+
 func TestNewSnapshotUnsigned(t *testing.T) {
 	err := loadOrResetTrustedRootMetadata()
 	assert.NoError(t, err)
@@ -685,7 +802,8 @@ func TestNewSnapshotUnsigned(t *testing.T) {
 	_, err = runRefresh(updaterConfig, time.Now())
 	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying snapshot failed, not enough signatures, got 0, want 1"})
 
-	assertFilesExist(t, []string{metadata.ROOT, metadata.TIMESTAMP})
+	// the failed refresh is not persisted
+	assertFilesExact(t, []string{metadata.ROOT})
 }
 
 func TestNewSnapshotVersionMismatch(t *testing.T) {
@@ -702,7 +820,8 @@ func TestNewSnapshotVersionMismatch(t *testing.T) {
 	_, err = runRefresh(updaterConfig, time.Now())
 	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "expected 1, got 2"})
 
-	assertFilesExist(t, []string{metadata.ROOT, metadata.TIMESTAMP})
+	// the failed refresh is not persisted
+	assertFilesExact(t, []string{metadata.ROOT})
 }
 
 func TestNewSnapshotVersionRollback(t *testing.T) {
@@ -803,7 +922,7 @@ func TestNewTargetsHashMismatch(t *testing.T) {
 	_, err = runRefresh(updaterConfig, time.Now())
 	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
 
-	assertVersionEquals(t, metadata.SNAPSHOT, 3)
+	assertVersionEquals(t, metadata.SNAPSHOT, 2)
 	assertVersionEquals(t, metadata.TARGETS, 1)
 }
 
@@ -819,7 +938,8 @@ func TestNewTargetsUnsigned(t *testing.T) {
 	_, err = runRefresh(updaterConfig, time.Now())
 	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying targets failed, not enough signatures, got 0, want 1"})
 
-	assertFilesExist(t, []string{metadata.ROOT, metadata.TIMESTAMP, metadata.SNAPSHOT})
+	// the failed refresh is not persisted
+	assertFilesExact(t, []string{metadata.ROOT})
 }
 
 func TestNewTargetsVersionMismatch(t *testing.T) {
@@ -835,7 +955,8 @@ func TestNewTargetsVersionMismatch(t *testing.T) {
 	_, err = runRefresh(updaterConfig, time.Now())
 	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "expected targets version 1, got 2"})
 
-	assertFilesExist(t, []string{metadata.ROOT, metadata.TIMESTAMP, metadata.SNAPSHOT})
+	// the failed refresh is not persisted
+	assertFilesExact(t, []string{metadata.ROOT})
 }
 
 func TestNewTargetsExpired(t *testing.T) {
@@ -851,7 +972,126 @@ func TestNewTargetsExpired(t *testing.T) {
 	_, err = runRefresh(updaterConfig, time.Now())
 	assert.ErrorIs(t, err, metadata.ErrExpiredMetadata{Msg: "new targets is expired"})
 
-	assertFilesExist(t, []string{metadata.ROOT, metadata.TIMESTAMP, metadata.SNAPSHOT})
+	// the failed refresh is not persisted
+	assertFilesExact(t, []string{metadata.ROOT})
+}
+
+func TestRefreshRollbackOnTargetsFailure(t *testing.T) {
+	// Test that a refresh failing at the targets step leaves neither the
+	// trusted timestamp/snapshot nor the cached ones advanced
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+
+	// New targets are published but not signed
+	targetsSigners := simulator.Sim.Signers[metadata.TARGETS]
+	delete(simulator.Sim.Signers, metadata.TARGETS)
+	simulator.Sim.MDTargets.Signed.Version += 1
+	simulator.Sim.UpdateSnapshot()
+
+	updater, err := runRefresh(updaterConfig, time.Now())
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying targets failed, not enough signatures, got 0, want 1"})
+	assert.Nil(t, updater.trusted.Timestamp)
+	assert.Nil(t, updater.trusted.Snapshot)
+	assert.Empty(t, updater.trusted.Targets)
+	assertVersionEquals(t, metadata.TIMESTAMP, 1)
+	assertVersionEquals(t, metadata.SNAPSHOT, 1)
+	assertVersionEquals(t, metadata.TARGETS, 1)
+
+	// The refresh can be retried once the repository is fixed; republish
+	// as asserting the versions loaded the cached metadata in the simulator
+	simulator.Sim.Signers[metadata.TARGETS] = targetsSigners
+	simulator.Sim.MDTargets.Signed.Version += 1
+	simulator.Sim.UpdateSnapshot()
+	err = updater.Refresh()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), updater.trusted.Timestamp.Signed.Version)
+	assertVersionEquals(t, metadata.TIMESTAMP, 2)
+	assertVersionEquals(t, metadata.SNAPSHOT, 2)
+	assertVersionEquals(t, metadata.TARGETS, 2)
+}
+
+func TestResumeRefreshFromLocalMetadata(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+
+	// Test that valid local snapshot and targets are not downloaded again,
+	// even with a new timestamp
+	simulator.Sim.UpdateTimestamp()
+	simulator.Sim.FetchTracker.Metadata = []simulator.FTMetadata{}
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []simulator.FTMetadata{
+		{Name: metadata.ROOT, Value: 2},
+		{Name: metadata.TIMESTAMP, Value: -1},
+	}, simulator.Sim.FetchTracker.Metadata)
+
+	// Test resuming a refresh interrupted before targets was persisted:
+	// only the missing targets are downloaded
+	err = os.Remove(filepath.Join(updaterConfig.LocalMetadataDir, "targets.json"))
+	assert.NoError(t, err)
+	simulator.Sim.FetchTracker.Metadata = []simulator.FTMetadata{}
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []simulator.FTMetadata{
+		{Name: metadata.ROOT, Value: 2},
+		{Name: metadata.TIMESTAMP, Value: -1},
+		{Name: metadata.TARGETS, Value: 1},
+	}, simulator.Sim.FetchTracker.Metadata)
+	assertFilesExist(t, metadata.TOP_LEVEL_ROLE_NAMES[:])
+
+	// Test that a missing or corrupt local timestamp doesn't cause the valid
+	// local snapshot and targets to be downloaded again
+	err = os.Remove(filepath.Join(updaterConfig.LocalMetadataDir, "timestamp.json"))
+	assert.NoError(t, err)
+	simulator.Sim.FetchTracker.Metadata = []simulator.FTMetadata{}
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []simulator.FTMetadata{
+		{Name: metadata.ROOT, Value: 2},
+		{Name: metadata.TIMESTAMP, Value: -1},
+	}, simulator.Sim.FetchTracker.Metadata)
+	err = os.WriteFile(filepath.Join(updaterConfig.LocalMetadataDir, "timestamp.json"), []byte("{"), 0644)
+	assert.NoError(t, err)
+	simulator.Sim.FetchTracker.Metadata = []simulator.FTMetadata{}
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []simulator.FTMetadata{
+		{Name: metadata.ROOT, Value: 2},
+		{Name: metadata.TIMESTAMP, Value: -1},
+	}, simulator.Sim.FetchTracker.Metadata)
+
+	// Test that a corrupt local targets is downloaded again
+	err = os.WriteFile(filepath.Join(updaterConfig.LocalMetadataDir, "targets.json"), []byte("{"), 0644)
+	assert.NoError(t, err)
+	simulator.Sim.FetchTracker.Metadata = []simulator.FTMetadata{}
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []simulator.FTMetadata{
+		{Name: metadata.ROOT, Value: 2},
+		{Name: metadata.TIMESTAMP, Value: -1},
+		{Name: metadata.TARGETS, Value: 1},
+	}, simulator.Sim.FetchTracker.Metadata)
+
+	// Test that new snapshot and targets are downloaded once published
+	simulator.Sim.MDTargets.Signed.Version += 1
+	simulator.Sim.UpdateSnapshot()
+	simulator.Sim.FetchTracker.Metadata = []simulator.FTMetadata{}
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []simulator.FTMetadata{
+		{Name: metadata.ROOT, Value: 2},
+		{Name: metadata.TIMESTAMP, Value: -1},
+		{Name: metadata.SNAPSHOT, Value: 2},
+		{Name: metadata.TARGETS, Value: 2},
+	}, simulator.Sim.FetchTracker.Metadata)
 }
 
 func TestComputeMetafileHashesLength(t *testing.T) {
@@ -1070,3 +1310,1231 @@ func TestTimestampEqVersionsCheck(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, initialTimestampMetadataVer, timestamp.Signed.Meta["snapshot.json"].Version)
 }
+
+func TestPruneCache(t *testing.T) {
+	// Test that only superseded versioned metadata files are removed
+	// from the local metadata directory
+
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	addDelegatedRole(metadata.TARGETS, "role1", 1, []string{"*"})
+	// publish root v2 and v3, bump snapshot, timestamp and role1 to v2
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+	role1 := simulator.Sim.MDDelegates["role1"]
+	role1.Signed.Version += 1
+	simulator.Sim.MDDelegates["role1"] = role1
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater, err := runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	_, err = updater.GetTargetInfo("missing.txt")
+	assert.Error(t, err)
+
+	// the bootstrap root, trusted and newer versions, roles which aren't
+	// trusted and unversioned files are kept
+	stale := []string{"2.root.json", "1.timestamp.json", "1.snapshot.json", "1.role1.json"}
+	kept := []string{"1.root.json", "3.root.json", "2.timestamp.json", "2.snapshot.json", "3.snapshot.json", "1.targets.json", "2.role1.json", "1.role2.json", "notes.json", "1.root.txt"}
+	for _, name := range append(stale, kept...) {
+		err = os.WriteFile(filepath.Join(simulator.MetadataDir, name), []byte("{}"), 0644)
+		assert.NoError(t, err)
+	}
+
+	err = updater.PruneCache()
+	assert.NoError(t, err)
+	for _, name := range stale {
+		assert.NoFileExists(t, filepath.Join(simulator.MetadataDir, name))
+	}
+	for _, name := range kept {
+		assert.FileExists(t, filepath.Join(simulator.MetadataDir, name))
+	}
+	assertFilesExist(t, []string{metadata.ROOT, metadata.TIMESTAMP, metadata.SNAPSHOT, metadata.TARGETS, "role1"})
+}
+
+func TestDownloadMetadataRoleTimeouts(t *testing.T) {
+	// Test that a slow server trips the short timestamp timeout but not
+	// the longer targets timeout
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	updaterConfig, err := config.New(server.URL, simulator.RootBytes)
+	assert.NoError(t, err)
+	updaterConfig.TimestampTimeout = 50 * time.Millisecond
+	updaterConfig.TargetsTimeout = 5 * time.Second
+	updater := &Updater{cfg: updaterConfig}
+
+	_, err = updater.downloadMetadata(metadata.TIMESTAMP, updaterConfig.TimestampMaxLength, "")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	data, err := updater.downloadMetadata(metadata.TARGETS, updaterConfig.TargetsMaxLength, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("{}"), data)
+
+	// delegated roles use the targets timeout
+	_, err = updater.downloadMetadata("role1", updaterConfig.TargetsMaxLength, "")
+	assert.NoError(t, err)
+
+	// unset timeouts fall back to the default
+	updaterConfig.SnapshotTimeout = 0
+	assert.Equal(t, config.DefaultTimeout, updaterConfig.RoleTimeout(metadata.SNAPSHOT))
+}
+
+func TestDownloadTargetTimeout(t *testing.T) {
+	// Test that target file downloads, including mirrored ones, use
+	// TargetTimeout
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("target"))
+	}))
+	defer server.Close()
+
+	updaterConfig, err := config.New(server.URL, simulator.RootBytes)
+	assert.NoError(t, err)
+	updaterConfig.TargetTimeout = 50 * time.Millisecond
+	updater := &Updater{cfg: updaterConfig}
+	targetFile, err := metadata.TargetFile().FromBytes("file.txt", []byte("target"))
+	assert.NoError(t, err)
+	targets := metadata.Targets(time.Now().Add(time.Hour))
+	targets.Signed.Targets["file.txt"] = targetFile
+
+	_, err = updater.downloadTargetFromMirrors(targetFile, []string{server.URL}, "file.txt", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	err = updater.mirrorTargets(t.TempDir(), targets, false, map[string]bool{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	updaterConfig.TargetTimeout = 5 * time.Second
+	data, err := updater.downloadTargetFromMirrors(targetFile, []string{server.URL}, "file.txt", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("target"), data)
+	err = updater.mirrorTargets(t.TempDir(), targets, false, map[string]bool{})
+	assert.NoError(t, err)
+
+	// an unset timeout falls back to the default
+	updaterConfig.TargetTimeout = 0
+	assert.Equal(t, config.DefaultTimeout, updaterConfig.TargetFileTimeout())
+}
+
+func TestCompressedLocalMetadata(t *testing.T) {
+	// Test that compressed local metadata is written by Refresh and can be
+	// read back by a subsequent offline Refresh
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.CompressLocalMetadata = true
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+
+	for _, role := range metadata.TOP_LEVEL_ROLE_NAMES {
+		data, err := os.ReadFile(filepath.Join(simulator.MetadataDir, fmt.Sprintf("%s.json.gz", role)))
+		assert.NoError(t, err)
+		decompressed, err := gunzipBytes(data, updaterConfig.TargetsMaxLength)
+		assert.NoError(t, err)
+		if role == metadata.ROOT {
+			assert.Equal(t, simulator.RootBytes, decompressed)
+			continue
+		}
+		noVersion := -1
+		expected, err := simulator.Sim.FetchMetadata(role, &noVersion)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, decompressed)
+	}
+	for _, role := range []string{metadata.TIMESTAMP, metadata.SNAPSHOT, metadata.TARGETS} {
+		assert.NoFileExists(t, filepath.Join(simulator.MetadataDir, fmt.Sprintf("%s.json", role)))
+	}
+
+	// Only the compressed local metadata is available in unsafe local mode
+	updaterConfig.UnsafeLocalMode = true
+	updater, err := runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	assert.Equal(t, simulator.Sim.MDTargets.Signed.Version, updater.GetTrustedMetadataSet().Targets[metadata.TARGETS].Signed.Version)
+
+	// Test compressed metadata is not decompressed past its max length
+	updaterConfig.TimestampMaxLength = 10
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "decompressed metadata is larger than 10 bytes"})
+}
+
+func TestCorruptLocalMetadata(t *testing.T) {
+	// Test that a local timestamp, snapshot or targets file which is malformed
+	// or of another metadata type is treated as absent and replaced by the
+	// remote metadata
+	corruptions := map[string][]byte{
+		"malformed":  []byte("{garbage"),
+		"wrong type": []byte(`{"signed":{"_type":"root"},"signatures":[]}`),
+	}
+	for _, role := range []string{metadata.TIMESTAMP, metadata.SNAPSHOT, metadata.TARGETS} {
+		for name, corrupt := range corruptions {
+			t.Run(role+" "+name, func(t *testing.T) {
+				err := loadOrResetTrustedRootMetadata()
+				assert.NoError(t, err)
+				updaterConfig, err := loadUpdaterConfig()
+				assert.NoError(t, err)
+				_, err = runRefresh(updaterConfig, time.Now())
+				assert.NoError(t, err)
+
+				localPath := filepath.Join(simulator.MetadataDir, fmt.Sprintf("%s.json", role))
+				err = os.WriteFile(localPath, corrupt, 0644)
+				assert.NoError(t, err)
+
+				_, err = runRefresh(updaterConfig, time.Now())
+				assert.NoError(t, err)
+				data, err := os.ReadFile(localPath)
+				assert.NoError(t, err)
+				noVersion := -1
+				expected, err := simulator.Sim.FetchMetadata(role, &noVersion)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, data)
+			})
+		}
+	}
+}
+
+func TestRequireHashAlgorithms(t *testing.T) {
+	// Test that targets and metadata missing a required hash algorithm are
+	// rejected even if their published hashes match
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("sha256 only"), "file1.txt")
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("sha256 and sha512"), "file2.txt")
+	file2 := simulator.Sim.TargetFiles["file2.txt"].TargetFile
+	withSHA512, err := metadata.TargetFile().FromBytes("file2.txt", []byte("sha256 and sha512"), "sha512")
+	assert.NoError(t, err)
+	file2.Hashes["sha512"] = withSHA512.Hashes["sha512"]
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updaterConfig.RequireHashAlgorithms = []string{"sha512"}
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+
+	// target lacking sha512 is rejected for download and from the cache
+	info, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+	_, _, err = updater.DownloadTarget(info, "", "")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "file1.txt is missing required hash algorithm sha512"})
+	cachedPath := filepath.Join(updaterConfig.LocalTargetsDir, "file1.txt")
+	err = os.WriteFile(cachedPath, []byte("sha256 only"), 0644)
+	assert.NoError(t, err)
+	path, data, err := updater.FindCachedTarget(info, cachedPath)
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+	assert.Nil(t, data)
+
+	// target with all required algorithms is accepted
+	info, err = updater.GetTargetInfo("file2.txt")
+	assert.NoError(t, err)
+	_, data, err = updater.DownloadTarget(info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("sha256 and sha512"), data)
+
+	// published snapshot meta hashes lacking sha512 are rejected
+	simulator.Sim.ComputeMetafileHashesAndLength = true
+	simulator.Sim.UpdateSnapshot()
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "snapshot.json is missing required hash algorithm sha512"})
+}
+
+func TestAllowedHashAlgorithms(t *testing.T) {
+	// Test that only hashes of allowed algorithms are used to verify targets
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("sha256 and sha1"), "file1.txt")
+	sha1Digest := sha1.Sum([]byte("sha256 and sha1"))
+	simulator.Sim.TargetFiles["file1.txt"].TargetFile.Hashes["sha1"] = sha1Digest[:]
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("sha256 and bad sha512"), "file2.txt")
+	simulator.Sim.TargetFiles["file2.txt"].TargetFile.Hashes["sha512"] = make([]byte, 64)
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	info, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+
+	// by default every listed hash is verified
+	_, _, err = updater.DownloadTarget(info, "", "")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - unknown hashing algorithm - sha1"})
+
+	// the disallowed sha1 hash is ignored
+	updaterConfig.AllowedHashAlgorithms = []string{"sha256", "sha512"}
+	_, data, err := updater.DownloadTarget(info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("sha256 and sha1"), data)
+	path, data, err := updater.FindCachedTarget(info, "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, path)
+	assert.Equal(t, []byte("sha256 and sha1"), data)
+
+	// allowed hashes still have to match
+	info2, err := updater.GetTargetInfo("file2.txt")
+	assert.NoError(t, err)
+	_, _, err = updater.DownloadTarget(info2, "", "")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha512"})
+	updaterConfig.AllowedHashAlgorithms = []string{"sha256"}
+	_, data, err = updater.DownloadTarget(info2, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("sha256 and bad sha512"), data)
+
+	// at least one allowed hash is required
+	updaterConfig.AllowedHashAlgorithms = []string{"sha512"}
+	_, _, err = updater.DownloadTarget(info, "", "")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "file1.txt has no hash of an allowed hash algorithm"})
+	path, data, err = updater.FindCachedTarget(info, "")
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+	assert.Nil(t, data)
+}
+
+func TestDownloadTargetHashAlgorithms(t *testing.T) {
+	// Test that only the hashes selected per call are verified
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("bad sha256 and sha512"), "file1.txt")
+	sha512Digest := sha512.Sum512([]byte("bad sha256 and sha512"))
+	simulator.Sim.TargetFiles["file1.txt"].TargetFile.Hashes["sha256"] = make([]byte, 32)
+	simulator.Sim.TargetFiles["file1.txt"].TargetFile.Hashes["sha512"] = sha512Digest[:]
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	info, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+
+	// by default every listed hash is verified
+	_, _, err = updater.DownloadTarget(info, "", "")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+
+	// only the selected sha512 hash is verified
+	_, data, err := updater.DownloadTarget(info, "", "", "sha512")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bad sha256 and sha512"), data)
+	data, err = updater.DownloadTargetBytes(info, "", "sha512")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bad sha256 and sha512"), data)
+	_, err = updater.DownloadTargetBytes(info, "", "sha256")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+
+	// the selected algorithms must be listed by the target
+	_, _, err = updater.DownloadTarget(info, "", "", "sha384")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "target file has no sha384 hash to verify"})
+
+	// the required algorithms are verified whatever the selection
+	updaterConfig.RequireHashAlgorithms = []string{"sha256"}
+	_, _, err = updater.DownloadTarget(info, "", "", "sha512")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+	updaterConfig.RequireHashAlgorithms = nil
+
+	// and allowed
+	updaterConfig.AllowedHashAlgorithms = []string{"sha256"}
+	_, _, err = updater.DownloadTarget(info, "", "", "sha512")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash algorithm sha512 is not allowed to verify file1.txt"})
+}
+
+func TestRequireMetaHashes(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+
+	// Test absent meta hashes are accepted by default
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+
+	// Test absent snapshot hashes are rejected if required
+	updaterConfig.RequireMetaHashes = true
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "no hashes published for snapshot.json"})
+
+	// Test absent targets hashes are rejected if required
+	simulator.Sim.ComputeMetafileHashesAndLength = true
+	simulator.Sim.UpdateTimestamp()
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "no hashes published for targets.json"})
+
+	// Test present meta hashes are accepted and verified
+	simulator.Sim.UpdateSnapshot()
+	updater, err := runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, updater.trusted.Timestamp.Signed.Meta["snapshot.json"].Hashes)
+	assert.NotEmpty(t, updater.trusted.Snapshot.Signed.Meta["targets.json"].Hashes)
+
+	// Test present meta hashes must match
+	simulator.Sim.MDTargets.Signed.Version += 1
+	simulator.Sim.MDSnapshot.Signed.Meta["targets.json"].Version = simulator.Sim.MDTargets.Signed.Version
+	simulator.Sim.MDSnapshot.Signed.Version += 1
+	simulator.Sim.UpdateTimestamp()
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+}
+
+func TestPreserveTargetDirs(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	targets := map[string]string{
+		"file1.txt":             "top-level target",
+		"docs/readme":           "nested target",
+		"docs/guides/intro.txt": "deeply nested target",
+	}
+	for targetPath, content := range targets {
+		simulator.Sim.AddTarget(metadata.TARGETS, []byte(content), targetPath)
+	}
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updaterConfig.LocalTargetsDir = t.TempDir()
+	updaterConfig.PreserveTargetDirs = true
+	// keep the remote layout flat, only the local one is under test
+	simulator.Sim.PrefixTargetsWithHash = false
+	updaterConfig.PrefixTargetsWithHash = false
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+
+	for targetPath, content := range targets {
+		info, err := updater.GetTargetInfo(targetPath)
+		assert.NoError(t, err)
+		path, _, err := updater.DownloadTarget(info, "", "")
+		assert.NoError(t, err)
+		expectedPath := filepath.Join(updaterConfig.LocalTargetsDir, filepath.FromSlash(targetPath))
+		assert.Equal(t, expectedPath, path)
+		data, err := os.ReadFile(expectedPath)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(content), data)
+		// the nested target is found in the cache
+		path, data, err = updater.FindCachedTarget(info, "")
+		assert.NoError(t, err)
+		assert.Equal(t, expectedPath, path)
+		assert.Equal(t, []byte(content), data)
+	}
+
+	// Test target paths outside of LocalTargetsDir are rejected
+	for _, targetPath := range []string{"../escape.txt", "docs/../../escape.txt", "/etc/escape.txt", ""} {
+		_, err := updater.generateTargetFilePath(&metadata.TargetFiles{Path: targetPath})
+		assert.ErrorIs(t, err, metadata.ErrValue{Msg: fmt.Sprintf("refusing to store target with non-local path %s", targetPath)})
+	}
+}
+
+func TestTargetHashPrefixAlgorithm(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("sha256 only"), "sha256.txt")
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("sha512 only"), "sha512.txt")
+	sha512File := simulator.Sim.TargetFiles["sha512.txt"].TargetFile
+	withSHA512, err := metadata.TargetFile().FromBytes("sha512.txt", []byte("sha512 only"), "sha512")
+	assert.NoError(t, err)
+	sha512File.Hashes = withSHA512.Hashes
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+
+	// Test the default sha256 prefix
+	sha256Info, err := updater.GetTargetInfo("sha256.txt")
+	assert.NoError(t, err)
+	_, data, err := updater.DownloadTarget(sha256Info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("sha256 only"), data)
+	sha512Info, err := updater.GetTargetInfo("sha512.txt")
+	assert.NoError(t, err)
+	_, _, err = updater.DownloadTarget(sha512Info, "", "")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "target sha512.txt has no sha256 hash to prefix its filename with"})
+
+	// Test a sha512 prefix
+	updaterConfig.TargetHashPrefixAlgorithm = "sha512"
+	_, data, err = updater.DownloadTarget(sha512Info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("sha512 only"), data)
+	_, _, err = updater.DownloadTarget(sha256Info, "", "")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "target sha256.txt has no sha512 hash to prefix its filename with"})
+}
+
+func TestHashPrefixedNestedTargets(t *testing.T) {
+	// Test that the hash prefix of a nested target goes in front of its
+	// basename, e.g. a/b/<hash>.c.txt, and not after its first directory
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("nested target"), "a/b/c.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+
+	info, err := updater.GetTargetInfo("a/b/c.txt")
+	assert.NoError(t, err)
+	simulator.Sim.FetchTracker.Targets = nil
+	_, data, err := updater.DownloadTarget(info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("nested target"), data)
+	hash := hex.EncodeToString(info.Hashes["sha256"])
+	assert.Equal(t, []simulator.FTTargets{{Name: "a/b/c.txt", Value: &hash}}, simulator.Sim.FetchTracker.Targets)
+}
+
+// downMirrorFetcher fails downloads from the down base URL and serves
+// everything else from the repository simulator
+type downMirrorFetcher struct {
+	down string
+}
+
+func (f downMirrorFetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	if strings.HasPrefix(urlPath, f.down) {
+		return nil, metadata.ErrDownloadHTTP{StatusCode: http.StatusServiceUnavailable, URL: urlPath}
+	}
+	return simulator.Sim.DownloadFile(urlPath, maxLength, timeout)
+}
+
+func TestDownloadTargetMirrorFailover(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("target content"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	downURL := "https://down.example.com/targets"
+	updaterConfig.Fetcher = downMirrorFetcher{down: downURL}
+	updaterConfig.RemoteTargetsURL = downURL
+	updaterConfig.RemoteTargetsMirrorURLs = []string{filepath.Join(simulator.Sim.LocalDir, "targets")}
+	updaterConfig.DisableLocalCache = true
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	info, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+
+	// Test that the second mirror is used if the first one is down
+	_, data, err := updater.DownloadTarget(info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("target content"), data)
+
+	// Test that an explicit targetBaseURL doesn't fall back to the mirrors
+	_, _, err = updater.DownloadTarget(info, "", downURL)
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{})
+
+	// Test that the errors of all mirrors are returned if all of them fail,
+	// the simulator serves no data for the URL of the second one
+	updaterConfig.RemoteTargetsMirrorURLs = []string{"https://empty.example.com/mirror"}
+	_, _, err = updater.DownloadTarget(info, "", "")
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{})
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+}
+
+func TestAllowedURLPrefixes(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("target content"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+	targetsURL := filepath.Join(simulator.Sim.LocalDir, "targets")
+
+	// Test a disallowed metadata base URL, including the versioned root URL
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.AllowedURLPrefixes = []string{"https://example.com/tuf"}
+	updater, err := New(updaterConfig)
+	assert.NoError(t, err)
+	err = updater.Refresh()
+	rootURL := fmt.Sprintf("%s/2.root.json", simulator.MetadataDir)
+	assert.ErrorIs(t, err, metadata.ErrDownloadURLNotAllowed{URL: rootURL})
+	assert.ErrorIs(t, err, metadata.ErrDownload{})
+
+	// Test that a prefix only matches whole path segments
+	updaterConfig.AllowedURLPrefixes = []string{simulator.MetadataDir[:len(simulator.MetadataDir)-1]}
+	err = updater.Refresh()
+	assert.ErrorIs(t, err, metadata.ErrDownloadURLNotAllowed{URL: rootURL})
+
+	// Test allowed metadata and target base URLs
+	updaterConfig.AllowedURLPrefixes = []string{simulator.MetadataDir, targetsURL + "/"}
+	updaterConfig.RemoteTargetsURL = targetsURL
+	err = updater.Refresh()
+	assert.NoError(t, err)
+	info, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+	_, data, err := updater.DownloadTarget(info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("target content"), data)
+
+	// Test a disallowed target base URL
+	_, _, err = updater.DownloadTarget(info, "", "https://example.com/targets")
+	assert.ErrorIs(t, err, metadata.ErrDownloadURLNotAllowed{})
+}
+
+func TestValidityRemaining(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	refTime := time.Now().UTC().Truncate(time.Second)
+	simulator.Sim.MDRoot.Signed.Expires = refTime.Add(365 * 24 * time.Hour)
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+	simulator.Sim.MDTargets.Signed.Expires = refTime.Add(7 * 24 * time.Hour)
+	simulator.Sim.MDSnapshot.Signed.Expires = refTime.Add(24 * time.Hour)
+	simulator.Sim.MDTimestamp.Signed.Expires = refTime.Add(time.Hour)
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater, err := New(updaterConfig)
+	assert.NoError(t, err)
+	// only root is trusted before a refresh
+	updater.trusted.RefTime = refTime
+	remaining := updater.ValidityRemaining()
+	assert.Len(t, remaining, 1)
+	assert.Contains(t, remaining, metadata.ROOT)
+
+	_, err = updater.GetTargetInfo("non-existing")
+	assert.Error(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		metadata.ROOT:      365 * 24 * time.Hour,
+		metadata.TARGETS:   7 * 24 * time.Hour,
+		metadata.SNAPSHOT:  24 * time.Hour,
+		metadata.TIMESTAMP: time.Hour,
+	}, updater.ValidityRemaining())
+
+	// expired roles have negative durations
+	updater.trusted.RefTime = refTime.Add(2 * 24 * time.Hour)
+	remaining = updater.ValidityRemaining()
+	assert.Equal(t, -47*time.Hour, remaining[metadata.TIMESTAMP])
+	assert.Equal(t, -24*time.Hour, remaining[metadata.SNAPSHOT])
+	assert.Equal(t, 5*24*time.Hour, remaining[metadata.TARGETS])
+}
+
+func TestRefreshWithReport(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+
+	// steps without their error, which is checked separately
+	stepsOf := func(report *RefreshReport) []RefreshStep {
+		steps := []RefreshStep{}
+		for _, step := range report.Steps {
+			steps = append(steps, RefreshStep{Role: step.Role, Step: step.Step, Version: step.Version})
+		}
+		return steps
+	}
+
+	// Test a download failure
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.AllowedURLPrefixes = []string{"https://example.com/tuf"}
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	report, err := updater.RefreshWithReport()
+	assert.ErrorIs(t, err, metadata.ErrDownloadURLNotAllowed{})
+	assert.Equal(t, err, report.Err)
+	assert.Equal(t, []RefreshStep{{Role: metadata.ROOT, Step: RefreshStepDownload, Version: 2}}, stepsOf(report))
+	assert.ErrorIs(t, report.Failures()[0].Err, metadata.ErrDownloadURLNotAllowed{})
+
+	// Test a successful refresh
+	updaterConfig.AllowedURLPrefixes = nil
+	report, err = updater.RefreshWithReport()
+	assert.NoError(t, err)
+	assert.NoError(t, report.Err)
+	assert.Empty(t, report.Failures())
+	assert.Equal(t, []RefreshStep{
+		{Role: metadata.ROOT, Step: RefreshStepDownload, Version: 2},
+		{Role: metadata.ROOT, Step: RefreshStepVerify, Version: 2},
+		{Role: metadata.ROOT, Step: RefreshStepPersist, Version: 2},
+		{Role: metadata.TIMESTAMP, Step: RefreshStepDownload},
+		{Role: metadata.TIMESTAMP, Step: RefreshStepVerify, Version: 1},
+		{Role: metadata.SNAPSHOT, Step: RefreshStepDownload, Version: 1},
+		{Role: metadata.SNAPSHOT, Step: RefreshStepVerify, Version: 1},
+		{Role: metadata.TARGETS, Step: RefreshStepDownload, Version: 1},
+		{Role: metadata.TARGETS, Step: RefreshStepVerify, Version: 1},
+		{Role: metadata.TIMESTAMP, Step: RefreshStepPersist, Version: 1},
+		{Role: metadata.SNAPSHOT, Step: RefreshStepPersist, Version: 1},
+		{Role: metadata.TARGETS, Step: RefreshStepPersist, Version: 1},
+	}, stepsOf(report))
+
+	// Test a verification failure, reported along with the invalid cached
+	// metadata which doesn't fail the refresh
+	simulator.Sim.MDTargets.Signed.Version += 1
+	simulator.Sim.UpdateSnapshot()
+	simulator.Sim.Signers[metadata.SNAPSHOT] = map[string]*signature.Signer{}
+	simulator.Sim.UpdateSnapshot()
+	err = os.WriteFile(filepath.Join(simulator.MetadataDir, "timestamp.json"), []byte("corrupt"), 0644)
+	assert.NoError(t, err)
+	updater = initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	report, err = updater.RefreshWithReport()
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{})
+	assert.Equal(t, []RefreshStep{
+		{Role: metadata.ROOT, Step: RefreshStepDownload, Version: 2},
+		{Role: metadata.ROOT, Step: RefreshStepVerify, Version: 2},
+		{Role: metadata.ROOT, Step: RefreshStepPersist, Version: 2},
+		{Role: metadata.TIMESTAMP, Step: RefreshStepLoadLocal},
+		{Role: metadata.TIMESTAMP, Step: RefreshStepDownload},
+		{Role: metadata.TIMESTAMP, Step: RefreshStepVerify, Version: 3},
+		{Role: metadata.SNAPSHOT, Step: RefreshStepLoadLocal, Version: 3},
+		{Role: metadata.SNAPSHOT, Step: RefreshStepDownload, Version: 3},
+		{Role: metadata.SNAPSHOT, Step: RefreshStepVerify, Version: 3},
+	}, stepsOf(report))
+	failures := report.Failures()
+	assert.Len(t, failures, 3)
+	assert.Equal(t, metadata.TIMESTAMP, failures[0].Role)
+	assert.Error(t, failures[0].Err)
+	assert.ErrorIs(t, failures[1].Err, metadata.ErrBadVersionNumber{Msg: "expected 3, got 1"})
+	assert.Equal(t, RefreshStep{Role: metadata.SNAPSHOT, Step: RefreshStepVerify, Version: 3, Err: err}, failures[2])
+
+	// Test the report is only collected by RefreshWithReport
+	assert.Nil(t, updater.report)
+}
+
+func TestRefreshWithReportPersistFailure(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+
+	// a directory in place of the cached timestamp can't be overwritten
+	err = os.Mkdir(filepath.Join(simulator.MetadataDir, "timestamp.json"), 0755)
+	assert.NoError(t, err)
+	defer os.RemoveAll(filepath.Join(simulator.MetadataDir, "timestamp.json"))
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	report, err := updater.RefreshWithReport()
+	assert.Error(t, err)
+	failures := report.Failures()
+	assert.Len(t, failures, 1)
+	assert.Equal(t, metadata.TIMESTAMP, failures[0].Role)
+	assert.Equal(t, RefreshStepPersist, failures[0].Step)
+	assert.Equal(t, int64(1), failures[0].Version)
+	assert.Equal(t, err, failures[0].Err)
+	assert.Equal(t, failures[0], report.Steps[len(report.Steps)-1])
+}
+
+func TestVersionedMetadataRetry(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	snapshotName := fmt.Sprintf("/metadata/%d.snapshot.json", simulator.Sim.MDSnapshot.Signed.Version)
+
+	// a CDN-like server which hasn't propagated the versioned snapshot for
+	// the first missingSnapshots requests
+	var missingSnapshots, snapshotRequests atomic.Int64
+	missingSnapshots.Store(2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == snapshotName {
+			if snapshotRequests.Add(1) <= missingSnapshots.Load() {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+		data, err := simulator.Sim.DownloadFile(simulator.Sim.LocalDir+r.URL.Path, 5000000, time.Second)
+		if err != nil || data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	// a fake clock which only advances while backing off, so that the
+	// number of retries doesn't depend on the time the requests take
+	clock := time.Now()
+	retryNow = func() time.Time { return clock }
+	retrySleep = func(d time.Duration) { clock = clock.Add(d) }
+	defer func() {
+		retryNow = time.Now
+		retrySleep = time.Sleep
+	}()
+
+	newUpdater := func(retryTimeout time.Duration) *Updater {
+		updaterConfig, err := config.New(server.URL+"/metadata", simulator.RootBytes)
+		assert.NoError(t, err)
+		updaterConfig.LocalMetadataDir = t.TempDir()
+		updaterConfig.LocalTargetsDir = t.TempDir()
+		updaterConfig.VersionedMetadataRetryTimeout = retryTimeout
+		updater, err := New(updaterConfig)
+		assert.NoError(t, err)
+		return updater
+	}
+
+	// Test the transient 404s are tolerated within the retry timeout
+	updater := newUpdater(5 * time.Second)
+	err = updater.Refresh()
+	assert.NoError(t, err)
+	assert.Equal(t, missingSnapshots.Load()+1, snapshotRequests.Load())
+	assert.NotNil(t, updater.GetTrustedMetadataSet().Targets[metadata.TARGETS])
+
+	// Test the refresh fails once the retry timeout elapses
+	snapshotRequests.Store(0)
+	missingSnapshots.Store(100)
+	updater = newUpdater(150 * time.Millisecond)
+	err = updater.Refresh()
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{StatusCode: http.StatusNotFound, URL: server.URL + snapshotName})
+	assert.Equal(t, int64(3), snapshotRequests.Load())
+
+	// Test there are no retries by default
+	snapshotRequests.Store(0)
+	updater = newUpdater(0)
+	err = updater.Refresh()
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{StatusCode: http.StatusNotFound, URL: server.URL + snapshotName})
+	assert.Equal(t, int64(1), snapshotRequests.Load())
+}
+
+func TestDownloadTargetWithoutLength(t *testing.T) {
+	// Test that a target with hashes but no committed length is downloaded
+	// and verified against its hashes only
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("no length"), "file1.txt")
+	simulator.Sim.TargetFiles["file1.txt"].TargetFile.ClearLength()
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	info, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+	assert.False(t, info.HasLength())
+	_, data, err := updater.DownloadTarget(info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("no length"), data)
+	_, data, err = updater.FindCachedTarget(info, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("no length"), data)
+
+	// the download is capped by TargetFileMaxLength
+	updaterConfig.TargetFileMaxLength = 4
+	_, _, err = updater.DownloadTarget(info, t.TempDir()+"/file1.txt", "")
+	assert.ErrorIs(t, err, metadata.ErrDownload{})
+}
+
+func TestDownloadTargetBytes(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("in memory"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	info, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+
+	// the verified bytes are returned and nothing is cached locally
+	data, err := updater.DownloadTargetBytes(info, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("in memory"), data)
+	cachedPath, _, err := updater.FindCachedTarget(info, "")
+	assert.NoError(t, err)
+	assert.Empty(t, cachedPath)
+
+	// a corrupted download fails verification
+	target := simulator.Sim.TargetFiles["file1.txt"]
+	target.Data = []byte("in mem0ry")
+	simulator.Sim.TargetFiles["file1.txt"] = target
+	_, err = updater.DownloadTargetBytes(info, "")
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{})
+}
+
+// closingFetcher counts the calls to CloseIdleConnections
+type closingFetcher struct {
+	fetcher.Fetcher
+	closed int
+}
+
+func (f *closingFetcher) CloseIdleConnections() {
+	f.closed++
+}
+
+func TestClose(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.MDTimestamp.Signed.Expires = simulator.PastDateTime
+	simulator.Sim.UpdateTimestamp()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	closing := &closingFetcher{Fetcher: simulator.Sim}
+	updaterConfig.Fetcher = closing
+	updater, err := New(updaterConfig)
+	assert.NoError(t, err)
+
+	// Close after a failed refresh
+	err = updater.Refresh()
+	assert.ErrorIs(t, err, metadata.ErrExpiredMetadata{Msg: "timestamp.json is expired"})
+	assert.NoError(t, updater.Close())
+	assert.Nil(t, updater.pending)
+	assert.Equal(t, 1, closing.closed)
+
+	// Close is safe to call multiple times
+	assert.NoError(t, updater.Close())
+	assert.Equal(t, 2, closing.closed)
+
+	// the updater is still usable
+	simulator.Sim.MDTimestamp.Signed.Expires = simulator.Sim.SafeExpiry
+	simulator.Sim.UpdateTimestamp()
+	assert.NoError(t, updater.Refresh())
+	assert.NoError(t, updater.Close())
+
+	// the idle connections of a fetcher wrapped in a budget are closed
+	updaterConfig.Fetcher = fetcher.NewBudgetFetcher(closing, 1000000)
+	assert.NoError(t, updater.Close())
+	assert.Equal(t, 4, closing.closed)
+
+	// a fetcher without idle connections is left alone
+	updaterConfig.Fetcher = simulator.Sim
+	assert.NoError(t, updater.Close())
+	updaterConfig.Fetcher = fetcher.NewBudgetFetcher(simulator.Sim, 1000000)
+	assert.NoError(t, updater.Close())
+}
+
+func TestTimestampConditionalRequests(t *testing.T) {
+	// Test that the timestamp is fetched with a conditional request by the
+	// default fetcher, which answers a 304 Not Modified with the cached bytes
+	// so that the updater verifies them like any other download
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	var timestampDownloads, timestampNotModified atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := simulator.Sim.DownloadFile(simulator.Sim.LocalDir+r.URL.Path, 5000000, time.Second)
+		if err != nil || data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(data))
+		isTimestamp := r.URL.Path == "/metadata/timestamp.json"
+		if r.Header.Get("If-None-Match") == etag {
+			if isTimestamp {
+				timestampNotModified.Add(1)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if isTimestamp {
+			timestampDownloads.Add(1)
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	updaterConfig, err := config.New(server.URL+"/metadata", simulator.RootBytes)
+	assert.NoError(t, err)
+	updaterConfig.LocalMetadataDir = t.TempDir()
+	updaterConfig.LocalTargetsDir = t.TempDir()
+	updaterConfig.Fetcher = &fetcher.DefaultFetcher{}
+	refresh := func() *Updater {
+		updater, err := New(updaterConfig)
+		assert.NoError(t, err)
+		assert.NoError(t, updater.Refresh())
+		return updater
+	}
+
+	updater := refresh()
+	assert.Equal(t, int64(1), updater.GetTrustedMetadataSet().Timestamp.Signed.Version)
+	assert.Equal(t, int64(1), timestampDownloads.Load())
+
+	// the unmodified timestamp is not downloaded again
+	updater = refresh()
+	assert.Equal(t, int64(1), updater.GetTrustedMetadataSet().Timestamp.Signed.Version)
+	assert.Equal(t, int64(1), timestampDownloads.Load())
+	assert.Equal(t, int64(1), timestampNotModified.Load())
+
+	// a new timestamp is downloaded
+	simulator.Sim.UpdateSnapshot()
+	updater = refresh()
+	assert.Equal(t, int64(2), updater.GetTrustedMetadataSet().Timestamp.Signed.Version)
+	assert.Equal(t, int64(2), timestampDownloads.Load())
+}
+
+func TestRefreshDownloadBudget(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		simulator.Sim.MDRoot.Signed.Version += 1
+		simulator.Sim.PublishRoot()
+	}
+
+	// the root versions and top-level metadata exceed the budget together
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	budget := fetcher.NewBudgetFetcher(simulator.Sim, int64(3*len(simulator.Sim.SignedRoots[0])))
+	updaterConfig.Fetcher = budget
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.ErrorIs(t, err, metadata.ErrDownloadBudgetExceeded{})
+
+	// each call starts with the whole budget
+	budget = fetcher.NewBudgetFetcher(simulator.Sim, 100000)
+	updaterConfig.Fetcher = budget
+	updater, err := runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Greater(t, budget.Used(), int64(0))
+	_, err = updater.GetTargetInfo("missing.txt")
+	assert.EqualError(t, err, "target missing.txt not found")
+	assert.Equal(t, int64(0), budget.Used())
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater, err := runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	data := []byte("atomically written")
+	assertWritten := func(fileName string) {
+		written, err := os.ReadFile(fileName)
+		assert.NoError(t, err)
+		assert.Equal(t, data, written)
+		// no temporary file is left over next to the destination
+		entries, err := os.ReadDir(filepath.Dir(fileName))
+		assert.NoError(t, err)
+		for _, entry := range entries {
+			assert.False(t, strings.HasPrefix(entry.Name(), "tuf_tmp"), entry.Name())
+		}
+	}
+
+	// Test the temporary file is created next to the destination by default
+	fileName := filepath.Join(t.TempDir(), "same-dir.txt")
+	assert.NoError(t, updater.writeFileAtomic(fileName, data))
+	assertWritten(fileName)
+
+	// Test a rename from TempDir on the same device
+	tempDir := t.TempDir()
+	updaterConfig.TempDir = tempDir
+	fileName = filepath.Join(t.TempDir(), "temp-dir.txt")
+	assert.NoError(t, updater.writeFileAtomic(fileName, data))
+	assertWritten(fileName)
+	entries, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Test a rename from TempDir across devices falls back to a copy
+	defer func() { moveFile = MoveFile }()
+	moves := []string{}
+	moveFile = func(source, destination string) error {
+		moves = append(moves, filepath.Dir(source))
+		if filepath.Dir(source) == tempDir {
+			return &os.LinkError{Op: "rename", Old: source, New: destination, Err: syscall.EXDEV}
+		}
+		return MoveFile(source, destination)
+	}
+	destDir := t.TempDir()
+	fileName = filepath.Join(destDir, "cross-device.txt")
+	assert.NoError(t, updater.writeFileAtomic(fileName, data))
+	assertWritten(fileName)
+	assert.Equal(t, []string{tempDir, destDir}, moves)
+	entries, err = os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Test other rename errors are returned and clean up the temporary file
+	moveFile = func(source, destination string) error {
+		return &os.LinkError{Op: "rename", Old: source, New: destination, Err: syscall.EACCES}
+	}
+	err = updater.writeFileAtomic(filepath.Join(destDir, "failed.txt"), data)
+	assert.ErrorIs(t, err, syscall.EACCES)
+	entries, err = os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	_, err = os.Stat(filepath.Join(destDir, "failed.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// forbiddenFetcher answers downloads of missing files with HTTP 403
+// Forbidden instead of 404 Not Found, like some misconfigured buckets
+type forbiddenFetcher struct {
+	fetcher.Fetcher
+}
+
+func (f *forbiddenFetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	data, err := f.Fetcher.DownloadFile(urlPath, maxLength, timeout)
+	var httpErr metadata.ErrDownloadHTTP
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		return nil, metadata.ErrDownloadHTTP{StatusCode: http.StatusForbidden, URL: urlPath}
+	}
+	return data, err
+}
+
+func TestRootForbidden(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.MDRoot.Signed.Version += 1
+	simulator.Sim.PublishRoot()
+
+	// Test a 403 for the next root version is an error by default
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.Fetcher = &forbiddenFetcher{Fetcher: simulator.Sim}
+	_, err = runRefresh(updaterConfig, time.Now())
+	var httpErr metadata.ErrDownloadHTTP
+	assert.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+	assert.Contains(t, httpErr.URL, "3.root.json")
+
+	// Test a 403 can end the root chain like a 404
+	updaterConfig.RootForbiddenAsNotFound = true
+	updater, err := runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), updater.trusted.Root.Signed.Version)
+}
+
+func TestVerifyCachedFile(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	content := []byte("migrated file")
+	filePath := filepath.Join(t.TempDir(), "migrated.txt")
+	assert.NoError(t, os.WriteFile(filePath, content, 0644))
+	digest := sha256.Sum256(content)
+
+	// Test matching supplied hashes
+	data, err := updater.VerifyCachedFile(filePath, metadata.Hashes{"sha256": digest[:]}, int64(len(content)))
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// Test mismatching supplied hashes and length
+	otherDigest := sha256.Sum256([]byte("other file"))
+	_, err = updater.VerifyCachedFile(filePath, metadata.Hashes{"sha256": otherDigest[:]}, int64(len(content)))
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - mismatch for algorithm sha256"})
+	_, err = updater.VerifyCachedFile(filePath, metadata.Hashes{"sha256": digest[:]}, 1)
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: fmt.Sprintf("length verification failed - expected 1, got %d", len(content))})
+	_, err = updater.VerifyCachedFile(filePath, metadata.Hashes{}, int64(len(content)))
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{Msg: "hash verification failed - no hashes to verify against"})
+
+	// Test the configured hash algorithm requirements apply
+	updaterConfig.RequireHashAlgorithms = []string{"sha512"}
+	_, err = updater.VerifyCachedFile(filePath, metadata.Hashes{"sha256": digest[:]}, int64(len(content)))
+	assert.ErrorIs(t, err, metadata.ErrLengthOrHashMismatch{})
+
+	// Test a missing file
+	_, err = updater.VerifyCachedFile(filepath.Join(t.TempDir(), "missing.txt"), metadata.Hashes{"sha256": digest[:]}, int64(len(content)))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// signedURLFetcher records the URLs it is called with and requires the
+// signed query parameter appended by the tests' RewriteURL
+type signedURLFetcher struct {
+	fetcher.Fetcher
+	urls []string
+}
+
+func (f *signedURLFetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	f.urls = append(f.urls, urlPath)
+	unsigned, ok := strings.CutSuffix(urlPath, "?sig=abc")
+	if !ok {
+		return nil, metadata.ErrDownloadHTTP{StatusCode: http.StatusForbidden, URL: urlPath}
+	}
+	return f.Fetcher.DownloadFile(unsigned, maxLength, timeout)
+}
+
+func TestRewriteURL(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	simulator.Sim.AddTarget(metadata.TARGETS, []byte("signed download"), "file1.txt")
+	simulator.Sim.UpdateSnapshot()
+
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	signed := &signedURLFetcher{Fetcher: simulator.Sim}
+	updaterConfig.Fetcher = signed
+	updaterConfig.RemoteTargetsURL = filepath.Join(simulator.Sim.LocalDir, "targets")
+	updaterConfig.RewriteURL = func(url string) (string, error) {
+		return url + "?sig=abc", nil
+	}
+	updater := initUpdater(updaterConfig)
+	if updater == nil {
+		t.Fatal("updater is nil")
+	}
+	// Test the rewritten URLs reach the fetcher for metadata and targets
+	info, err := updater.GetTargetInfo("file1.txt")
+	assert.NoError(t, err)
+	_, data, err := updater.DownloadTarget(info, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("signed download"), data)
+	assert.Contains(t, signed.urls, fmt.Sprintf("%s/metadata/timestamp.json?sig=abc", simulator.Sim.LocalDir))
+	assert.Contains(t, signed.urls, fmt.Sprintf("%s/targets/%s.file1.txt?sig=abc", simulator.Sim.LocalDir, hex.EncodeToString(info.Hashes["sha256"])))
+	for _, url := range signed.urls {
+		assert.True(t, strings.HasSuffix(url, "?sig=abc"), url)
+	}
+
+	// Test rewrite errors fail the download
+	updaterConfig.RewriteURL = func(url string) (string, error) {
+		return "", fmt.Errorf("failed to sign %s", url)
+	}
+	_, _, err = updater.DownloadTarget(info, t.TempDir()+"/file1.txt", "")
+	assert.ErrorContains(t, err, "failed to sign")
+}
+
+func TestSignatureVerificationWorkers(t *testing.T) {
+	err := loadOrResetTrustedRootMetadata()
+	assert.NoError(t, err)
+	updaterConfig, err := loadUpdaterConfig()
+	assert.NoError(t, err)
+	updaterConfig.SignatureVerificationWorkers = 4
+
+	// Test metadata verified concurrently is loaded
+	updater, err := runRefresh(updaterConfig, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, updater.trusted.SignatureVerificationWorkers)
+	assert.Equal(t, int64(1), updater.trusted.Targets[metadata.TARGETS].Signed.Version)
+
+	// Test the verification outcome is the same as when verifying sequentially
+	targetsSigners := simulator.Sim.Signers[metadata.TARGETS]
+	delete(simulator.Sim.Signers, metadata.TARGETS)
+	simulator.Sim.MDTargets.Signed.Version += 1
+	simulator.Sim.UpdateSnapshot()
+	_, err = runRefresh(updaterConfig, time.Now())
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "Verifying targets failed, not enough signatures, got 0, want 1"})
+	simulator.Sim.Signers[metadata.TARGETS] = targetsSigners
+}
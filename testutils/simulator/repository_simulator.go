@@ -44,10 +44,10 @@ package simulator
 // """
 
 import (
-	"bytes"
 	"crypto"
 	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"os"
@@ -55,6 +55,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
@@ -107,6 +108,8 @@ type RepositorySimulator struct {
 	MDTimestamp                    *metadata.Metadata[metadata.TimestampType]
 	MDRoot                         *metadata.Metadata[metadata.RootType]
 	LocalDir                       string
+	// mu serializes fetches so the simulator can serve concurrent clients
+	mu sync.Mutex
 }
 
 // New initializes a RepositorySimulator
@@ -265,11 +268,13 @@ func (rs *RepositorySimulator) PublishRoot() {
 	log.Debugf("published root v%d", rs.MDRoot.Signed.Version)
 }
 
+// lastIndex splits str around the last occurrence of delimiter
 func lastIndex(str string, delimiter string) (string, string, string) {
-	// TODO: check if contained and lengths
-	spl := strings.Split(str, delimiter)
-	res := strings.SplitAfterN(str, delimiter, len(spl)-1)
-	return res[0], delimiter, res[1]
+	i := strings.LastIndex(str, delimiter)
+	if i < 0 {
+		return "", "", str
+	}
+	return str[:i], delimiter, str[i+len(delimiter):]
 }
 
 func partition(s string, delimiter string) (string, string) {
@@ -297,6 +302,8 @@ func partition(s string, delimiter string) (string, string) {
 }
 
 func (rs *RepositorySimulator) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
 	data, err := rs.fetch(urlPath)
 	if err != nil {
 		return data, err
@@ -366,9 +373,9 @@ func (rs *RepositorySimulator) fetch(urlPath string) ([]byte, error) {
 		prefix := ""
 		filename = prefixedFilename
 		if rs.MDRoot.Signed.ConsistentSnapshot && rs.PrefixTargetsWithHash {
-			prefix, filename = partition(prefixedFilename, ".")
+			prefix, filename, _ = strings.Cut(prefixedFilename, ".")
 		}
-		targetPath = filepath.Join(dirParts, sep, filename)
+		targetPath = filepath.ToSlash(filepath.Join(dirParts, sep, filename))
 		target, err := rs.FetchTarget(targetPath, prefix)
 		if err != nil {
 			log.Printf("failed to fetch target: %v", err)
@@ -386,16 +393,16 @@ func (rs *RepositorySimulator) FetchTarget(targetPath string, targetHash string)
 	if !ok {
 		return nil, fmt.Errorf("no target %s", targetPath)
 	}
-	if targetHash != "" && !contains(repoTarget.TargetFile.Hashes, []byte(targetHash)) {
+	if targetHash != "" && !contains(repoTarget.TargetFile.Hashes, targetHash) {
 		return nil, fmt.Errorf("hash mismatch for %s", targetPath)
 	}
 	log.Printf("fetched target %s", targetPath)
 	return repoTarget.Data, nil
 }
 
-func contains(hashes map[string]metadata.HexBytes, targetHash []byte) bool {
+func contains(hashes map[string]metadata.HexBytes, targetHash string) bool {
 	for _, value := range hashes {
-		if bytes.Equal(value, targetHash) {
+		if hex.EncodeToString(value) == targetHash {
 			return true
 		}
 	}
@@ -12,10 +12,15 @@
 package testutils
 
 import (
+	"crypto"
+	"crypto/rsa"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
 )
 
 var (
@@ -93,6 +98,23 @@ func Copy(fromPath string, toPath string) error {
 	return nil
 }
 
+// LoadSignerFromPEMFile loads the unencrypted private key at keyPath, the
+// RSA keys of the keystore are loaded as rsassa-pss-sha256 signers
+func LoadSignerFromPEMFile(keyPath string) (signature.Signer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := cryptoutils.UnmarshalPEMToPrivateKey(data, cryptoutils.SkipPassword)
+	if err != nil {
+		return nil, err
+	}
+	if rsaKey, ok := privateKey.(*rsa.PrivateKey); ok {
+		return signature.LoadRSAPSSSigner(rsaKey, crypto.SHA256, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	}
+	return signature.LoadSigner(privateKey, crypto.SHA256)
+}
+
 func Cleanup() {
 	log.Printf("cleaning temporary directory: %s\n", TempDir)
 	err := os.RemoveAll(TempDir)